@@ -0,0 +1,223 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var pageCollection = "pageArchive"
+
+// The places where the archived pages may be kept
+const (
+	DiskMode   = "disk"
+	GridFSMode = "gridfs"
+)
+
+// gridFSBucket : the bucket of the archived pages when kept in the DB
+const gridFSBucket = "pages"
+
+// Page : a fetched page, whose compressed content is kept once for each content hash
+type Page struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Kind      string             `bson:"kind" json:"kind"`
+	URL       string             `bson:"url" json:"url"`
+	FetchedAt time.Time          `bson:"fetchedAt" json:"fetchedAt"`
+	Hash      string             `bson:"hash" json:"hash"`
+	Size      int64              `bson:"size" json:"size"`
+}
+
+// Store : where the compressed contents are kept, by their hash
+type Store interface {
+	Has(hash string) (bool, error)
+	Put(hash string, content []byte) error
+	Get(hash string) ([]byte, error)
+}
+
+// Archive : the fetched pages, listed in the DB with their contents kept in a store
+type Archive struct {
+	DB    *mongo.Database
+	Store Store
+}
+
+// New : creates the archive of a mode, with the contents in a directory or in a GridFS bucket
+func New(db *mongo.Database, mode, dir string) (*Archive, error) {
+	switch mode {
+	case DiskMode:
+		if dir == "" {
+			return nil, fmt.Errorf("missing archive directory")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return &Archive{DB: db, Store: &DiskStore{Dir: dir}}, nil
+	case GridFSMode:
+		bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(gridFSBucket))
+		if err != nil {
+			return nil, err
+		}
+		return &Archive{DB: db, Store: &GridFSStore{Bucket: bucket}}, nil
+	}
+	return nil, fmt.Errorf("unknown archive mode: %v", mode)
+}
+
+// Hash : the hash that identifies a content
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Save : archives a page fetched from an URL, keeping its content only if not kept yet
+func (a *Archive) Save(ctx context.Context, kind, url string, fetchedAt time.Time, content []byte) (*Page, error) {
+	p := Page{
+		Kind:      kind,
+		URL:       url,
+		FetchedAt: fetchedAt,
+		Hash:      Hash(content),
+		Size:      int64(len(content)),
+	}
+	found, err := a.Store.Has(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(content); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if err := a.Store.Put(p.Hash, buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	res, err := a.DB.Collection(pageCollection).InsertOne(ctx, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.ID, _ = res.InsertedID.(primitive.ObjectID)
+	return &p, nil
+}
+
+// List : reads the archived pages using an filter, the oldest first
+func (a *Archive) List(ctx context.Context, filter bson.M) ([]*Page, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "fetchedAt", Value: 1}})
+	cur, err := a.DB.Collection(pageCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*Page{}, err
+	}
+	defer cur.Close(ctx)
+	pages := []*Page{}
+	for cur.Next(ctx) {
+		var p Page
+		if err := cur.Decode(&p); err != nil {
+			return pages, err
+		}
+		pages = append(pages, &p)
+	}
+	return pages, nil
+}
+
+// Read : reads the content of an archived page
+func (a *Archive) Read(p *Page) ([]byte, error) {
+	compressed, err := a.Store.Get(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// DiskStore : keeps the contents in files of a directory, named by their hash
+type DiskStore struct {
+	Dir string
+}
+
+func (d *DiskStore) path(hash string) string {
+	return filepath.Join(d.Dir, hash[:2], hash+".html.gz")
+}
+
+// Has : checks if a content is kept
+func (d *DiskStore) Has(hash string) (bool, error) {
+	_, err := os.Stat(d.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put : keeps a content, writing it to a temporary file first so it is never partial
+func (d *DiskStore) Put(hash string, content []byte) error {
+	path := d.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get : reads a kept content
+func (d *DiskStore) Get(hash string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(hash))
+}
+
+// GridFSStore : keeps the contents in a GridFS bucket, with their hash as the file ID
+type GridFSStore struct {
+	Bucket *gridfs.Bucket
+}
+
+// Has : checks if a content is kept
+func (g *GridFSStore) Has(hash string) (bool, error) {
+	ctx := context.Background()
+	n, err := g.Bucket.GetFilesCollection().CountDocuments(ctx, bson.M{"_id": hash})
+	return n > 0, err
+}
+
+// Put : keeps a content. A content kept at the same time by another fetch is not an error.
+func (g *GridFSStore) Put(hash string, content []byte) error {
+	err := g.Bucket.UploadFromStreamWithID(hash, hash, bytes.NewReader(content))
+	if models.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// Get : reads a kept content
+func (g *GridFSStore) Get(hash string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := g.Bucket.DownloadToStream(hash, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/transport"
+)
+
+// The ways a device may ask for credentials
+const (
+	NoAuth     = ""
+	BasicAuth  = "basic"
+	DigestAuth = "digest"
+	FormAuth   = "form"
+)
+
+// Default fields of the login forms
+const (
+	defaultUserField     = "username"
+	defaultPasswordField = "password"
+)
+
+// Credentials : how the pages under an URL are authenticated. The password is never part of
+// the configuration: it is read from a file or from an environment variable.
+type Credentials struct {
+	URL          string `json:"url"`
+	Mode         string `json:"mode"`
+	User         string `json:"user"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+	PasswordEnv  string `json:"passwordEnv,omitempty"`
+	// LoginPath, UserField, PasswordField : the form posted for logging in, in the form mode
+	LoginPath     string `json:"loginPath,omitempty"`
+	UserField     string `json:"userField,omitempty"`
+	PasswordField string `json:"passwordField,omitempty"`
+	password      string
+	prefix        *transport.URLPrefix
+}
+
+// Config : the credentials of each target, by the URL prefix of its pages
+type Config struct {
+	Targets []*Credentials `json:"targets"`
+}
+
+// ReadFile : reads the credentials of the targets from a JSON file, loading their passwords
+func ReadFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Config{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	for _, t := range c.Targets {
+		if err := t.load(); err != nil {
+			return nil, fmt.Errorf("credentials of %v: %v", t.URL, err)
+		}
+	}
+	return &c, nil
+}
+
+// load : checks the credentials and reads their password
+func (c *Credentials) load() error {
+	p, err := transport.ParseURLPrefix(c.URL)
+	if err != nil {
+		return err
+	}
+	c.prefix = p
+	switch c.Mode {
+	case NoAuth:
+		return nil
+	case BasicAuth, DigestAuth:
+	case FormAuth:
+		if c.LoginPath == "" {
+			return fmt.Errorf("missing login path")
+		}
+		if c.UserField == "" {
+			c.UserField = defaultUserField
+		}
+		if c.PasswordField == "" {
+			c.PasswordField = defaultPasswordField
+		}
+	default:
+		return fmt.Errorf("unknown mode: %v", c.Mode)
+	}
+	switch {
+	case c.PasswordFile != "":
+		p, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.password = strings.TrimRight(string(p), "\r\n")
+	case c.PasswordEnv != "":
+		p, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return fmt.Errorf("missing environment variable %v", c.PasswordEnv)
+		}
+		c.password = p
+	default:
+		return fmt.Errorf("missing password file or environment variable")
+	}
+	return nil
+}
+
+// match : the credentials of the longest URL prefix of a request, if any
+func (c *Config) match(u *url.URL) *Credentials {
+	prefixes := make([]*transport.URLPrefix, len(c.Targets))
+	for i, t := range c.Targets {
+		prefixes[i] = t.prefix
+	}
+	if i := transport.LongestMatch(prefixes, u); i >= 0 {
+		return c.Targets[i]
+	}
+	return nil
+}
+
+// Transport : authenticates the requests to the configured targets before passing them to the
+// next transport
+type Transport struct {
+	Config *Config
+	Next   http.RoundTripper
+	mutex  sync.Mutex
+	// logging : serializes the logins, so concurrent requests do not log in at once
+	logging sync.Mutex
+	jar     *cookiejar.Jar
+	// digests : the last digest challenge of each target
+	digests map[string]*digestChallenge
+}
+
+// NewTransport : creates the transport of a configuration over another transport
+func NewTransport(c *Config, next http.RoundTripper) *Transport {
+	jar, _ := cookiejar.New(nil)
+	return &Transport{
+		Config:  c,
+		Next:    next,
+		jar:     jar,
+		digests: map[string]*digestChallenge{},
+	}
+}
+
+// RoundTrip : sends a request with the credentials of its target. Digest challenges and
+// expired form sessions are answered once before giving up.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.Config.match(req.URL)
+	if c == nil || c.Mode == NoAuth {
+		return t.Next.RoundTrip(req)
+	}
+	switch c.Mode {
+	case BasicAuth:
+		r := cloneRequest(req)
+		r.SetBasicAuth(c.User, c.password)
+		return t.Next.RoundTrip(r)
+	case DigestAuth:
+		return t.roundTripDigest(c, req)
+	default:
+		return t.roundTripForm(c, req)
+	}
+}
+
+// cloneRequest : a copy of a request that may be changed, as transports must not change them
+func cloneRequest(req *http.Request) *http.Request {
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody != nil {
+		r.Body, _ = req.GetBody()
+	}
+	return r
+}
+
+// canRetry : checks if a request may be sent again
+func canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// digestChallenge : the parameters of a digest challenge, and how many times it was answered
+type digestChallenge struct {
+	params map[string]string
+	count  int
+}
+
+// parseDigestChallenge : reads the parameters of a WWW-Authenticate digest header
+func parseDigestChallenge(header string) (*digestChallenge, bool) {
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return nil, false
+	}
+	params := map[string]string{}
+	for _, p := range splitParams(header[len("digest "):]) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	if params["nonce"] == "" {
+		return nil, false
+	}
+	return &digestChallenge{params: params}, true
+}
+
+// splitParams : splits the comma separated parameters of a header, except inside quotes
+func splitParams(s string) []string {
+	params := []string{}
+	quoted := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, s[start:])
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorization : answers the challenge for a request, following RFC 2617 with MD5
+func (d *digestChallenge) authorization(c *Credentials, req *http.Request) string {
+	d.count++
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(c.User + ":" + d.params["realm"] + ":" + c.password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+	nc := fmt.Sprintf("%08x", d.count)
+	b := make([]byte, 8)
+	rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	fields := []string{
+		fmt.Sprintf(`username="%v"`, c.User),
+		fmt.Sprintf(`realm="%v"`, d.params["realm"]),
+		fmt.Sprintf(`nonce="%v"`, d.params["nonce"]),
+		fmt.Sprintf(`uri="%v"`, uri),
+	}
+	if strings.Contains(d.params["qop"], "auth") {
+		response := md5Hex(strings.Join([]string{ha1, d.params["nonce"], nc, cnonce, "auth", ha2}, ":"))
+		fields = append(fields, "qop=auth", "nc="+nc, fmt.Sprintf(`cnonce="%v"`, cnonce),
+			fmt.Sprintf(`response="%v"`, response))
+	} else {
+		response := md5Hex(ha1 + ":" + d.params["nonce"] + ":" + ha2)
+		fields = append(fields, fmt.Sprintf(`response="%v"`, response))
+	}
+	if opaque, ok := d.params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%v"`, opaque))
+	}
+	if algorithm, ok := d.params["algorithm"]; ok {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	return "Digest " + strings.Join(fields, ", ")
+}
+
+// roundTripDigest : answers the last challenge of the target, or the one of the device when
+// there is none or it became stale
+func (t *Transport) roundTripDigest(c *Credentials, req *http.Request) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		r := cloneRequest(req)
+		t.mutex.Lock()
+		if d, ok := t.digests[c.URL]; ok {
+			r.Header.Set("Authorization", d.authorization(c, r))
+		}
+		t.mutex.Unlock()
+		return t.Next.RoundTrip(r)
+	}
+	res, err := send()
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !canRetry(req) {
+		return res, err
+	}
+	d, ok := parseDigestChallenge(res.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return res, nil
+	}
+	res.Body.Close()
+	t.mutex.Lock()
+	t.digests[c.URL] = d
+	t.mutex.Unlock()
+	return send()
+}
+
+// needsLogin : checks if an answer asks for logging in, by refusing the request or by
+// redirecting to the login page
+func needsLogin(c *Credentials, res *http.Response) bool {
+	if res.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	if res.StatusCode < 300 || res.StatusCode >= 400 {
+		return false
+	}
+	loc, err := res.Location()
+	return err == nil && strings.TrimRight(loc.Path, "/") == strings.TrimRight(c.LoginPath, "/")
+}
+
+// roundTripForm : sends a request with the session cookies of the target, logging in again
+// when the session is missing or expired
+func (t *Transport) roundTripForm(c *Credentials, req *http.Request) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		r := cloneRequest(req)
+		for _, ck := range t.jar.Cookies(r.URL) {
+			if _, err := r.Cookie(ck.Name); err != nil {
+				r.AddCookie(ck)
+			}
+		}
+		res, err := t.Next.RoundTrip(r)
+		if err == nil {
+			t.jar.SetCookies(r.URL, res.Cookies())
+		}
+		return res, err
+	}
+	res, err := send()
+	if err != nil || !needsLogin(c, res) || !canRetry(req) {
+		return res, err
+	}
+	res.Body.Close()
+	t.logging.Lock()
+	err = t.login(c, req)
+	t.logging.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return send()
+}
+
+// login : posts the login form of the target, keeping the session cookies
+func (t *Transport) login(c *Credentials, req *http.Request) error {
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	loginURL, err := base.Parse(c.LoginPath)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set(c.UserField, c.User)
+	form.Set(c.PasswordField, c.password)
+	body := []byte(form.Encode())
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodPost, loginURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := t.Next.RoundTrip(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 || needsLogin(c, res) {
+		return fmt.Errorf("login to %v failed: %v", c.URL, res.Status)
+	}
+	t.jar.SetCookies(loginURL, res.Cookies())
+	return nil
+}
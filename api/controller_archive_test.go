@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestArchiveAndReprocess(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.DB.Collection("pageArchive").Drop(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Archives in a temporary directory
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		log.Fatalf("Error creating the archive directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := s.SetArchive("disk", dir); err != nil {
+		log.Fatalf("Error configuring the archive: %v", err)
+	}
+	if err := s.InitializeArchive(); err != nil {
+		log.Fatalf("Error opening the archive: %v", err)
+	}
+	defer func() {
+		s.SetArchive("", "")
+		s.InitializeArchive()
+	}()
+	// Archives the same page twice, which keeps its content once
+	content, _ := ioutil.ReadFile("./tests/assets/telemetry-data/index.html")
+	for i := 0; i < 2; i++ {
+		if _, err := s.Archive.Save(ctx, models.TelemetryDataKind, "http://localhost/telemetry-data/", time.Now().UTC(), content); err != nil {
+			t.Errorf("Error while archiving the page: %v\n", err)
+			return
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.html.gz"))
+	assert.Equal(t, 1, len(files))
+	pages, _ := s.Archive.List(ctx, bson.M{})
+	assert.Equal(t, 2, len(pages))
+	// Rebuilds the telemetry data from the archived pages
+	n, err := s.Reprocess(ctx, models.TelemetryDataKind, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("Error while reprocessing: %v\n", err)
+		return
+	}
+	assert.Equal(t, 2, n)
+	data, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 1, len(data))
+	assert.Equal(t, "11F3EF00-F3", data[0].Module)
+}
+
+func TestReprocessInverterPages(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshPerformanceCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshEnergyCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.DB.Collection("pageArchive").Drop(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		log.Fatalf("Error creating the archive directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := s.SetArchive("disk", dir); err != nil {
+		log.Fatalf("Error configuring the archive: %v", err)
+	}
+	if err := s.InitializeArchive(); err != nil {
+		log.Fatalf("Error opening the archive: %v", err)
+	}
+	defer func() {
+		s.SetArchive("", "")
+		s.InitializeArchive()
+	}()
+	// A bad parse kept counters larger than the ones in the page
+	fetched := time.Date(2020, 9, 13, 12, 0, 0, 0, time.Local)
+	at := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	if err := models.RecordInverterEnergy(ctx, s.DB, "7E1504FE-95", at, 5.0); err != nil {
+		t.Errorf("Error while recording energy: %v\n", err)
+		return
+	}
+	if _, err := models.AppendEnergyLedger(ctx, s.DB, "7E1504FE-95", at.Unix(), 80.0, 900); err != nil {
+		t.Errorf("Error while appending to the ledger: %v\n", err)
+		return
+	}
+	content, _ := ioutil.ReadFile("./tests/assets/inverter/index.html")
+	if _, err := s.Archive.Save(ctx, models.InverterKind, "http://localhost/inverter/", fetched.UTC(), content); err != nil {
+		t.Errorf("Error while archiving the page: %v\n", err)
+		return
+	}
+	n, err := s.Reprocess(ctx, models.InverterKind, fetched.Add(-time.Hour), fetched.Add(time.Hour))
+	if err != nil {
+		t.Errorf("Error while reprocessing: %v\n", err)
+		return
+	}
+	assert.Equal(t, 1, n)
+	// The lower counters of the page replace the stored ones
+	p := models.DailyPerformance{Scope: models.InverterScope, Name: "7E1504FE-95", Day: at}
+	if assert.NoError(t, p.ReadDailyPerformance(ctx, s.DB)) {
+		assert.InDelta(t, 1.56, p.Energy, 1e-9)
+	}
+	entries, _ := models.ListEnergyLedger(ctx, s.DB, bson.M{"serial": "7E1504FE-95"})
+	if assert.Equal(t, 1, len(entries)) {
+		assert.InDelta(t, 8.49, entries[0].Counter, 1e-9)
+	}
+	i := models.Inverter{Serial: "7E1504FE-95"}
+	if assert.NoError(t, i.ReadInverter(ctx, s.DB)) {
+		assert.InDelta(t, 1.56, i.EnergyToday, 1e-9)
+	}
+	daily, _ := models.ListEnergyDaily(ctx, s.DB, bson.M{"serial": "7E1504FE-95"})
+	if assert.Equal(t, 1, len(daily)) {
+		assert.InDelta(t, 1.56, daily[0].DeviceEnergy, 1e-9)
+	}
+	// The live inverter is not rolled back to the archived read
+	i.EnergyToday = 3.0
+	if _, err := i.UpsertInverterInDB(ctx, s.DB); err != nil {
+		t.Errorf("Error while updating the inverter: %v\n", err)
+		return
+	}
+	if _, err := s.Reprocess(ctx, models.InverterKind, fetched.Add(-time.Hour), fetched.Add(time.Hour)); err != nil {
+		t.Errorf("Error while reprocessing: %v\n", err)
+		return
+	}
+	if assert.NoError(t, i.ReadInverter(ctx, s.DB)) {
+		assert.InDelta(t, 3.0, i.EnergyToday, 1e-9)
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDeviceUser     = "admin"
+	testDevicePassword = "s3cret"
+	testDeviceRealm    = "SetApp"
+	testDeviceNonce    = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+)
+
+// serveInverterPage : answers with the inverter test page
+func serveInverterPage(w http.ResponseWriter) {
+	content, err := ioutil.ReadFile("./tests/assets/inverter/index.html")
+	if err != nil {
+		log.Fatalf("Error reading the test page: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(content)
+}
+
+func testMD5(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkDigest : verifies the digest authorization of a request
+func checkDigest(r *http.Request) bool {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Digest ") {
+		return false
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(h[len("Digest "):], ", ") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	ha1 := testMD5(testDeviceUser + ":" + testDeviceRealm + ":" + testDevicePassword)
+	ha2 := testMD5(r.Method + ":" + params["uri"])
+	expected := testMD5(ha1 + ":" + testDeviceNonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	return params["username"] == testDeviceUser && params["response"] == expected
+}
+
+// authDevice : a device that serves the inverter page behind an authentication mode
+func authDevice(mode string) *httptest.Server {
+	sessions := map[string]bool{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("username") != testDeviceUser ||
+			r.FormValue("password") != testDevicePassword {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		id := fmt.Sprintf("session%v", len(sessions)+1)
+		sessions[id] = true
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: id, Path: "/"})
+	})
+	mux.HandleFunc("/inverter/", func(w http.ResponseWriter, r *http.Request) {
+		switch mode {
+		case "basic":
+			if u, p, ok := r.BasicAuth(); !ok || u != testDeviceUser || p != testDevicePassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "digest":
+			if !checkDigest(r) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%v", qop="auth", nonce="%v", opaque="5ccc069c"`,
+					testDeviceRealm, testDeviceNonce))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "form":
+			c, err := r.Cookie("session")
+			if err != nil || !sessions[c.Value] {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+			// Each session is valid only once, so the next visit logs in again
+			delete(sessions, c.Value)
+		}
+		serveInverterPage(w)
+	})
+	return httptest.NewServer(mux)
+}
+
+// useAuth : authenticates the collectors with credentials for an URL, in a mode
+func useAuth(dir, url, mode string) error {
+	file := filepath.Join(dir, "auth.json")
+	content := fmt.Sprintf(`{"targets": [{"url": "%v", "mode": "%v", "user": "%v",
+		"passwordEnv": "TEST_DEVICE_PASSWORD", "loginPath": "/login"}]}`, url, mode, testDeviceUser)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := s.SetAuth(file); err != nil {
+		return err
+	}
+	s.ConfigureTransport()
+	return nil
+}
+
+func TestAuthenticatedInverterAcquisition(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		log.Fatalf("Error creating the auth directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// Visits the other tests anonymously again
+	defer func() {
+		s.SetAuth("")
+		s.ConfigureTransport()
+	}()
+	for _, mode := range []string{"basic", "digest", "form"} {
+		// Removes all data in the collection
+		if err := s.RefreshInverterCollection(ctx); err != nil {
+			log.Fatalf("Error refreshing the DB: %v", err)
+		}
+		device := authDevice(mode)
+		iURL := device.URL + "/inverter/"
+		// With a wrong password nothing is stored
+		os.Setenv("TEST_DEVICE_PASSWORD", "wrong")
+		if err := useAuth(dir, device.URL+"/", mode); err != nil {
+			t.Errorf("Error while configuring the %v authentication: %v\n", mode, err)
+			device.Close()
+			continue
+		}
+		assert.Error(t, s.InverterCollector.Visit(iURL), mode)
+		invs, _ := models.ListInverters(ctx, s.DB)
+		assert.Equal(t, 0, len(invs), mode)
+		// With the right one the inverter is stored, twice for renewing the sessions
+		os.Setenv("TEST_DEVICE_PASSWORD", testDevicePassword)
+		if err := useAuth(dir, device.URL+"/", mode); err != nil {
+			t.Errorf("Error while configuring the %v authentication: %v\n", mode, err)
+			device.Close()
+			continue
+		}
+		for n := 0; n < 2; n++ {
+			assert.NoError(t, s.InverterCollector.Visit(iURL), mode)
+		}
+		invs, _ = models.ListInverters(ctx, s.DB)
+		assert.Equal(t, 1, len(invs), mode)
+		device.Close()
+	}
+}
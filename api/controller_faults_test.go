@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/controllers"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tests"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// faultCase : a fault injected in a route and the error expected from the scrape
+type faultCase struct {
+	name  string
+	fault tests.Fault
+	// fetchFails : if the visit itself fails, instead of the parsing
+	fetchFails bool
+	err        string
+}
+
+// truncateBefore : the bytes of a test page before a text
+func truncateBefore(asset, text string) int {
+	content, err := ioutil.ReadFile(asset)
+	if err != nil {
+		log.Fatalf("Error reading the test page: %v", err)
+	}
+	return strings.Index(string(content), text)
+}
+
+// scrapeStatus : the outcome of the visits to an URL
+func scrapeStatus(url string) (controllers.ScrapeStatus, bool) {
+	for _, st := range s.ScrapeStatuses() {
+		if st.URL == url {
+			return st, true
+		}
+	}
+	return controllers.ScrapeStatus{}, false
+}
+
+func TestInverterFaults(t *testing.T) {
+	ctx := context.Background()
+	defer ts.ClearFaults()
+	defer s.InverterCollector.SetRequestTimeout(10 * time.Second)
+	s.InverterCollector.SetRequestTimeout(500 * time.Millisecond)
+	iURL := fmt.Sprintf("http://%v:%v/%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"), s.InverterPaths[0])
+	cases := []faultCase{
+		{"internal error", tests.Fault{Status: http.StatusInternalServerError}, true, "Internal Server Error"},
+		{"timeout", tests.Fault{Delay: time.Second}, true, "Timeout"},
+		{"truncated", tests.Fault{Truncate: truncateBefore("./tests/assets/inverter/index.html", "Energia do Inversor")}, false, "missing"},
+		{"classes", tests.Fault{Classes: map[string]string{"grey-strong-class": "label-strong"}}, false, "missing"},
+		{"garbage", tests.Fault{Garbage: true}, false, "invalid Potência"},
+	}
+	for _, fc := range cases {
+		// Removes all data in the collections
+		if err := s.RefreshInverterCollection(ctx); err != nil {
+			log.Fatalf("Error refreshing the DB: %v", err)
+		}
+		if err := s.RefreshEnergyCollections(ctx); err != nil {
+			log.Fatalf("Error refreshing the DB: %v", err)
+		}
+		ts.SetFault("inverter", fc.fault)
+		err := s.InverterCollector.Visit(iURL)
+		assert.Equal(t, fc.fetchFails, err != nil, fc.name)
+		// Checks that nothing was stored
+		invs, _ := models.ListInverters(ctx, s.DB)
+		assert.Equal(t, 0, len(invs), fc.name)
+		ledger, _ := models.ListEnergyLedger(ctx, s.DB, bson.M{})
+		assert.Equal(t, 0, len(ledger), fc.name)
+		// Checks that the error was surfaced
+		st, ok := scrapeStatus(iURL)
+		if assert.True(t, ok, fc.name) {
+			assert.Equal(t, models.InverterKind, st.Kind)
+			assert.Less(t, int64(0), st.Failures, fc.name)
+			assert.Contains(t, st.LastError, fc.err, fc.name)
+		}
+	}
+	// Without faults the inverter is stored and the failures are forgotten
+	ts.ClearFaults()
+	if err := s.InverterCollector.Visit(iURL); err != nil {
+		t.Errorf("Error while visiting the inverter: %v\n", err)
+		return
+	}
+	invs, _ := models.ListInverters(ctx, s.DB)
+	assert.Equal(t, 1, len(invs))
+	st, _ := scrapeStatus(iURL)
+	assert.Equal(t, int64(0), st.Failures)
+}
+
+func TestTelemetryDataFaults(t *testing.T) {
+	ctx := context.Background()
+	defer ts.ClearFaults()
+	tURL := fmt.Sprintf("http://%v:%v/%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"), s.TelemetryPaths[0])
+	cases := []faultCase{
+		{"unavailable", tests.Fault{Status: http.StatusServiceUnavailable}, true, "Service Unavailable"},
+		{"truncated", tests.Fault{Truncate: truncateBefore("./tests/assets/telemetry-data/index.html", "Tensão de Saída")}, false, "missing"},
+		{"classes", tests.Fault{Classes: map[string]string{"col-5 setting-font text-right": "col-5 setting"}}, false, "missing"},
+		{"garbage", tests.Fault{Garbage: true}, false, "invalid Tensão de Entrada"},
+	}
+	// Removes all data in the collection
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	for _, fc := range cases {
+		ts.SetFault("telemetry-data", fc.fault)
+		err := s.TelemetryCollector.Visit(tURL)
+		assert.Equal(t, fc.fetchFails, err != nil, fc.name)
+		st, ok := scrapeStatus(tURL)
+		if assert.True(t, ok, fc.name) {
+			assert.Equal(t, models.TelemetryDataKind, st.Kind)
+			assert.Less(t, int64(0), st.Failures, fc.name)
+			assert.Contains(t, st.LastError, fc.err, fc.name)
+		}
+	}
+	// Lets the telemetry writer flush, then checks that nothing was stored
+	time.Sleep(2 * time.Second)
+	data, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 0, len(data))
+	rejected, _ := models.ListRejectedData(ctx, s.DB, bson.M{"kind": models.TelemetryDataKind, "serial": ""})
+	assert.Equal(t, 0, len(rejected))
+}
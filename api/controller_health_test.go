@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/controllers"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// readiness : asks the readiness of the service
+func readiness() (int, controllers.Readiness) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	s.Router.ServeHTTP(w, req)
+	r := controllers.Readiness{}
+	json.Unmarshal(w.Body.Bytes(), &r)
+	return w.Code, r
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	// The process is alive
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	// Scrapes only the static server inverter, allowing two missed periods
+	defer s.SetReadiness(os.Getenv("READY_MISSED_PERIODS"))
+	s.SetReadiness("2")
+	static := fmt.Sprintf("http://%v:%v/%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"), s.InverterPaths[0])
+	previous := replaceTargets(models.Target{Name: "static", URL: static, Kind: models.InverterKind, Period: 1})
+	defer replaceTargets(previous...)
+	c := make(chan bool)
+	go s.Acquisition(c)
+	defer func() { c <- true }()
+	time.Sleep(2 * time.Second)
+	code, r := readiness()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, r.Ready)
+	for _, dep := range []string{"mongo", "collectors", "scrapes"} {
+		assert.Equal(t, true, r.Checks[dep].OK, dep)
+	}
+	// A target that is never scraped makes the service not ready after its periods
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	if err := s.AddTarget(models.Target{Name: "down", URL: down.URL + "/inverter/", Kind: models.InverterKind,
+		Period: 1}); err != nil {
+		t.Errorf("Error while adding a target: %v\n", err)
+		return
+	}
+	// The periods of a target added at runtime are counted from when it is added
+	time.Sleep(1 * time.Second)
+	code, _ = readiness()
+	assert.Equal(t, http.StatusOK, code)
+	time.Sleep(3 * time.Second)
+	code, r = readiness()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, false, r.Ready)
+	assert.Equal(t, true, r.Checks["mongo"].OK)
+	assert.Equal(t, true, r.Checks["collectors"].OK)
+	assert.Equal(t, []string{"down"}, r.Checks["scrapes"].Stale)
+	// Paused targets are not expected to be scraped
+	s.PauseTarget("down", true)
+	code, _ = readiness()
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -2,9 +2,7 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"os"
 	"testing"
 	"time"
 
@@ -18,22 +16,21 @@ func TestInverterAcquisition(t *testing.T) {
 	if err := s.RefreshInverterCollection(ctx); err != nil {
 		log.Fatalf("Error refreshing the DB: %v", err)
 	}
-	// Lets the inverter acquisition run for 5s
-	baseURL := fmt.Sprintf("http://%v:%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"))
+	// Lets the acquisition of the targets run for 5s
 	c := make(chan bool)
-	go s.InverterAcquisition(baseURL, 1, c)
+	go s.Acquisition(c)
 	time.Sleep(5 * time.Second)
 	c <- true
 	// Verifies the inverter in DB
 	i := models.Inverter{
 		Serial: "7E1504FE-95",
 	}
-	err := i.ReadInverter(s.DB)
+	err := i.ReadInverter(ctx, s.DB)
 	if err != nil {
 		t.Errorf("Error while reading inverter in DB: %v\n", err)
 		return
 	}
 	// Checks if the DB has repeated inverters
-	invs, _ := models.ListInverters(s.DB)
+	invs, _ := models.ListInverters(ctx, s.DB)
 	assert.Equal(t, 1, len(invs))
 }
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/seed"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLoadLayoutFile(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshLayoutCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Loads the layout twice, which should not repeat sites or strings
+	for i := 0; i < 2; i++ {
+		if err := s.LoadLayoutFile(ctx, "./tests/assets/layout.json"); err != nil {
+			t.Errorf("Error while loading the layout: %v\n", err)
+			return
+		}
+	}
+	sites, _ := models.ListSites(ctx, s.DB)
+	assert.Equal(t, 1, len(sites))
+	strs, _ := models.ListStringsOfSite(ctx, s.DB, "CPID")
+	assert.Equal(t, 1, len(strs))
+}
+
+func TestReadInvalidLayoutFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "layout-*.json")
+	if err != nil {
+		log.Fatalf("Error creating the layout file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"sites": [`)
+	f.Close()
+	_, err = models.ReadLayoutFile(f.Name())
+	assert.True(t, errors.Is(err, models.ErrInvalid), err)
+}
+
+func TestTelemetryDataBySiteAndString(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshLayoutCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Seeds the collections for testing data
+	if err := s.LoadLayoutFile(ctx, "./tests/assets/layout.json"); err != nil {
+		log.Fatalf("Error loading the layout: %v", err)
+	}
+	if err := seed.LoadTelemetryData(s.DB); err != nil {
+		log.Fatalf("Error seeding the DB: %v", err)
+	}
+	// The site has two of the three seeded inverters, the string only one
+	counts := map[string]int{
+		"/sites/CPID/telemetry":               200,
+		"/sites/CPID/telemetry?from=0&to=300": 2,
+		"/strings/CPID-A/telemetry":           100,
+	}
+	for url, count := range counts {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		s.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		data := []models.TelemetryData{}
+		json.Unmarshal(w.Body.Bytes(), &data)
+		assert.Equal(t, count, len(data), url)
+	}
+	// Unknown sites are not found
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/sites/UNKNOWN/telemetry", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPutAndDeleteSite(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshLayoutCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Creates and then updates a site
+	codes := []int{}
+	for _, body := range []string{`{"peakPower": 10}`, `{"peakPower": 12}`} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/sites/NEW", strings.NewReader(body))
+		s.Router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusCreated, http.StatusOK}, codes)
+	st := models.Site{Name: "NEW"}
+	st.ReadSite(ctx, s.DB)
+	assert.Equal(t, 12.0, st.PeakPower)
+	// Deletes the site twice
+	codes = []int{}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/sites/NEW", nil)
+		s.Router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusNoContent, http.StatusNotFound}, codes)
+}
+
+func TestPutStringInSites(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshLayoutCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// The same name is taken by a string in each site, where the id in the body is ignored
+	codes := []int{}
+	for _, body := range []string{
+		`{"site": "EAST", "peakPower": 5}`,
+		`{"site": "WEST", "peakPower": 6}`,
+		`{"id": "5f5e100000000000000000aa", "site": "WEST", "peakPower": 7}`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/strings/A", strings.NewReader(body))
+		s.Router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated, http.StatusOK}, codes)
+	strs, _ := models.ListStrings(ctx, s.DB, bson.M{"name": "A"})
+	assert.Equal(t, 2, len(strs))
+	// The site tells which string is read
+	for site, power := range map[string]float64{"EAST": 5, "WEST": 7} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/strings/A?site="+site, nil)
+		s.Router.ServeHTTP(w, req)
+		str := models.String{}
+		json.Unmarshal(w.Body.Bytes(), &str)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, power, str.PeakPower, site)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/controllers"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// writeCapture : copies a test page to a replay directory as captured at a Unix time
+func writeCapture(dir, path, asset string, at int64) {
+	content, err := ioutil.ReadFile(asset)
+	if err != nil {
+		log.Fatalf("Error reading the test page: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+		log.Fatalf("Error creating the replay directory: %v", err)
+	}
+	file := filepath.Join(dir, path, fmt.Sprintf("%v.html", at))
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		log.Fatalf("Error writing the captured page: %v", err)
+	}
+}
+
+func TestReplayCapturedPages(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshEnergyCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Captures the inverter page twice and the telemetry page once
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		log.Fatalf("Error creating the replay directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeCapture(dir, "inverter", "./tests/assets/inverter/index.html", 1598445000)
+	writeCapture(dir, "telemetry-data", "./tests/assets/telemetry-data/index.html", 1598445100)
+	writeCapture(dir, "inverter", "./tests/assets/inverter/index.html", 1598445200)
+	pages, err := controllers.ReadReplayDir(dir)
+	if err != nil {
+		t.Errorf("Error while reading the replay directory: %v\n", err)
+		return
+	}
+	assert.Equal(t, 3, len(pages))
+	assert.Equal(t, "telemetry-data", pages[1].Path)
+	// Replays as fast as possible
+	if err := s.Replay(dir, 0, make(chan bool)); err != nil {
+		t.Errorf("Error while replaying: %v\n", err)
+		return
+	}
+	// Lets the telemetry writer flush
+	time.Sleep(2 * time.Second)
+	// Verifies the data in DB, read at the capture times
+	inverters, _ := models.ListInverters(ctx, s.DB)
+	assert.Equal(t, 1, len(inverters))
+	data, _ := models.ListTelemetryData(ctx, s.DB, bson.M{"serial": "7E1504FE-95"})
+	assert.Equal(t, 1, len(data))
+	ledger, _ := models.ListEnergyLedger(ctx, s.DB, bson.M{})
+	assert.Equal(t, 2, len(ledger))
+	if len(ledger) == 2 {
+		assert.Equal(t, int64(200), ledger[1].End-ledger[1].Start)
+	}
+}
+
+func TestReplayDiscoveryPage(t *testing.T) {
+	// Captures the discovery page
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		log.Fatalf("Error creating the replay directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeCapture(dir, s.DiscoveryPaths[0], "./tests/assets/telemetry-index/index.html", 1598445000)
+	if err := s.Replay(dir, 0, make(chan bool)); err != nil {
+		t.Errorf("Error while replaying: %v\n", err)
+		return
+	}
+	// The pages found are named after the discovery target, so they take its labels
+	sources := []models.Target{}
+	for _, target := range s.Targets() {
+		if target.Kind == models.DiscoveryKind {
+			target.Labels = map[string]string{"site": "north"}
+		}
+		sources = append(sources, target)
+	}
+	discovered := s.DiscoveredTelemetry(sources)
+	if assert.Equal(t, 1, len(discovered)) {
+		assert.Equal(t, "north", discovered[0].Labels["site"])
+	}
+	// Replays a page without links, so the pages found vanish for the other tests
+	writeCapture(dir, s.DiscoveryPaths[0], "./tests/assets/inverter/index.html", 1598445100)
+	if err := s.Replay(dir, 0, make(chan bool)); err != nil {
+		t.Errorf("Error while replaying: %v\n", err)
+	}
+	assert.Equal(t, 0, len(s.DiscoveredTelemetry(s.Targets())))
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCappedCollectionMigration(t *testing.T) {
+	ctx := context.Background()
+	// Brings back an inverter collection with the old capped layout
+	if err := s.DB.Collection("inverters").Drop(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	create := bson.D{
+		{Key: "create", Value: "inverters"},
+		{Key: "capped", Value: true},
+		{Key: "size", Value: 1 << 20},
+	}
+	if err := s.DB.RunCommand(ctx, create).Err(); err != nil {
+		log.Fatalf("Error creating the capped collection: %v", err)
+	}
+	for _, serial := range []string{"INVERTER1", "INVERTER2", "INVERTER3"} {
+		if _, err := s.DB.Collection("inverters").InsertOne(ctx, models.Inverter{Serial: serial}); err != nil {
+			log.Fatalf("Error adding the inverters: %v", err)
+		}
+	}
+	if err := s.MigrateCappedCollection(ctx, "inverters", s.SetupInverterCollection); err != nil {
+		t.Errorf("Error while migrating the collection: %v\n", err)
+		return
+	}
+	// The documents are kept in a collection that is no longer capped
+	cur, err := s.DB.ListCollections(ctx, bson.M{"name": "inverters"})
+	if err != nil {
+		log.Fatalf("Error listing the collections: %v", err)
+	}
+	var info struct {
+		Options struct {
+			Capped bool `bson:"capped"`
+		} `bson:"options"`
+	}
+	if assert.True(t, cur.Next(ctx)) {
+		assert.NoError(t, cur.Decode(&info))
+		assert.False(t, info.Options.Capped)
+	}
+	cur.Close(ctx)
+	invs, _ := models.ListInverters(ctx, s.DB)
+	assert.Equal(t, 3, len(invs))
+	colls, _ := s.DB.ListCollectionNames(ctx, bson.M{"name": "invertersMigrating"})
+	assert.Equal(t, 0, len(colls))
+	// The new collection has the indexes of its setup
+	i := models.Inverter{Serial: "INVERTER1"}
+	_, err = i.AddInverterToDB(ctx, s.DB)
+	assert.True(t, errors.Is(err, models.ErrDuplicate), err)
+	// Migrating again changes nothing
+	assert.NoError(t, s.MigrateCappedCollection(ctx, "inverters", s.SetupInverterCollection))
+	invs, _ = models.ListInverters(ctx, s.DB)
+	assert.Equal(t, 3, len(invs))
+}
+
+func TestRetentionTooLong(t *testing.T) {
+	ctx := context.Background()
+	defer s.SetRetention(os.Getenv("TELEMETRY_RETENTION_DAYS"), os.Getenv("ROLLUP_RETENTION_DAYS"))
+	// The TTL indexes take the seconds in 32 bits, even when the days overflow a duration
+	for _, days := range []string{"30000", "1000000"} {
+		s.SetRetention("", days)
+		assert.Error(t, s.ApplyRetentionPolicies(ctx), days)
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/simulator"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// startSimulator : serves a simulated device of a model, with its clock stopped at a time
+func startSimulator(m *simulator.Model, now time.Time) (*simulator.Simulator, *httptest.Server) {
+	sim, err := simulator.New(m)
+	if err != nil {
+		log.Fatalf("Error creating the simulator: %v", err)
+	}
+	sim.Now = func() time.Time { return now }
+	return sim, httptest.NewServer(sim.Router)
+}
+
+func TestSimulatedInverterAcquisition(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	m := simulator.DefaultModel()
+	m.Inverters = 2
+	m.Faults = []simulator.Fault{
+		{Kind: simulator.FaultDerate, Inverter: 2, Factor: 0.5},
+	}
+	noon := time.Date(2020, time.August, 26, 12, 0, 0, 0, time.Local)
+	sim, srv := startSimulator(m, noon)
+	defer srv.Close()
+	// Visits both inverters, where the second one is derated
+	s.InverterCollector.Visit(srv.URL + "/inverter/")
+	s.InverterCollector.Visit(srv.URL + "/inverter/2/")
+	// Verifies the inverters in DB
+	for n, power := range map[int]float64{1: 10, 2: 5} {
+		i := models.Inverter{Serial: sim.Model.InverterSerial(n)}
+		if err := i.ReadInverter(ctx, s.DB); err != nil {
+			t.Errorf("Error while reading inverter %v in DB: %v\n", n, err)
+			continue
+		}
+		assert.Equal(t, power, i.Power)
+		assert.Equal(t, true, i.Status)
+		assert.InDelta(t, sim.Model.Inverter(n, noon).EnergyToday, i.EnergyToday, 0.01)
+	}
+}
+
+func TestSimulatedTelemetryAcquisition(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	m := simulator.DefaultModel()
+	m.Faults = []simulator.Fault{
+		{Kind: simulator.FaultHTTP, Inverter: 1, Module: 3, Status: 503},
+	}
+	noon := time.Date(2020, time.August, 26, 12, 0, 0, 0, time.Local)
+	sim, srv := startSimulator(m, noon)
+	defer srv.Close()
+	// Visits all the modules, where the third one fails
+	for _, path := range []string{"/telemetry-data/", "/telemetry-data/2/", "/telemetry-data/3/"} {
+		s.TelemetryCollector.Visit(srv.URL + path)
+	}
+	// Lets the telemetry writer flush
+	time.Sleep(2 * time.Second)
+	// Verifies the telemetry data in DB
+	data, err := models.ListTelemetryData(ctx, s.DB, bson.M{"serial": sim.Model.InverterSerial(1)})
+	if err != nil {
+		t.Errorf("Error while reading data in DB: %v\n", err)
+		return
+	}
+	assert.Equal(t, 2, len(data))
+	for n := 1; n <= 2; n++ {
+		expected := sim.Model.Module(1, n, noon)
+		found := false
+		for _, d := range data {
+			if d.Module == expected.Module {
+				found = true
+				// The device clock is read as UTC
+				layout := "Jan-02-2006, 15:04:05"
+				lt, _ := time.Parse(layout, expected.LastTelemetry.Format(layout))
+				assert.Equal(t, lt.Unix(), d.LastTelemetryTime)
+				assert.InDelta(t, expected.InputCurrent, d.InputCurrent, 0.01)
+			}
+		}
+		assert.True(t, found)
+	}
+}
+
+func TestSimulatedOfflineAndNight(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	m := simulator.DefaultModel()
+	m.Inverters = 2
+	noon := time.Date(2020, time.August, 26, 12, 0, 0, 0, time.Local)
+	m.Faults = []simulator.Fault{
+		{Kind: simulator.FaultOffline, Inverter: 1},
+		{Kind: simulator.FaultOffline, Inverter: 2, Module: 2, From: noon.Add(-time.Hour)},
+	}
+	sim, srv := startSimulator(m, noon)
+	defer srv.Close()
+	// The offline inverter loses the server and stops producing
+	s.InverterCollector.Visit(srv.URL + "/inverter/")
+	i := models.Inverter{Serial: sim.Model.InverterSerial(1)}
+	if assert.NoError(t, i.ReadInverter(ctx, s.DB)) {
+		assert.False(t, i.Communication)
+		assert.False(t, i.Status)
+		assert.Equal(t, 0.0, i.Power)
+	}
+	// The offline module keeps its last read from before the fault
+	s.TelemetryCollector.Visit(srv.URL + "/telemetry-data/5/")
+	// At night the other inverter is idle, with the energy of the whole day
+	night := time.Date(2020, time.August, 26, 23, 0, 0, 0, time.Local)
+	sim.Now = func() time.Time { return night }
+	s.InverterCollector.Visit(srv.URL + "/inverter/2/")
+	i = models.Inverter{Serial: sim.Model.InverterSerial(2)}
+	if assert.NoError(t, i.ReadInverter(ctx, s.DB)) {
+		assert.True(t, i.Communication)
+		assert.False(t, i.Status)
+		assert.Equal(t, 0.0, i.Power)
+		assert.InDelta(t, sim.Model.Inverter(2, noon.Add(6*time.Hour)).EnergyToday, i.EnergyToday, 0.01)
+	}
+	// At night the modules show their last report before the sunset
+	s.TelemetryCollector.Visit(srv.URL + "/telemetry-data/4/")
+	// Lets the telemetry writer flush
+	time.Sleep(2 * time.Second)
+	layout := "Jan-02-2006, 15:04:05"
+	for module, before := range map[int]time.Time{1: time.Date(2020, time.August, 26, 18, 0, 0, 0, time.Local), 2: noon.Add(-time.Hour)} {
+		data, err := models.ListTelemetryData(ctx, s.DB, bson.M{"module": sim.Model.ModuleSerial(2, module)})
+		if assert.NoError(t, err) && assert.Equal(t, 1, len(data), module) {
+			lt, _ := time.Parse(layout, before.Format(layout))
+			assert.Less(t, data[0].LastTelemetryTime, lt.Unix(), module)
+			assert.Less(t, lt.Unix()-int64(m.TelemetryPeriod), data[0].LastTelemetryTime, module)
+		}
+	}
+}
+
+func TestSimulatedNewFirmware(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	m := simulator.DefaultModel()
+	m.Firmware = simulator.FirmwareNew
+	noon := time.Date(2020, time.August, 26, 12, 0, 0, 0, time.Local)
+	sim, srv := startSimulator(m, noon)
+	defer srv.Close()
+	// The renamed classes are not parsed, so the page is not stored
+	iURL := srv.URL + "/inverter/"
+	assert.NoError(t, s.InverterCollector.Visit(iURL))
+	i := models.Inverter{Serial: sim.Model.InverterSerial(1)}
+	assert.True(t, errors.Is(i.ReadInverter(ctx, s.DB), models.ErrNotFound))
+	st, ok := scrapeStatus(iURL)
+	if assert.True(t, ok) {
+		assert.Less(t, int64(0), st.Failures)
+		assert.Contains(t, st.LastError, "missing")
+	}
+}
+
+func TestSimulatedCounterReset(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshEnergyCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	m := simulator.DefaultModel()
+	m.InitialEnergy = 1000
+	reset := time.Date(2020, time.August, 26, 3, 0, 0, 0, time.Local)
+	m.CounterResets = []time.Time{reset}
+	before := time.Date(2020, time.August, 25, 12, 0, 0, 0, time.Local)
+	after := time.Date(2020, time.August, 26, 12, 0, 0, 0, time.Local)
+	sim, srv := startSimulator(m, before)
+	defer srv.Close()
+	// The counter restarts from the energy produced since the reset
+	assert.Less(t, sim.Model.Inverter(1, after).TotalEnergy, sim.Model.Inverter(1, before).TotalEnergy)
+	assert.InDelta(t, sim.Model.Inverter(1, after).EnergyToday, sim.Model.Inverter(1, after).TotalEnergy, 0.01)
+	s.InverterCollector.Visit(srv.URL + "/inverter/")
+	// The ledger takes the reads in the fetch times, which must differ
+	time.Sleep(1100 * time.Millisecond)
+	sim.Now = func() time.Time { return after }
+	s.InverterCollector.Visit(srv.URL + "/inverter/")
+	ledger, err := models.ListEnergyLedger(ctx, s.DB, bson.M{"serial": sim.Model.InverterSerial(1)})
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(ledger)) {
+		last := ledger[len(ledger)-1]
+		assert.Equal(t, models.EnergyReset, last.Kind)
+		assert.InDelta(t, sim.Model.Inverter(1, after).TotalEnergy, last.Energy, 0.01)
+	}
+}
+
+func TestSimulatorModelCheck(t *testing.T) {
+	// The http faults need a status that can be answered
+	for status, valid := range map[int]bool{0: false, 99: false, 200: true, 503: true, 600: false} {
+		m := simulator.DefaultModel()
+		m.Faults = []simulator.Fault{{Kind: simulator.FaultHTTP, Status: status}}
+		assert.Equal(t, valid, m.Check() == nil, status)
+	}
+}
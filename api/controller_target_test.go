@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/simulator"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// replaceTargets : scrapes only some targets, returning the previous ones
+func replaceTargets(targets ...models.Target) []models.Target {
+	previous := s.Targets()
+	for _, t := range previous {
+		s.RemoveTarget(t.Name)
+	}
+	for _, t := range targets {
+		if err := s.AddTarget(t); err != nil {
+			log.Fatalf("Error adding the target %v: %v", t.URL, err)
+		}
+	}
+	return previous
+}
+
+func TestMultipleHostsAcquisition(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Each inverter is at its own host: the static server and two simulated devices
+	m := simulator.DefaultModel()
+	m.Inverters = 3
+	noon := time.Date(2020, time.August, 26, 12, 0, 0, 0, time.Local)
+	sim, first := startSimulator(m, noon)
+	defer first.Close()
+	_, second := startSimulator(m, noon)
+	defer second.Close()
+	static := fmt.Sprintf("http://%v:%v/%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"), s.InverterPaths[0])
+	previous := replaceTargets(
+		models.Target{Name: "static", URL: static, Kind: models.InverterKind, Period: 1},
+		models.Target{Name: "first", URL: first.URL + "/inverter/2/", Kind: models.InverterKind, Period: 1,
+			Labels: map[string]string{"site": "north"}},
+	)
+	defer replaceTargets(previous...)
+	// Lets the acquisition run, adding a target while it runs
+	c := make(chan bool)
+	go s.Acquisition(c)
+	time.Sleep(2 * time.Second)
+	if err := s.AddTarget(models.Target{Name: "second", URL: second.URL + "/inverter/3/", Kind: models.InverterKind,
+		Period: 1}); err != nil {
+		t.Errorf("Error while adding a target: %v\n", err)
+	}
+	assert.Error(t, s.AddTarget(models.Target{Name: "second", URL: second.URL + "/inverter/", Kind: models.InverterKind}))
+	time.Sleep(2 * time.Second)
+	c <- true
+	// Verifies the inverters of every host in DB
+	invs, _ := models.ListInverters(ctx, s.DB)
+	assert.Equal(t, 3, len(invs))
+	for _, serial := range []string{"7E1504FE-95", sim.Model.InverterSerial(2), sim.Model.InverterSerial(3)} {
+		i := models.Inverter{Serial: serial}
+		assert.NoError(t, i.ReadInverter(ctx, s.DB), serial)
+	}
+	// The outcomes of the visits carry the labels of their targets
+	st, ok := scrapeStatus(first.URL + "/inverter/2/")
+	if assert.True(t, ok) {
+		assert.Equal(t, "first", st.Target)
+		assert.Equal(t, "north", st.Labels["site"])
+	}
+	assert.NoError(t, s.RemoveTarget("second"))
+	assert.Error(t, s.RemoveTarget("second"))
+}
+
+// requestTargets : makes a request to the targets API as an user
+func requestTargets(method, path, body, user string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", user)
+	req.RemoteAddr = "192.0.2.1:41000"
+	s.Router.ServeHTTP(w, req)
+	return w
+}
+
+// acquireInverters : lets the acquisition run for a while, counting the inverters stored
+func acquireInverters(ctx context.Context) int {
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	c := make(chan bool)
+	go s.Acquisition(c)
+	time.Sleep(2 * time.Second)
+	c <- true
+	// Lets the last visit finish
+	time.Sleep(100 * time.Millisecond)
+	invs, _ := models.ListInverters(ctx, s.DB)
+	return len(invs)
+}
+
+func TestTargetManagement(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTargetCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	previous := replaceTargets()
+	defer replaceTargets(previous...)
+	// The changes are made behind a proxy that sets the user
+	defer s.SetProxyUserHeader(os.Getenv("PROXY_USER_HEADER"))
+	s.SetProxyUserHeader("X-User")
+	static := fmt.Sprintf("http://%v:%v/%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"), s.InverterPaths[0])
+	body := fmt.Sprintf(`{"url": "%v", "kind": "inverter", "period": 1, "labels": {"site": "north"}}`, static)
+	// Adds a target, which is named after its URL and cannot be added twice
+	w := requestTargets("POST", "/targets", body, "operator")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	added := models.Target{}
+	json.Unmarshal(w.Body.Bytes(), &added)
+	assert.Equal(t, models.TargetName(static), added.Name)
+	assert.Equal(t, http.StatusConflict, requestTargets("POST", "/targets", body, "operator").Code)
+	assert.Equal(t, http.StatusBadRequest, requestTargets("POST", "/targets",
+		`{"url": "http://localhost/", "kind": "meter"}`, "operator").Code)
+	// A name taken by a scheduled target is not stored
+	s.AddTarget(models.Target{Name: "configured", URL: static, Kind: models.InverterKind})
+	assert.Equal(t, http.StatusConflict, requestTargets("POST", "/targets",
+		fmt.Sprintf(`{"name": "configured", "url": "%v", "kind": "inverter"}`, static), "operator").Code)
+	configured := models.Target{Name: "configured"}
+	assert.True(t, errors.Is(configured.ReadTarget(ctx, s.DB), models.ErrNotFound))
+	s.RemoveTarget("configured")
+	assert.Equal(t, 1, acquireInverters(ctx))
+	// A paused target is not visited until resumed
+	w = requestTargets("PUT", "/targets/"+added.Name+"/pause", "", "operator")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, acquireInverters(ctx))
+	// The targets are kept in the DB, with their state, between launches
+	replaceTargets()
+	if err := s.LoadTargets(ctx); err != nil {
+		t.Errorf("Error while loading the targets: %v\n", err)
+		return
+	}
+	if targets := s.Targets(); assert.Equal(t, 1, len(targets)) {
+		assert.Equal(t, true, targets[0].Paused)
+		assert.Equal(t, "north", targets[0].Labels["site"])
+	}
+	w = requestTargets("PUT", "/targets/"+added.Name+"/resume", "", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, acquireInverters(ctx))
+	// A deleted target is not visited anymore
+	assert.Equal(t, http.StatusNoContent, requestTargets("DELETE", "/targets/"+added.Name, "", "admin").Code)
+	assert.Equal(t, http.StatusNotFound, requestTargets("DELETE", "/targets/"+added.Name, "", "admin").Code)
+	assert.Equal(t, http.StatusNotFound, requestTargets("PUT", "/targets/"+added.Name+"/pause", "", "admin").Code)
+	assert.Equal(t, 0, acquireInverters(ctx))
+	// Every change is audited, the newest first
+	w = requestTargets("GET", "/targets/changes?name="+added.Name, "", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	changes := []models.TargetChange{}
+	json.Unmarshal(w.Body.Bytes(), &changes)
+	if assert.Equal(t, 4, len(changes)) {
+		for n, action := range []string{models.TargetDeleted, models.TargetResumed, models.TargetPaused, models.TargetAdded} {
+			assert.Equal(t, action, changes[n].Action)
+		}
+		assert.Equal(t, "admin", changes[0].Actor)
+		assert.Equal(t, "operator", changes[3].Actor)
+		assert.Equal(t, "192.0.2.1", changes[3].Client)
+		assert.Equal(t, static, changes[3].Target.URL)
+	}
+	// Without a proxy, the user header is not trusted
+	s.SetProxyUserHeader("")
+	assert.Equal(t, http.StatusCreated, requestTargets("POST", "/targets", body, "admin").Code)
+	stored, err := models.ListTargetChanges(ctx, s.DB, bson.M{"name": added.Name, "action": models.TargetAdded})
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(stored)) {
+		assert.Equal(t, "192.0.2.1", stored[0].Actor)
+	}
+}
+
+func TestLoadTargetsMerge(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTargetCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// A target was changed at runtime and another one was added
+	changed := models.Target{Name: "first", URL: "http://172.16.0.1/inverter/", Kind: models.InverterKind, Period: 60}
+	added := models.Target{Name: "third", URL: "http://172.16.0.3/inverter/", Kind: models.InverterKind}
+	for _, target := range []models.Target{changed, added} {
+		if err := target.AddTargetToDB(ctx, s.DB); err != nil {
+			t.Errorf("Error while storing a target: %v\n", err)
+			return
+		}
+	}
+	// The configuration has the original target and a new one
+	previous := replaceTargets(
+		models.Target{Name: "first", URL: "http://172.16.0.1/inverter/", Kind: models.InverterKind, Period: 5},
+		models.Target{Name: "second", URL: "http://172.16.0.2/inverter/", Kind: models.InverterKind},
+	)
+	defer replaceTargets(previous...)
+	if err := s.LoadTargets(ctx); err != nil {
+		t.Errorf("Error while loading the targets: %v\n", err)
+		return
+	}
+	targets := s.Targets()
+	if assert.Equal(t, 3, len(targets)) {
+		assert.Equal(t, changed, targets[0])
+		assert.Equal(t, "second", targets[1].Name)
+		assert.Equal(t, added, targets[2])
+	}
+	// The new configured target seeds the DB
+	second := models.Target{Name: "second"}
+	assert.NoError(t, second.ReadTarget(ctx, s.DB))
+}
+
+func TestLoadTargetsDeleted(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTargetCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	configured := []models.Target{
+		{Name: "first", URL: "http://172.16.0.1/inverter/", Kind: models.InverterKind},
+		{Name: "second", URL: "http://172.16.0.2/inverter/", Kind: models.InverterKind},
+	}
+	previous := replaceTargets(configured...)
+	defer replaceTargets(previous...)
+	if err := s.LoadTargets(ctx); err != nil {
+		t.Errorf("Error while loading the targets: %v\n", err)
+		return
+	}
+	// A configured target deleted through the API is not seeded again at the next launch
+	assert.Equal(t, http.StatusNoContent, requestTargets("DELETE", "/targets/first", "", "admin").Code)
+	replaceTargets(configured...)
+	if err := s.LoadTargets(ctx); err != nil {
+		t.Errorf("Error while loading the targets: %v\n", err)
+		return
+	}
+	if targets := s.Targets(); assert.Equal(t, 1, len(targets)) {
+		assert.Equal(t, "second", targets[0].Name)
+	}
+	first := models.Target{Name: "first"}
+	assert.True(t, errors.Is(first.ReadTarget(ctx, s.DB), models.ErrNotFound))
+	// Until it is added back through the API
+	assert.Equal(t, http.StatusCreated, requestTargets("POST", "/targets",
+		`{"name": "first", "url": "http://172.16.0.1/inverter/", "kind": "inverter"}`, "admin").Code)
+	replaceTargets(configured...)
+	if err := s.LoadTargets(ctx); err != nil {
+		t.Errorf("Error while loading the targets: %v\n", err)
+		return
+	}
+	assert.Equal(t, 2, len(s.Targets()))
+}
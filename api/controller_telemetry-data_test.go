@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rjmalves/cpid-solar-telemetry/api/controllers"
 	"github.com/rjmalves/cpid-solar-telemetry/api/models"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,17 +20,16 @@ func TestTelemetryDataAcquisition(t *testing.T) {
 	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
 		log.Fatalf("Error refreshing the DB: %v", err)
 	}
-	// Lets the inverter acquisition run for 5s
-	baseURL := fmt.Sprintf("http://%v:%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"))
+	// Lets the acquisition of the targets run for 5s
 	c := make(chan bool)
-	go s.TelemetryDataAcquisition(baseURL, 1, c)
+	go s.Acquisition(c)
 	time.Sleep(5 * time.Second)
 	c <- true
 	// Verifies the telemetry data in DB
 	filter := bson.M{
 		"serial": "7E1504FE-95",
 	}
-	data, err := models.ListTelemetryData(s.DB, filter)
+	data, err := models.ListTelemetryData(ctx, s.DB, filter)
 	if err != nil {
 		t.Errorf("Error while reading data in DB: %v\n", err)
 		return
@@ -37,3 +37,52 @@ func TestTelemetryDataAcquisition(t *testing.T) {
 	// Checks if the DB has repeated data
 	assert.Equal(t, 1, len(data))
 }
+
+func TestTelemetryWriterFlushesOnStop(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Buffers less data than the batch size, with a long flush period
+	w := controllers.TelemetryWriter{
+		DB:          s.DB,
+		BatchSize:   10,
+		FlushPeriod: time.Hour,
+	}
+	w.Start()
+	for i := 0; i < 5; i++ {
+		w.Add(context.Background(), &models.TelemetryData{
+			Serial:            "INVERTER1",
+			LastTelemetryTime: int64(i),
+		})
+	}
+	td, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 0, len(td))
+	// Stopping should write everything
+	w.Stop()
+	td, _ = models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 5, len(td))
+}
+
+func TestDiscoverTelemetryPages(t *testing.T) {
+	// Visits the index page of the static server
+	baseURL := fmt.Sprintf("http://%v:%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"))
+	index := models.Target{URL: baseURL + s.DiscoveryPaths[0] + "/", Kind: models.DiscoveryKind}
+	if err := index.Check(); err != nil {
+		t.Errorf("Error while checking the discovery target: %v\n", err)
+		return
+	}
+	if err := s.DiscoverTelemetry(context.Background(), index); err != nil {
+		t.Errorf("Error while discovering telemetry pages: %v\n", err)
+		return
+	}
+	// Only the telemetry link to the same device should be found
+	discovered := s.DiscoveredTelemetry([]models.Target{})
+	if assert.Equal(t, 1, len(discovered)) {
+		assert.Equal(t, baseURL+"telemetry-data/", discovered[0].URL)
+		assert.Equal(t, models.TelemetryDataKind, discovered[0].Kind)
+	}
+	// The configured telemetry page is not scheduled twice
+	assert.Equal(t, 0, len(s.DiscoveredTelemetry(s.Targets())))
+}
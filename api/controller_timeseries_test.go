@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// serverMajorVersion : the major version of the MongoDB server of the tests
+func serverMajorVersion() int32 {
+	var info struct {
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	if err := s.DB.RunCommand(context.Background(), bson.M{"buildInfo": 1}).Decode(&info); err != nil {
+		log.Fatalf("Error reading the server version: %v", err)
+	}
+	if len(info.VersionArray) == 0 {
+		return 0
+	}
+	return info.VersionArray[0]
+}
+
+// useTelemetryTimeSeries : recreates the telemetry data collection as time-series, if enabled
+// and supported, until the returned function is called
+func useTelemetryTimeSeries(enabled bool) func() {
+	ctx := context.Background()
+	refresh := func(enabled bool) {
+		s.TelemetryTimeSeries = enabled
+		if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+			log.Fatalf("Error refreshing the DB: %v", err)
+		}
+		ts, err := s.IsTelemetryDataTimeSeries(ctx)
+		if err != nil {
+			log.Fatalf("Error reading the telemetry data collection: %v", err)
+		}
+		models.SetTelemetryDataTimeSeries(ts)
+	}
+	previous := s.TelemetryTimeSeries
+	refresh(enabled)
+	return func() { refresh(previous) }
+}
+
+func TestTimeSeriesFallback(t *testing.T) {
+	ctx := context.Background()
+	if serverMajorVersion() >= 5 {
+		t.Skip("the server has time-series collections")
+	}
+	defer useTelemetryTimeSeries(true)()
+	// Older servers keep a regular collection, whose unique index rejects repeated reads
+	ts, err := s.IsTelemetryDataTimeSeries(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ts)
+	d := models.TelemetryData{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000000}
+	if _, err := d.AddDataToDB(ctx, s.DB); err != nil {
+		t.Errorf("Error while adding data: %v\n", err)
+		return
+	}
+	_, err = d.AddDataToDB(ctx, s.DB)
+	assert.True(t, errors.Is(err, models.ErrDuplicate))
+}
+
+func TestTimeSeriesTelemetryData(t *testing.T) {
+	ctx := context.Background()
+	major := serverMajorVersion()
+	if major < 5 {
+		t.Skip("the server has no time-series collections")
+	}
+	defer useTelemetryTimeSeries(true)()
+	ts, err := s.IsTelemetryDataTimeSeries(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ts)
+	// Without an unique index, the repeated reads are looked up before inserting
+	d := models.TelemetryData{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000000, InputVoltage: 30}
+	if _, err := d.AddDataToDB(ctx, s.DB); err != nil {
+		t.Errorf("Error while adding data: %v\n", err)
+		return
+	}
+	acquired, err := d.AlreadyAcquired(ctx, s.DB)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	_, err = d.AddDataToDB(ctx, s.DB)
+	assert.True(t, errors.Is(err, models.ErrDuplicate))
+	n, err := models.AddDataBatchToDB(ctx, s.DB, []*models.TelemetryData{
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000000},
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000300},
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000300},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	data, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 2, len(data))
+	// Deleting by time, to replace a read, needs MongoDB 7.0
+	if major < 7 {
+		return
+	}
+	replaced := models.TelemetryData{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000000, InputVoltage: 25}
+	res, err := replaced.ReplaceDataInDB(ctx, s.DB)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Changed, res)
+	data, _ = models.ListTelemetryData(ctx, s.DB, bson.M{"lastTelemetryTime": 1600000000})
+	if assert.Equal(t, 1, len(data)) {
+		assert.InDelta(t, 25.0, data[0].InputVoltage, 1e-9)
+	}
+}
+
+func TestTimeSeriesMigrationResume(t *testing.T) {
+	if serverMajorVersion() < 5 {
+		t.Skip("the server has no time-series collections")
+	}
+	ctx := context.Background()
+	defer useTelemetryTimeSeries(false)()
+	data := []*models.TelemetryData{}
+	for i := int64(0); i < 3; i++ {
+		data = append(data, &models.TelemetryData{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1600000000 + 300*i})
+	}
+	if _, err := models.AddDataBatchToDB(ctx, s.DB, data); err != nil {
+		t.Errorf("Error while adding data: %v\n", err)
+		return
+	}
+	// A migration into a time-series collection stops after copying the first read
+	rename := bson.D{
+		{Key: "renameCollection", Value: s.DB.Name() + ".telemetryData"},
+		{Key: "to", Value: s.DB.Name() + ".telemetryDataMigrating"},
+	}
+	if err := s.DB.Client().Database("admin").RunCommand(ctx, rename).Err(); err != nil {
+		t.Errorf("Error while renaming the collection: %v\n", err)
+		return
+	}
+	s.TelemetryTimeSeries = true
+	if err := s.SetupTelemetryDataCollection(ctx); err != nil {
+		t.Errorf("Error while creating the collection: %v\n", err)
+		return
+	}
+	models.SetTelemetryDataTimeSeries(true)
+	var first models.TelemetryData
+	if err := s.DB.Collection("telemetryDataMigrating").FindOne(ctx, bson.M{}).Decode(&first); err != nil {
+		t.Errorf("Error while reading the data: %v\n", err)
+		return
+	}
+	first.FillDerivedFields()
+	if _, err := s.DB.Collection("telemetryData").InsertOne(ctx, &first); err != nil {
+		t.Errorf("Error while copying the data: %v\n", err)
+		return
+	}
+	// Resumes the migration, which does not copy the first read again
+	if err := s.MigrateTelemetryDataCollection(ctx); err != nil {
+		t.Errorf("Error while migrating: %v\n", err)
+		return
+	}
+	n, err := s.DB.Collection("telemetryData").CountDocuments(ctx, bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	colls, _ := s.DB.ListCollectionNames(ctx, bson.M{"name": "telemetryDataMigrating"})
+	assert.Equal(t, 0, len(colls))
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// tlsDevice : a device that serves the inverter page over HTTPS, with a self-signed certificate,
+// optionally asking for a client certificate
+func tlsDevice(clientCert bool) *httptest.Server {
+	device := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveInverterPage(w)
+	}))
+	if clientCert {
+		device.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	}
+	device.StartTLS()
+	return device
+}
+
+// writePEM : writes a PEM block to a file in a directory
+func writePEM(dir, name, kind string, der []byte) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		log.Fatalf("Error writing %v: %v", name, err)
+	}
+	return file
+}
+
+// writeClientCert : writes a self-signed client certificate and its key to a directory
+func writeClientCert(dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("Error generating the client key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cpid-solar-telemetry"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatalf("Error creating the client certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		log.Fatalf("Error encoding the client key: %v", err)
+	}
+	return writePEM(dir, "client.pem", "CERTIFICATE", der), writePEM(dir, "client-key.pem", "EC PRIVATE KEY", keyDer)
+}
+
+// useTLS : visits the targets with TLS settings, written to a file in a directory
+func useTLS(dir string, targets ...*transport.TLSTarget) error {
+	file := filepath.Join(dir, "tls.json")
+	content, err := json.Marshal(transport.TLSConfig{Targets: targets})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		return err
+	}
+	if err := s.SetTLS(file); err != nil {
+		return err
+	}
+	s.ConfigureTransport()
+	return nil
+}
+
+func TestHTTPSInverterAcquisition(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		log.Fatalf("Error creating the TLS directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// Visits the other tests with the system authorities again
+	defer func() {
+		s.SetTLS("")
+		s.ConfigureTransport()
+	}()
+	device := tlsDevice(false)
+	defer device.Close()
+	pinned := transport.Fingerprint(device.Certificate().Raw)
+	ca := writePEM(dir, "ca.pem", "CERTIFICATE", device.Certificate().Raw)
+	certFile, keyFile := writeClientCert(dir)
+	cases := []struct {
+		name   string
+		target *transport.TLSTarget
+		ok     bool
+	}{
+		{"system authorities", nil, false},
+		{"custom authority", &transport.TLSTarget{CAFile: ca}, true},
+		{"pinned", &transport.TLSTarget{Fingerprint: strings.ToUpper(pinned)}, true},
+		{"wrong pin", &transport.TLSTarget{Fingerprint: strings.Repeat("ab", 32)}, false},
+	}
+	for _, c := range cases {
+		// Removes all data in the collection
+		if err := s.RefreshInverterCollection(ctx); err != nil {
+			log.Fatalf("Error refreshing the DB: %v", err)
+		}
+		targets := []*transport.TLSTarget{}
+		if c.target != nil {
+			c.target.URL = device.URL + "/"
+			targets = append(targets, c.target)
+		}
+		if err := useTLS(dir, targets...); err != nil {
+			t.Errorf("Error while configuring the TLS settings of %v: %v\n", c.name, err)
+			continue
+		}
+		err := s.InverterCollector.Visit(device.URL + "/inverter/")
+		assert.Equal(t, c.ok, err == nil, c.name)
+		invs, _ := models.ListInverters(ctx, s.DB)
+		assert.Equal(t, map[bool]int{true: 1, false: 0}[c.ok], len(invs), c.name)
+	}
+	// Devices that ask for a client certificate are only scraped with one
+	clientDevice := tlsDevice(true)
+	defer clientDevice.Close()
+	pinned = transport.Fingerprint(clientDevice.Certificate().Raw)
+	if err := useTLS(dir, &transport.TLSTarget{URL: clientDevice.URL + "/", Fingerprint: pinned}); err != nil {
+		t.Errorf("Error while configuring the TLS settings: %v\n", err)
+		return
+	}
+	assert.Error(t, s.InverterCollector.Visit(clientDevice.URL+"/inverter/"))
+	if err := useTLS(dir, &transport.TLSTarget{URL: clientDevice.URL + "/", Fingerprint: pinned,
+		CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Errorf("Error while configuring the TLS settings: %v\n", err)
+		return
+	}
+	assert.NoError(t, s.InverterCollector.Visit(clientDevice.URL+"/inverter/"))
+}
+
+func TestURLPrefixMatching(t *testing.T) {
+	prefixes := []*transport.URLPrefix{}
+	for _, raw := range []string{"http://172.16.0.1", "http://172.16.0.1/inverter/", "https://device.local"} {
+		p, err := transport.ParseURLPrefix(raw)
+		if err != nil {
+			t.Errorf("Error while parsing the prefix %v: %v\n", raw, err)
+			return
+		}
+		prefixes = append(prefixes, p)
+	}
+	for raw, expected := range map[string]int{
+		"http://172.16.0.1/telemetry-data/":  0,
+		"http://172.16.0.1:80/":              0,
+		"http://172.16.0.1/inverter/":        1,
+		"http://172.16.0.1/inverter/2/":      1,
+		"http://172.16.0.1/inverter2/":       0,
+		"http://172.16.0.10/inverter/":       -1,
+		"http://172.16.0.1.attacker.example": -1,
+		"http://172.16.0.1:8080/inverter/":   -1,
+		"https://172.16.0.1/inverter/":       -1,
+		"https://DEVICE.local:443/inverter/": 2,
+	} {
+		u, _ := url.Parse(raw)
+		assert.Equal(t, expected, transport.LongestMatch(prefixes, u), raw)
+	}
+	_, err := transport.ParseURLPrefix("172.16.0.1/inverter/")
+	assert.Error(t, err)
+}
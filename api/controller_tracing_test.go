@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/controllers"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans : records the spans of the service in memory, until the returned function is called
+func recordSpans() (*tracetest.InMemoryExporter, func()) {
+	exp := tracetest.NewInMemoryExporter()
+	tracing.Use(exp)
+	return exp, func() {
+		tracing.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}
+}
+
+// spansByName : the recorded spans, by name
+func spansByName(exp *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+	if err := tracing.Flush(context.Background()); err != nil {
+		log.Fatalf("Error flushing the spans: %v", err)
+	}
+	spans := map[string]tracetest.SpanStub{}
+	for _, sp := range exp.GetSpans() {
+		spans[sp.Name] = sp
+	}
+	return spans
+}
+
+func TestAcquisitionSpans(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	exp, stop := recordSpans()
+	defer stop()
+	static := fmt.Sprintf("http://%v:%v/%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT"), s.InverterPaths[0])
+	previous := replaceTargets(models.Target{Name: "static", URL: static, Kind: models.InverterKind, Period: 60})
+	defer replaceTargets(previous...)
+	// Lets the acquisition visit the target once
+	c := make(chan bool)
+	go s.Acquisition(c)
+	time.Sleep(1500 * time.Millisecond)
+	c <- true
+	spans := spansByName(exp)
+	cycle, ok := spans["acquisition cycle"]
+	if !assert.True(t, ok) {
+		return
+	}
+	scrape, ok := spans["scrape "+models.InverterKind]
+	if !assert.True(t, ok) {
+		return
+	}
+	// The visit is a child of the cycle, with the steps of the pipeline as its children
+	assert.Equal(t, cycle.SpanContext.SpanID(), scrape.Parent.SpanID())
+	assert.Contains(t, scrape.Attributes, tracing.TargetKey.String("static"))
+	assert.Contains(t, scrape.Attributes, tracing.URLKey.String(static))
+	for _, name := range []string{"fetch", "parse", "validate", "inverters.update", "dailyPerformance.update", "energyLedger.find"} {
+		sp, ok := spans[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, scrape.SpanContext.SpanID(), sp.Parent.SpanID(), name)
+			assert.Equal(t, cycle.SpanContext.TraceID(), sp.SpanContext.TraceID(), name)
+		}
+	}
+	// The DB commands are recorded by the monitor of the client
+	if update, ok := spans["inverters.update"]; ok {
+		assert.Contains(t, update.Attributes, semconv.DBSystemMongoDB)
+		assert.Contains(t, update.Attributes, semconv.DBMongoDBCollectionKey.String("inverters"))
+	}
+}
+
+func TestTelemetryWriterSpans(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	exp, stop := recordSpans()
+	defer stop()
+	w := controllers.TelemetryWriter{
+		DB:          s.DB,
+		BatchSize:   10,
+		FlushPeriod: time.Hour,
+	}
+	w.Start()
+	// The reads of two scrapes are written in one batch
+	scrapes := []trace.Span{}
+	for i := 0; i < 2; i++ {
+		sctx, span := tracing.Start(ctx, "scrape "+models.TelemetryDataKind)
+		w.Add(sctx, &models.TelemetryData{
+			Serial:            "INVERTER1",
+			LastTelemetryTime: int64(i),
+		})
+		span.End()
+		scrapes = append(scrapes, span)
+	}
+	w.Stop()
+	spans := spansByName(exp)
+	flush, ok := spans["flush telemetry"]
+	if !assert.True(t, ok) {
+		return
+	}
+	if assert.Equal(t, 2, len(flush.Links)) {
+		for i, span := range scrapes {
+			assert.Equal(t, span.SpanContext().SpanID(), flush.Links[i].SpanContext.SpanID())
+		}
+	}
+	batch, ok := spans["telemetryData.insert"]
+	if assert.True(t, ok) {
+		assert.Equal(t, flush.SpanContext.SpanID(), batch.Parent.SpanID())
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValidateScrapedData(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshRejectedDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.SetValidation("./tests/assets/validation.json"); err != nil {
+		log.Fatalf("Error configuring the validation: %v", err)
+	}
+	defer s.SetValidation("")
+	// A DC voltage parsed into the AC field is rejected
+	i := models.Inverter{Serial: "7E1504FE-95", Power: 31.81, Voltage: 936, Frequency: 60}
+	assert.False(t, s.ValidateInverter(ctx, &i, time.Now(), []byte("<html>936 Vdc</html>")))
+	// The power of the model is limited
+	i = models.Inverter{Serial: "7E1504FE-95", Power: 40.0, Voltage: 286, Frequency: 60}
+	assert.False(t, s.ValidateInverter(ctx, &i, time.Now(), nil))
+	i = models.Inverter{Serial: "7E1504FE-95", Power: 31.81, Voltage: 286, Frequency: 60}
+	assert.True(t, s.ValidateInverter(ctx, &i, time.Now(), nil))
+	// A fast change of the current is only flagged
+	d := models.TelemetryData{Serial: "7E1504FE-95", Module: "MODULE-1", LastTelemetryTime: 1000, InputVoltage: 40, InputCurrent: 1.0}
+	assert.True(t, s.ValidateTelemetryData(ctx, &d, nil))
+	assert.Equal(t, 0, len(d.Flags))
+	d = models.TelemetryData{Serial: "7E1504FE-95", Module: "MODULE-1", LastTelemetryTime: 1010, InputVoltage: 40, InputCurrent: 9.0}
+	assert.True(t, s.ValidateTelemetryData(ctx, &d, nil))
+	assert.Equal(t, 1, len(d.Flags))
+	// A negative voltage is rejected
+	d = models.TelemetryData{Serial: "7E1504FE-95", Module: "MODULE-1", LastTelemetryTime: 1020, InputVoltage: -40, InputCurrent: 9.0}
+	assert.False(t, s.ValidateTelemetryData(ctx, &d, nil))
+	// Verifies the rejected reads in DB
+	rejected, err := models.ListRejectedData(ctx, s.DB, bson.M{})
+	if err != nil {
+		t.Errorf("Error while listing rejected data: %v\n", err)
+		return
+	}
+	assert.Equal(t, 3, len(rejected))
+	inverters, _ := models.ListRejectedData(ctx, s.DB, bson.M{"kind": models.InverterKind})
+	assert.Equal(t, 2, len(inverters))
+}
+
+func TestReadValidationFileUnknownAction(t *testing.T) {
+	for _, content := range []string{
+		`{"default": {"power": {"max": 100, "action": "flg"}}}`,
+		`{"models": {"SE5000": {"voltage": {"max": 260, "action": "drop"}}}}`,
+	} {
+		f, err := ioutil.TempFile("", "validation-*.json")
+		if err != nil {
+			log.Fatalf("Error creating the validation file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(content)
+		f.Close()
+		_, err = models.ReadValidationFile(f.Name())
+		assert.True(t, errors.Is(err, models.ErrInvalid), err)
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Default parameters of the anomaly detection
+const (
+	defaultAnomalyWindow      = 3600
+	defaultAnomalyThreshold   = 0.2
+	defaultAnomalyPersistence = 0.6
+	defaultAnomalyMinSlots    = 3
+)
+
+// SetAnomalyDetection : configures the time window, in seconds, in which the modules are
+// compared to their peers, the deviation from the peers median that makes a module an outlier,
+// as a fraction, and the fraction of the compared samples that must be outliers for an anomaly.
+// The samples are compared in slots of the expected telemetry period. Empty or invalid values
+// use the defaults.
+func (s *Server) SetAnomalyDetection(window, threshold, persistence string) {
+	s.AnomalyWindow = defaultAnomalyWindow
+	if n, err := strconv.ParseInt(window, 10, 64); err == nil && n > 0 {
+		s.AnomalyWindow = n
+	}
+	s.Anomaly = models.AnomalyConfig{
+		Threshold:   defaultAnomalyThreshold,
+		Persistence: defaultAnomalyPersistence,
+		MinSlots:    defaultAnomalyMinSlots,
+	}
+	if t, err := strconv.ParseFloat(threshold, 64); err == nil && t > 0 {
+		s.Anomaly.Threshold = t
+	}
+	if p, err := strconv.ParseFloat(persistence, 64); err == nil && p > 0 && p <= 1 {
+		s.Anomaly.Persistence = p
+	}
+}
+
+// DetectAnomalies : compares the modules of every inverter with their peers in the last
+// complete window
+func (s *Server) DetectAnomalies(ctx context.Context) error {
+	serials, err := models.ListModuleSerials(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	cfg := s.Anomaly
+	cfg.SlotPeriod = s.Completeness.Period
+	end := deviceNow().Unix() / s.AnomalyWindow * s.AnomalyWindow
+	start := end - s.AnomalyWindow
+	for _, serial := range serials {
+		anomalies, err := models.DetectModuleAnomalies(ctx, s.DB, serial, start, end, cfg)
+		if err != nil {
+			return err
+		}
+		for _, a := range anomalies {
+			logging.Warn("Module anomaly detected", logging.Fields{
+				logging.SerialField: a.Serial,
+				logging.ModuleField: a.Module,
+				"severity":          a.Severity,
+				"field":             a.Field,
+				"deviation":         a.Deviation,
+			})
+		}
+	}
+	return nil
+}
+
+// AnomalyDetection : periodically compares the modules with their peers
+func (s *Server) AnomalyDetection(aPeriod int64, quit chan bool) {
+	// Prepares the timer
+	aTimer := int64(0)
+	// Runs forever
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+			// Checks timeout
+			cTime := time.Now().Unix()
+			if cTime-aTimer >= aPeriod {
+				aTimer = cTime
+				ctx, span := tracing.Start(context.Background(), "detect anomalies")
+				err := s.DetectAnomalies(ctx)
+				tracing.Fail(span, err)
+				span.End()
+				if err != nil {
+					logging.Error("Error while detecting anomalies", logging.Fields{logging.ErrorField: err})
+				}
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+// GetAnomalies : lists the anomalies, optionally of an inverter, a severity or a review state
+func (s *Server) GetAnomalies(c *gin.Context) {
+	ctx := c.Request.Context()
+	filter := bson.M{}
+	for _, param := range []string{"serial", "module", "severity"} {
+		if v := c.Query(param); v != "" {
+			filter[param] = v
+		}
+	}
+	if v := c.Query("reviewed"); v != "" {
+		reviewed, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		filter["reviewed"] = reviewed
+	}
+	if err := addTimeRange(c, filter, "windowStart", false); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	anomalies, err := models.ListAnomalies(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, anomalies)
+}
+
+// ReviewAnomaly : marks an anomaly as reviewed
+func (s *Server) ReviewAnomaly(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.ReviewAnomaly(ctx, s.DB, id); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
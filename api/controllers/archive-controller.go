@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/archive"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SetArchive : configures where the fetched inverter and telemetry pages are archived, which
+// is a directory for the "disk" mode and a GridFS bucket for the "gridfs" one. An empty mode
+// does not archive the pages.
+func (s *Server) SetArchive(mode, dir string) error {
+	switch mode {
+	case "", archive.DiskMode, archive.GridFSMode:
+	default:
+		return fmt.Errorf("unknown archive mode: %v", mode)
+	}
+	if mode == archive.DiskMode && dir == "" {
+		return fmt.Errorf("missing archive directory")
+	}
+	s.ArchiveMode = mode
+	s.ArchiveDir = dir
+	return nil
+}
+
+// InitializeArchive : opens the configured archive, after connecting to the DB
+func (s *Server) InitializeArchive() error {
+	s.Archive = nil
+	if s.ArchiveMode == "" {
+		return nil
+	}
+	a, err := archive.New(s.DB, s.ArchiveMode, s.ArchiveDir)
+	if err != nil {
+		return err
+	}
+	s.Archive = a
+	return nil
+}
+
+// archivePage : archives a fetched page, if configured
+func (s *Server) archivePage(kind string, r *colly.Response) {
+	if s.Archive == nil {
+		return
+	}
+	if _, err := s.Archive.Save(requestContext(r.Request), kind, r.Request.URL.String(), fetchTime(r.Request).UTC(), r.Body); err != nil {
+		logging.Error("Error while archiving the page", logging.Fields{logging.URLField: r.Request.URL.String(),
+			logging.KindField: kind, logging.ErrorField: err})
+	}
+}
+
+// rootElement : the root div of an archived page, as the scrapper finds it
+func rootElement(p *archive.Page, content []byte) (*colly.HTMLElement, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	sel := doc.Find("div[id]").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		id, _ := s.Attr("id")
+		return id == "root"
+	})
+	if sel.Length() == 0 {
+		return nil, fmt.Errorf("root not found in page %v", p.ID.Hex())
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp := &colly.Response{
+		Body:    content,
+		Ctx:     colly.NewContext(),
+		Request: &colly.Request{URL: u, Ctx: colly.NewContext()},
+	}
+	return colly.NewHTMLElementFromSelectionNode(resp, sel.First(), sel.Nodes[0], 0), nil
+}
+
+// inverterReprocess : what the reprocessed pages of an inverter rebuild
+type inverterReprocess struct {
+	// latest : the inverter in the newest page
+	latest *models.Inverter
+	// energy : the largest daily energy counter of each day
+	energy map[time.Time]float64
+	// reads : the reads of the total energy counter
+	reads []models.EnergyRead
+}
+
+// Reprocess : parses again the archived pages of a kind fetched between two times, rebuilding
+// the telemetry data, the daily energy of the inverters and their energy ledger in the times,
+// and then the rollups of the days found. The inverters are only added when not stored, as the
+// archived reads are older than the live one. The daily energy of an inverter is replaced by the
+// largest one in its pages of the day, even if lower than the one kept before. Reads out of
+// the bounds of the validation are skipped. An empty kind reprocesses all the pages.
+func (s *Server) Reprocess(ctx context.Context, kind string, from, to time.Time) (int, error) {
+	if s.Archive == nil {
+		return 0, fmt.Errorf("the pages are not archived")
+	}
+	filter := bson.M{"fetchedAt": bson.M{"$gte": from, "$lt": to}}
+	if kind != "" {
+		filter["kind"] = kind
+	}
+	pages, err := s.Archive.List(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	days := map[time.Time]bool{}
+	inverters := map[string]*inverterReprocess{}
+	rebuilt := 0
+	for _, p := range pages {
+		content, err := s.Archive.Read(p)
+		if err != nil {
+			return rebuilt, err
+		}
+		e, err := rootElement(p, content)
+		if err != nil {
+			logging.Warn("Skipping archived page", logging.Fields{"page": p.ID.Hex(), logging.ErrorField: err})
+			continue
+		}
+		switch p.Kind {
+		case models.InverterKind:
+			i := models.Inverter{}
+			if err := i.FromScrapper(e); err != nil {
+				logging.Warn("Skipping archived page", logging.Fields{"page": p.ID.Hex(), logging.ErrorField: err})
+				continue
+			}
+			violations := s.Validation.Validate(i.Serial, i.ValidatedFields(), nil, 0)
+			if models.Rejected(violations) {
+				continue
+			}
+			at := deviceTime(p.FetchedAt)
+			day := models.StartOfDay(at)
+			r, ok := inverters[i.Serial]
+			if !ok {
+				r = &inverterReprocess{energy: map[time.Time]float64{}}
+				inverters[i.Serial] = r
+			}
+			// The pages are listed by fetch time
+			r.latest = &i
+			if energy, ok := r.energy[day]; !ok || i.EnergyToday > energy {
+				r.energy[day] = i.EnergyToday
+			}
+			if i.TotalEnergy > 0 {
+				r.reads = append(r.reads, models.EnergyRead{At: at.Unix(), Counter: i.TotalEnergy})
+			}
+			days[day] = true
+		case models.TelemetryDataKind:
+			t := models.TelemetryData{}
+			if err := t.FromScrapper(e); err != nil {
+				logging.Warn("Skipping archived page", logging.Fields{"page": p.ID.Hex(), logging.ErrorField: err})
+				continue
+			}
+			violations := s.Validation.Validate(t.Serial, t.ValidatedFields(), nil, 0)
+			if models.Rejected(violations) {
+				continue
+			}
+			if flags := models.Flags(violations); len(flags) > 0 {
+				t.Flags = flags
+			}
+			if _, err := t.ReplaceDataInDB(ctx, s.DB); err != nil {
+				return rebuilt, err
+			}
+			if err := models.UpdateModuleRegistry(ctx, s.DB, []*models.TelemetryData{&t}); err != nil {
+				return rebuilt, err
+			}
+			days[models.StartOfDay(t.TelemetryTime)] = true
+		default:
+			continue
+		}
+		rebuilt++
+	}
+	for serial, r := range inverters {
+		if _, err := r.latest.AddInverterToDB(ctx, s.DB); err != nil && !errors.Is(err, models.ErrDuplicate) {
+			return rebuilt, err
+		}
+		for day, energy := range r.energy {
+			if err := models.ReplaceInverterEnergy(ctx, s.DB, serial, day, energy); err != nil {
+				return rebuilt, err
+			}
+		}
+		if _, err := models.RebuildEnergyLedger(ctx, s.DB, serial, deviceTime(from).Unix(), deviceTime(to).Unix(),
+			r.reads, s.EnergyMaxGap); err != nil {
+			return rebuilt, err
+		}
+	}
+	for d := range days {
+		if err := s.UpdateDayRollups(ctx, d); err != nil {
+			return rebuilt, err
+		}
+	}
+	return rebuilt, nil
+}
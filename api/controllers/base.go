@@ -3,13 +3,24 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/archive"
+	"github.com/rjmalves/cpid-solar-telemetry/api/auth"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/migrations"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+	"github.com/rjmalves/cpid-solar-telemetry/api/transport"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,58 +28,157 @@ import (
 
 // Server : the base elements that make the service
 type Server struct {
-	DB                 *mongo.Database
-	InverterCollector  *colly.Collector
-	TelemetryCollector *colly.Collector
-	InverterPaths      []string
-	TelemetryPaths     []string
+	DB                   *mongo.Database
+	InverterCollector    *colly.Collector
+	TelemetryCollector   *colly.Collector
+	DiscoveryCollector   *colly.Collector
+	Router               *gin.Engine
+	InverterPaths        []string
+	TelemetryPaths       []string
+	DiscoveryPaths       []string
+	DiscoveryPattern     *regexp.Regexp
+	TelemetryRetention   time.Duration
+	RollupRetention      time.Duration
+	TelemetryTimeSeries  bool
+	TelemetryBatchSize   int
+	TelemetryFlushPeriod time.Duration
+	TelemetryWriter      *TelemetryWriter
+	ModuleStaleAfter     int64
+	ModuleMaxDeviation   float64
+	Irradiance           IrradianceConfig
+	EnergyMaxGap         int64
+	Completeness         models.CompletenessWindow
+	AnomalyWindow        int64
+	Anomaly              models.AnomalyConfig
+	Validation           *models.ValidationRules
+	ArchiveMode          string
+	ArchiveDir           string
+	Archive              *archive.Archive
+	ReplayDir            string
+	ReplaySpeed          float64
+	Auth                 *auth.Config
+	Transport            http.RoundTripper
+	AppURL               string
+	TLS                  *transport.Router
+	ReadyMissedPeriods   int64
+	ProxyUserHeader      string
+	discovery            telemetryDiscovery
+	validator            dataValidator
+	scrapes              scrapeTracker
+	targets              targetRegistry
+	health               healthState
 }
 
-// Initialize : prepares the service to launch
-func (s *Server) Initialize(DBHost, DBPort, DBUser, DBPassword, DBDatabase, inverters, telemetries string) error {
-	// Connects with the database
+// ConnectDB : connects with the database
+func (s *Server) ConnectDB(DBHost, DBPort, DBUser, DBPassword, DBDatabase string) error {
 	mongoURI := fmt.Sprintf("mongodb://%v:%v@%v:%v/%v", DBUser, DBPassword, DBHost, DBPort, DBDatabase)
-	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI))
+	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI).SetMonitor(tracing.CommandMonitor()))
 	if err != nil {
 		return err
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	err = client.Connect(ctx)
-	if err != nil {
+	if err := client.Connect(ctx); err != nil {
 		return err
 	}
 	s.DB = client.Database(DBDatabase)
+	return nil
+}
+
+// Initialize : prepares the service to launch
+func (s *Server) Initialize(DBHost, DBPort, DBUser, DBPassword, DBDatabase, inverters, telemetries string) error {
+	// Connects with the database
+	if err := s.ConnectDB(DBHost, DBPort, DBUser, DBPassword, DBDatabase); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	// Checks if first connection for making the collection setups
 	colls, err := s.DB.ListCollectionNames(ctx, bson.D{})
 	if err != nil {
 		return err
 	}
-	inverterCollFound := false
-	telemetryCollFound := false
-	for _, c := range colls {
-		if c == "inverters" {
-			inverterCollFound = true
-		} else if c == "telemetryData" {
-			telemetryCollFound = true
-		}
+	setups := map[string]func(context.Context) error{
+		"inverters":             s.SetupInverterCollection,
+		"telemetryData":         s.SetupTelemetryDataCollection,
+		"telemetryDailyData":    s.SetupTelemetryDailyDataCollection,
+		"modules":               s.SetupModuleCollection,
+		"sites":                 s.SetupSiteCollection,
+		"strings":               s.SetupStringCollection,
+		"dailyPerformance":      s.SetupDailyPerformanceCollection,
+		"irradiance":            s.SetupIrradianceCollection,
+		"energyLedger":          s.SetupEnergyLedgerCollection,
+		"energyDaily":           s.SetupEnergyDailyCollection,
+		"telemetryGaps":         s.SetupTelemetryGapCollection,
+		"telemetryCompleteness": s.SetupTelemetryCompletenessCollection,
+		"anomalies":             s.SetupAnomalyCollection,
+		"rejectedData":          s.SetupRejectedDataCollection,
+		"pageArchive":           s.SetupPageArchiveCollection,
+		"targets":               s.SetupTargetCollection,
+		"targetChanges":         s.SetupTargetChangeCollection,
+		"deletedTargets":        s.SetupDeletedTargetCollection,
 	}
-	if !inverterCollFound {
-		if err := s.SetupInverterCollection(ctx); err != nil {
-			return err
+	for name, setup := range setups {
+		found := false
+		for _, c := range colls {
+			if c == name {
+				found = true
+			}
 		}
-	}
-	if !telemetryCollFound {
-		if err := s.SetupTelemetryDataCollection(ctx); err != nil {
-			return err
+		if !found {
+			if err := setup(ctx); err != nil {
+				return err
+			}
 		}
 	}
+	// Moves the data of collections with an old layout, which may take a while
+	mctx := context.Background()
+	if err := s.MigrateCappedCollection(mctx, "inverters", s.SetupInverterCollection); err != nil {
+		return err
+	}
+	if err := s.MigrateTelemetryDataCollection(mctx); err != nil {
+		return err
+	}
+	// Brings the schema and data of the collections to the current version
+	if err := migrations.Run(mctx, s.DB, false); err != nil {
+		return err
+	}
+	// Imports the configured insolation
+	if err := s.ImportIrradiance(mctx); err != nil {
+		return err
+	}
+	// Opens the archive of the fetched pages
+	if err := s.InitializeArchive(); err != nil {
+		return err
+	}
+	// Applies the configured retention to the existing collections
+	if err := s.ApplyRetentionPolicies(mctx); err != nil {
+		return err
+	}
+	// Starts the batched writing of telemetry data
+	s.TelemetryWriter = &TelemetryWriter{
+		DB:          s.DB,
+		BatchSize:   s.TelemetryBatchSize,
+		FlushPeriod: s.TelemetryFlushPeriod,
+	}
+	s.TelemetryWriter.Start()
+	// Validates the scraped values with the built-in limits, unless configured before the
+	// collectors start, as the rules are not guarded by the validator mutex
+	if s.Validation == nil {
+		s.Validation = models.DefaultValidationRules()
+	}
 	// Creates the collectors
 	s.InverterCollector = colly.NewCollector()
 	s.TelemetryCollector = colly.NewCollector()
+	s.DiscoveryCollector = colly.NewCollector()
 	// Configures the collectors
 	s.InverterCollectorConfig()
 	s.TelemetryDataCollectorConfig()
+	s.DiscoveryCollectorConfig()
+	// Authenticates the requests of the collectors
+	s.ConfigureTransport()
+	// Creates the API
+	s.InitializeRouter()
 	// Parses the configured paths
 	s.InverterPaths = strings.Split(inverters, ",")
 	s.TelemetryPaths = strings.Split(telemetries, ",")
@@ -77,6 +187,10 @@ func (s *Server) Initialize(DBHost, DBPort, DBUser, DBPassword, DBDatabase, inve
 
 // Terminate : closes connections and ends the service
 func (s *Server) Terminate() error {
+	// Writes the buffered data
+	if s.TelemetryWriter != nil {
+		s.TelemetryWriter.Stop()
+	}
 	// Disconnects from DB
 	if err := s.DB.Client().Disconnect(context.Background()); err != nil {
 		return err
@@ -85,39 +199,64 @@ func (s *Server) Terminate() error {
 }
 
 // Run : runs the service and recovers errors
-func (s *Server) Run(appHost, appPort, iPeriod, tPeriod string) {
+func (s *Server) Run(apiPort, appHost, appPort, iPeriod, tPeriod, dPeriod, rPeriod, mPeriod, aPeriod string) {
 	defer s.Terminate()
+	// Serves the API, if configured
+	if apiPort != "" {
+		s.ServeAPI(apiPort)
+	}
 	// Prepares the app URL for scrapper visiting
 	baseURL := fmt.Sprintf("http://%v:%v/", appHost, appPort)
-	// Runs collector routines
+	if s.AppURL != "" {
+		baseURL = s.AppURL
+	}
+	if err := s.SetPathTargets(baseURL, iPeriod, tPeriod, dPeriod); err != nil {
+		logging.Error("Error while configuring the targets", logging.Fields{logging.ErrorField: err})
+	}
+	// The targets managed at runtime are kept in the DB between launches
+	if err := s.LoadTargets(context.Background()); err != nil {
+		logging.Error("Error while loading the targets", logging.Fields{logging.ErrorField: err})
+	}
+	// Runs the collector routine, or feeds the collectors the captured pages if replaying
 	ich := make(chan bool)
-	if i, err := strconv.ParseInt(iPeriod, 10, 64); err == nil {
-		go s.InverterAcquisition(baseURL, i, ich)
+	if s.ReplayDir != "" {
+		go func() {
+			if err := s.Replay(s.ReplayDir, s.ReplaySpeed, ich); err != nil {
+				logging.Error("Error while replaying", logging.Fields{logging.ErrorField: err})
+			}
+		}()
+	} else {
+		go s.Acquisition(ich)
+	}
+	rch := make(chan bool)
+	if r, err := strconv.ParseInt(rPeriod, 10, 64); err == nil {
+		go s.RollupAggregation(r, rch)
+	}
+	mch := make(chan bool)
+	if m, err := strconv.ParseInt(mPeriod, 10, 64); err == nil {
+		go s.ModuleHealthCheck(m, mch)
 	}
-	tch := make(chan bool)
-	if t, err := strconv.ParseInt(tPeriod, 10, 64); err == nil {
-		go s.TelemetryDataAcquisition(baseURL, t, tch)
+	ach := make(chan bool)
+	if a, err := strconv.ParseInt(aPeriod, 10, 64); err == nil {
+		go s.AnomalyDetection(a, ach)
 	}
-	// Exits on SIGINT
+	// Exits on SIGINT or SIGTERM
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	<-ch
 }
 
 // SetupInverterCollection : setups the inverter collection with constraints and rules
 func (s *Server) SetupInverterCollection(ctx context.Context) error {
-	// Creates collections with existence rules
-	opts := options.CreateCollection()
-	opts.SetCapped(true)
-	opts.SetSizeInBytes(1e11)
-	if err := s.DB.CreateCollection(ctx, "inverters", opts); err != nil {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "inverters"); err != nil {
 		return err
 	}
 	iCol := s.DB.Collection("inverters")
 	// Creates unique indexes
 	iMod := mongo.IndexModel{
-		Keys: bson.M{
-			"serial": -1,
+		Keys: bson.D{
+			{Key: "serial", Value: -1},
 		},
 		Options: options.Index().SetUnique(true),
 	}
@@ -129,25 +268,311 @@ func (s *Server) SetupInverterCollection(ctx context.Context) error {
 
 // SetupTelemetryDataCollection : setups the telemetry data collection with constraints and rules
 func (s *Server) SetupTelemetryDataCollection(ctx context.Context) error {
-	// Creates collections with existence rules
-	opts := options.CreateCollection()
-	opts.SetCapped(true)
-	opts.SetSizeInBytes(1e11)
-	if err := s.DB.CreateCollection(ctx, "telemetryData", opts); err != nil {
+	// Creates the collection as time-series if configured and supported
+	ts, err := s.useTimeSeries(ctx)
+	if err != nil {
+		return err
+	}
+	if ts {
+		return s.setupTelemetryDataTimeSeries(ctx)
+	}
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "telemetryData"); err != nil {
 		return err
 	}
 	tCol := s.DB.Collection("telemetryData")
 	// Creates unique indexes
 	tMod := mongo.IndexModel{
-		Keys: bson.M{
-			"serial":            -1,
-			"lastTelemetryTime": -1,
+		Keys: bson.D{
+			{Key: "serial", Value: -1},
+			{Key: "lastTelemetryTime", Value: -1},
 		},
 		Options: options.Index().SetUnique(true),
 	}
 	if _, err := tCol.Indexes().CreateOne(ctx, tMod); err != nil {
 		return err
 	}
+	// Creates the retention index
+	return s.ensureTTLIndex(ctx, "telemetryData", "telemetryTime", s.TelemetryRetention)
+}
+
+// SetupTelemetryDailyDataCollection : setups the daily telemetry rollups collection with constraints and rules
+func (s *Server) SetupTelemetryDailyDataCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "telemetryDailyData"); err != nil {
+		return err
+	}
+	dCol := s.DB.Collection("telemetryDailyData")
+	// Creates unique indexes
+	dMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: -1},
+			{Key: "module", Value: -1},
+			{Key: "day", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := dCol.Indexes().CreateOne(ctx, dMod); err != nil {
+		return err
+	}
+	// Creates the retention index
+	return s.ensureTTLIndex(ctx, "telemetryDailyData", "day", s.RollupRetention)
+}
+
+// SetupModuleCollection : setups the module registry collection with constraints and rules
+func (s *Server) SetupModuleCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "modules"); err != nil {
+		return err
+	}
+	mCol := s.DB.Collection("modules")
+	// Creates unique indexes
+	mMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: -1},
+			{Key: "module", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := mCol.Indexes().CreateOne(ctx, mMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupSiteCollection : setups the site collection with constraints and rules
+func (s *Server) SetupSiteCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "sites"); err != nil {
+		return err
+	}
+	sCol := s.DB.Collection("sites")
+	// Creates unique indexes
+	sMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := sCol.Indexes().CreateOne(ctx, sMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupStringCollection : setups the string collection with constraints and rules
+func (s *Server) SetupStringCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "strings"); err != nil {
+		return err
+	}
+	sCol := s.DB.Collection("strings")
+	// Creates unique indexes, as strings of different sites may have the same name
+	sMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "site", Value: 1},
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := sCol.Indexes().CreateOne(ctx, sMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupDailyPerformanceCollection : setups the daily performance collection with constraints and rules
+func (s *Server) SetupDailyPerformanceCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "dailyPerformance"); err != nil {
+		return err
+	}
+	pCol := s.DB.Collection("dailyPerformance")
+	// Creates unique indexes
+	pMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "scope", Value: 1},
+			{Key: "name", Value: 1},
+			{Key: "day", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := pCol.Indexes().CreateOne(ctx, pMod); err != nil {
+		return err
+	}
+	// Creates the retention index, as the performance is kept as the rollups
+	return s.ensureTTLIndex(ctx, "dailyPerformance", "day", s.RollupRetention)
+}
+
+// SetupIrradianceCollection : setups the irradiance collection with constraints and rules
+func (s *Server) SetupIrradianceCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "irradiance"); err != nil {
+		return err
+	}
+	iCol := s.DB.Collection("irradiance")
+	// Creates unique indexes
+	iMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "site", Value: 1},
+			{Key: "day", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := iCol.Indexes().CreateOne(ctx, iMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupEnergyLedgerCollection : setups the energy ledger collection with constraints and rules
+func (s *Server) SetupEnergyLedgerCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "energyLedger"); err != nil {
+		return err
+	}
+	eCol := s.DB.Collection("energyLedger")
+	// Creates unique indexes
+	eMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: 1},
+			{Key: "end", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := eCol.Indexes().CreateOne(ctx, eMod); err != nil {
+		return err
+	}
+	// Creates the retention index, as the ledger is kept as the rollups
+	return s.ensureTTLIndex(ctx, "energyLedger", "day", s.RollupRetention)
+}
+
+// SetupEnergyDailyCollection : setups the daily energy collection with constraints and rules
+func (s *Server) SetupEnergyDailyCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "energyDaily"); err != nil {
+		return err
+	}
+	eCol := s.DB.Collection("energyDaily")
+	// Creates unique indexes
+	eMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: 1},
+			{Key: "day", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := eCol.Indexes().CreateOne(ctx, eMod); err != nil {
+		return err
+	}
+	return s.ensureTTLIndex(ctx, "energyDaily", "day", s.RollupRetention)
+}
+
+// SetupTelemetryGapCollection : setups the telemetry gaps collection with constraints and rules
+func (s *Server) SetupTelemetryGapCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "telemetryGaps"); err != nil {
+		return err
+	}
+	gCol := s.DB.Collection("telemetryGaps")
+	// Creates unique indexes
+	gMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: 1},
+			{Key: "module", Value: 1},
+			{Key: "start", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := gCol.Indexes().CreateOne(ctx, gMod); err != nil {
+		return err
+	}
+	return s.ensureTTLIndex(ctx, "telemetryGaps", "day", s.RollupRetention)
+}
+
+// SetupTelemetryCompletenessCollection : setups the telemetry completeness collection with constraints and rules
+func (s *Server) SetupTelemetryCompletenessCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "telemetryCompleteness"); err != nil {
+		return err
+	}
+	cCol := s.DB.Collection("telemetryCompleteness")
+	// Creates unique indexes
+	cMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: 1},
+			{Key: "module", Value: 1},
+			{Key: "day", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := cCol.Indexes().CreateOne(ctx, cMod); err != nil {
+		return err
+	}
+	return s.ensureTTLIndex(ctx, "telemetryCompleteness", "day", s.RollupRetention)
+}
+
+// SetupAnomalyCollection : setups the anomalies collection with constraints and rules
+func (s *Server) SetupAnomalyCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "anomalies"); err != nil {
+		return err
+	}
+	aCol := s.DB.Collection("anomalies")
+	// Creates unique indexes
+	aMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: 1},
+			{Key: "module", Value: 1},
+			{Key: "field", Value: 1},
+			{Key: "windowStart", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := aCol.Indexes().CreateOne(ctx, aMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupRejectedDataCollection : setups the rejected data collection with constraints and rules
+func (s *Server) SetupRejectedDataCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "rejectedData"); err != nil {
+		return err
+	}
+	rCol := s.DB.Collection("rejectedData")
+	// Creates indexes
+	rMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serial", Value: 1},
+			{Key: "receivedAt", Value: -1},
+		},
+	}
+	if _, err := rCol.Indexes().CreateOne(ctx, rMod); err != nil {
+		return err
+	}
+	// Creates the retention index, as the rejected data is kept as the raw telemetry
+	return s.ensureTTLIndex(ctx, "rejectedData", "receivedAt", s.TelemetryRetention)
+}
+
+// SetupPageArchiveCollection : setups the archived pages collection with constraints and rules
+func (s *Server) SetupPageArchiveCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "pageArchive"); err != nil {
+		return err
+	}
+	pCol := s.DB.Collection("pageArchive")
+	// Creates indexes
+	pMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "kind", Value: 1},
+			{Key: "fetchedAt", Value: 1},
+		},
+	}
+	if _, err := pCol.Indexes().CreateOne(ctx, pMod); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -172,3 +597,198 @@ func (s *Server) RefreshTelemetryDataCollection(ctx context.Context) error {
 	}
 	return nil
 }
+
+// RefreshTelemetryDailyDataCollection : deletes all the daily telemetry rollups in the DB
+func (s *Server) RefreshTelemetryDailyDataCollection(ctx context.Context) error {
+	if err := s.DB.Collection("telemetryDailyData").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupTelemetryDailyDataCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshModuleCollection : deletes all the registered modules in the DB
+func (s *Server) RefreshModuleCollection(ctx context.Context) error {
+	if err := s.DB.Collection("modules").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupModuleCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshLayoutCollections : deletes all the sites and strings in the DB
+func (s *Server) RefreshLayoutCollections(ctx context.Context) error {
+	if err := s.DB.Collection("sites").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupSiteCollection(ctx); err != nil {
+		return err
+	}
+	if err := s.DB.Collection("strings").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupStringCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshPerformanceCollections : deletes all the daily performance and irradiance data in the DB
+func (s *Server) RefreshPerformanceCollections(ctx context.Context) error {
+	if err := s.DB.Collection("dailyPerformance").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupDailyPerformanceCollection(ctx); err != nil {
+		return err
+	}
+	if err := s.DB.Collection("irradiance").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupIrradianceCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshEnergyCollections : deletes all the energy ledger and daily energy data in the DB
+func (s *Server) RefreshEnergyCollections(ctx context.Context) error {
+	if err := s.DB.Collection("energyLedger").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupEnergyLedgerCollection(ctx); err != nil {
+		return err
+	}
+	if err := s.DB.Collection("energyDaily").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupEnergyDailyCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshCompletenessCollections : deletes all the telemetry gaps and completeness data in the DB
+func (s *Server) RefreshCompletenessCollections(ctx context.Context) error {
+	if err := s.DB.Collection("telemetryGaps").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupTelemetryGapCollection(ctx); err != nil {
+		return err
+	}
+	if err := s.DB.Collection("telemetryCompleteness").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupTelemetryCompletenessCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshAnomalyCollection : deletes all the anomalies in the DB
+func (s *Server) RefreshAnomalyCollection(ctx context.Context) error {
+	if err := s.DB.Collection("anomalies").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupAnomalyCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshRejectedDataCollection : deletes all the rejected data in the DB
+func (s *Server) RefreshRejectedDataCollection(ctx context.Context) error {
+	if err := s.DB.Collection("rejectedData").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupRejectedDataCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupTargetCollection : creates the targets collection, unique by name
+func (s *Server) SetupTargetCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "targets"); err != nil {
+		return err
+	}
+	tCol := s.DB.Collection("targets")
+	// Creates unique indexes
+	tMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := tCol.Indexes().CreateOne(ctx, tMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupTargetChangeCollection : creates the collection of the changes made to the targets
+func (s *Server) SetupTargetChangeCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "targetChanges"); err != nil {
+		return err
+	}
+	cCol := s.DB.Collection("targetChanges")
+	// Creates indexes for the queries by target
+	cMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: 1},
+			{Key: "time", Value: -1},
+		},
+	}
+	if _, err := cCol.Indexes().CreateOne(ctx, cMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetupDeletedTargetCollection : creates the collection of the deleted targets, unique by name
+func (s *Server) SetupDeletedTargetCollection(ctx context.Context) error {
+	// Creates the collection
+	if err := s.DB.CreateCollection(ctx, "deletedTargets"); err != nil {
+		return err
+	}
+	dCol := s.DB.Collection("deletedTargets")
+	// Creates unique indexes
+	dMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := dCol.Indexes().CreateOne(ctx, dMod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RefreshTargetCollections : deletes all the targets, their changes and deletions in the DB
+func (s *Server) RefreshTargetCollections(ctx context.Context) error {
+	if err := s.DB.Collection("targets").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupTargetCollection(ctx); err != nil {
+		return err
+	}
+	if err := s.DB.Collection("targetChanges").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupTargetChangeCollection(ctx); err != nil {
+		return err
+	}
+	if err := s.DB.Collection("deletedTargets").Drop(ctx); err != nil {
+		return err
+	}
+	if err := s.SetupDeletedTargetCollection(ctx); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+)
+
+// Default expected telemetry of the modules
+const (
+	defaultTelemetrySamplePeriod = 300
+	defaultTelemetryFromHour     = 6
+	defaultTelemetryToHour       = 18
+)
+
+// SetCompleteness : configures the period, in seconds, in which each module is expected to send
+// a telemetry sample and the hours of the device clock when they are expected, as "6-18".
+// Empty values use the defaults.
+func (s *Server) SetCompleteness(period, hours string) error {
+	s.Completeness = models.CompletenessWindow{
+		Period:   defaultTelemetrySamplePeriod,
+		FromHour: defaultTelemetryFromHour,
+		ToHour:   defaultTelemetryToHour,
+	}
+	if period != "" {
+		p, err := strconv.ParseInt(period, 10, 64)
+		if err != nil || p <= 0 {
+			return fmt.Errorf("invalid telemetry sample period: %v", period)
+		}
+		s.Completeness.Period = p
+	}
+	if hours != "" {
+		h := strings.Split(hours, "-")
+		if len(h) != 2 {
+			return fmt.Errorf("invalid telemetry hours: %v", hours)
+		}
+		from, errFrom := strconv.Atoi(strings.TrimSpace(h[0]))
+		to, errTo := strconv.Atoi(strings.TrimSpace(h[1]))
+		if errFrom != nil || errTo != nil || from < 0 || to > 24 || from >= to {
+			return fmt.Errorf("invalid telemetry hours: %v", hours)
+		}
+		s.Completeness.FromHour = from
+		s.Completeness.ToHour = to
+	}
+	return nil
+}
+
+// UpdateCompleteness : finds the gaps in the telemetry of a day until now
+func (s *Server) UpdateCompleteness(ctx context.Context, day time.Time) ([]*models.TelemetryCompleteness, error) {
+	return models.UpdateTelemetryCompleteness(ctx, s.DB, day, s.Completeness, deviceNow().Unix())
+}
+
+// PrintCompleteness : writes the completeness report of a day
+func PrintCompleteness(report []*models.TelemetryCompleteness) {
+	fmt.Printf("%-20s %-20s %10s %10s %8s %6s\n", "SERIAL", "MODULE", "EXPECTED", "RECEIVED", "PERCENT", "GAPS")
+	for _, c := range report {
+		module := c.Module
+		if module == "" {
+			module = "(all)"
+		}
+		fmt.Printf("%-20s %-20s %10d %10d %7.1f%% %6d\n", c.Serial, module, c.Expected, c.Received, c.Completeness, c.Gaps)
+	}
+}
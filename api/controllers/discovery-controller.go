@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+)
+
+// defaultDiscoveryPattern : the links that are taken as telemetry pages when no pattern is configured
+const defaultDiscoveryPattern = "telemetry"
+
+// telemetryDiscovery : the telemetry pages found by following the links of the discovery pages
+type telemetryDiscovery struct {
+	mutex sync.RWMutex
+	// found : the telemetry URLs found in the last visit of each discovery target
+	found map[string]map[string]bool
+	// visiting : the telemetry URLs being found in the current visit of each discovery target
+	visiting map[string]map[string]bool
+}
+
+// SetTelemetryDiscovery : configures the pages whose links are followed for finding telemetry
+// pages, separated by comma, and the regular expression that the links must match
+func (s *Server) SetTelemetryDiscovery(paths, pattern string) error {
+	s.DiscoveryPaths = []string{}
+	for _, p := range strings.Split(paths, ",") {
+		if p != "" {
+			s.DiscoveryPaths = append(s.DiscoveryPaths, p)
+		}
+	}
+	if pattern == "" {
+		pattern = defaultDiscoveryPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	s.DiscoveryPattern = re
+	return nil
+}
+
+// DiscoveryCollectorConfig : configures the scrapper that follows the links of the discovery pages
+func (s *Server) DiscoveryCollectorConfig() error {
+	s.discovery = telemetryDiscovery{
+		found:    map[string]map[string]bool{},
+		visiting: map[string]map[string]bool{},
+	}
+	if s.DiscoveryPattern == nil {
+		s.DiscoveryPattern = regexp.MustCompile(defaultDiscoveryPattern)
+	}
+	s.DiscoveryCollector.AllowURLRevisit = true
+	// Security parameters
+	s.DiscoveryCollector.Limit(&colly.LimitRule{
+		Parallelism: 4,
+		RandomDelay: 10 * time.Millisecond,
+	})
+	// When a link is found, keeps it if it is a telemetry page of the same device
+	s.DiscoveryCollector.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		source := e.Request.Ctx.Get("source")
+		baseURL := e.Request.Ctx.Get("baseURL")
+		link := e.Request.AbsoluteURL(e.Attr("href"))
+		if link == "" || !strings.HasPrefix(link, baseURL) || !s.DiscoveryPattern.MatchString(link) {
+			return
+		}
+		link = strings.TrimRight(link, "/") + "/"
+		if link == baseURL {
+			return
+		}
+		s.discovery.mutex.Lock()
+		defer s.discovery.mutex.Unlock()
+		if s.discovery.visiting[source] == nil {
+			s.discovery.visiting[source] = map[string]bool{}
+		}
+		s.discovery.visiting[source][link] = true
+	})
+	// When the page is done, its links replace the ones of the previous visit
+	s.DiscoveryCollector.OnScraped(func(r *colly.Response) {
+		source := r.Ctx.Get("source")
+		s.discovery.mutex.Lock()
+		defer s.discovery.mutex.Unlock()
+		found := s.discovery.visiting[source]
+		if found == nil {
+			found = map[string]bool{}
+		}
+		for p := range s.discovery.found[source] {
+			if !found[p] {
+				logging.Info("Telemetry page vanished", logging.Fields{logging.URLField: p, "source": source})
+			}
+		}
+		for p := range found {
+			if !s.discovery.found[source][p] {
+				logging.Info("Telemetry page discovered", logging.Fields{logging.URLField: p, "source": source})
+			}
+		}
+		s.discovery.found[source] = found
+		delete(s.discovery.visiting, source)
+	})
+	// When the page fails, the links of the previous visit are kept
+	s.DiscoveryCollector.OnError(func(r *colly.Response, err error) {
+		source := r.Ctx.Get("source")
+		logging.Warn("Error while discovering telemetry pages", logging.Fields{logging.URLField: source,
+			logging.ErrorField: err})
+		failVisit(r.Request, err)
+		s.discovery.mutex.Lock()
+		defer s.discovery.mutex.Unlock()
+		delete(s.discovery.visiting, source)
+	})
+
+	// Before making a request logs the visit
+	s.DiscoveryCollector.OnRequest(func(r *colly.Request) {
+		logging.Debug("Discovering telemetry pages", logging.Fields{logging.URLField: r.URL.String()})
+	})
+	s.traceVisits(models.DiscoveryKind, s.DiscoveryCollector)
+	return nil
+}
+
+// DiscoverTelemetry : visits a discovery target looking for telemetry pages of its device, in
+// a span that is a child of the one in a context
+func (s *Server) DiscoverTelemetry(ctx context.Context, t models.Target) error {
+	vctx := visitContext(ctx, t)
+	vctx.Put("source", t.Name)
+	vctx.Put("baseURL", t.DeviceURL())
+	return s.DiscoveryCollector.Request("GET", t.URL, nil, vctx, nil)
+}
+
+// DiscoveredTelemetry : the telemetry targets of the pages found by the discovery targets,
+// scraped or replayed, whose URLs are not among some targets, sorted by URL. Each one is named
+// after its URL, has no period of its own and takes the labels of the target among them with
+// the name of the discovery target where it was found.
+func (s *Server) DiscoveredTelemetry(targets []models.Target) []models.Target {
+	sources := map[string]models.Target{}
+	configured := map[string]bool{}
+	for _, t := range targets {
+		sources[t.Name] = t
+		configured[t.URL] = true
+	}
+	s.discovery.mutex.RLock()
+	defer s.discovery.mutex.RUnlock()
+	discovered := []models.Target{}
+	for source, found := range s.discovery.found {
+		for u := range found {
+			if configured[u] {
+				continue
+			}
+			configured[u] = true
+			discovered = append(discovered, models.Target{
+				Name:   models.TargetName(u),
+				URL:    u,
+				Kind:   models.TelemetryDataKind,
+				Labels: sources[source].Labels,
+			})
+		}
+	}
+	sort.Slice(discovered, func(i, j int) bool { return discovered[i].URL < discovered[j].URL })
+	return discovered
+}
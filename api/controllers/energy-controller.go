@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultEnergyMaxGap : the longest interval, in seconds, between reads of the energy
+// counter that is not flagged as a gap
+const defaultEnergyMaxGap = 900
+
+// SetEnergyLedger : configures after how many seconds between two reads of the total energy
+// counter the interval is flagged as a gap. Empty or invalid values use the default.
+func (s *Server) SetEnergyLedger(maxGap string) {
+	s.EnergyMaxGap = defaultEnergyMaxGap
+	if n, err := strconv.ParseInt(maxGap, 10, 64); err == nil && n > 0 {
+		s.EnergyMaxGap = n
+	}
+}
+
+// RecordEnergyCounter : adds the read of the total energy counter of an inverter, at a time of
+// the device clock, to the ledger. A zero counter is taken as a failed read.
+func (s *Server) RecordEnergyCounter(ctx context.Context, i *models.Inverter, at time.Time) error {
+	if i.TotalEnergy <= 0 {
+		return nil
+	}
+	e, err := models.AppendEnergyLedger(ctx, s.DB, i.Serial, at.Unix(), i.TotalEnergy, s.EnergyMaxGap)
+	if err != nil {
+		return err
+	}
+	switch e.Kind {
+	case models.EnergyReset, models.EnergyRollback:
+		logging.Warn("Energy counter went back", logging.Fields{
+			logging.SerialField: i.Serial,
+			logging.KindField:   e.Kind,
+			"counter":           e.Counter,
+		})
+	}
+	return nil
+}
+
+// UpdateEnergyLedger : reconciles the ledger of each inverter in a day with its daily counter
+func (s *Server) UpdateEnergyLedger(ctx context.Context, day time.Time) error {
+	serials, err := models.ListEnergyLedgerSerials(ctx, s.DB, day)
+	if err != nil {
+		return err
+	}
+	for _, serial := range serials {
+		if _, err := models.ReconcileEnergy(ctx, s.DB, serial, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInverterEnergy : lists the daily energy of an inverter from the ledger
+func (s *Server) GetInverterEnergy(c *gin.Context) {
+	ctx := c.Request.Context()
+	filter := bson.M{"serial": c.Param("serial")}
+	if err := addTimeRange(c, filter, "day", true); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	daily, err := models.ListEnergyDaily(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, daily)
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// defaultReadyMissedPeriods : how many periods a target may go without a successful scrape
+// before the service is not ready, when not configured
+const defaultReadyMissedPeriods = 3
+
+// acquisitionTimeout : how long the acquisition routine may go without checking the targets
+// before the collectors are taken as stopped
+const acquisitionTimeout = 5 * time.Second
+
+// healthState : when the acquisition routine last checked the targets, and since when it
+// visits each one
+type healthState struct {
+	mutex     sync.RWMutex
+	heartbeat time.Time
+	// scheduled : when each visited target was first taken by the acquisition, by name,
+	// which is again when a paused target is resumed
+	scheduled map[string]time.Time
+}
+
+// HealthCheck : the state of a dependency of the service
+type HealthCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Stale : the targets without a successful scrape in the last periods
+	Stale []string `json:"stale,omitempty"`
+}
+
+// Readiness : if the service is working, with the state of each dependency
+type Readiness struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// SetReadiness : configures how many periods a target may go without a successful scrape
+// before the service is not ready
+func (s *Server) SetReadiness(missedPeriods string) error {
+	s.ReadyMissedPeriods = defaultReadyMissedPeriods
+	if missedPeriods == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(missedPeriods, 10, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid number of missed periods: %v", missedPeriods)
+	}
+	s.ReadyMissedPeriods = n
+	return nil
+}
+
+// acquisitionStarted : records the start of the acquisition routine, which visits no target yet
+func (s *Server) acquisitionStarted() {
+	s.health.mutex.Lock()
+	defer s.health.mutex.Unlock()
+	s.health.heartbeat = time.Now()
+	s.health.scheduled = map[string]time.Time{}
+}
+
+// acquisitionAlive : records that the acquisition routine checked the targets, and which ones
+// it visits, by name
+func (s *Server) acquisitionAlive(visited map[string]bool) {
+	s.health.mutex.Lock()
+	defer s.health.mutex.Unlock()
+	now := time.Now()
+	s.health.heartbeat = now
+	for name := range visited {
+		if _, ok := s.health.scheduled[name]; !ok {
+			s.health.scheduled[name] = now
+		}
+	}
+	for name := range s.health.scheduled {
+		if !visited[name] {
+			delete(s.health.scheduled, name)
+		}
+	}
+}
+
+// checkDB : pings the DB
+func (s *Server) checkDB() HealthCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.DB.Client().Ping(ctx, readpref.Primary()); err != nil {
+		return HealthCheck{Error: err.Error()}
+	}
+	return HealthCheck{OK: true}
+}
+
+// checkCollectors : checks that the acquisition routine is running, which is not needed when
+// replaying the captured pages
+func (s *Server) checkCollectors() HealthCheck {
+	if s.ReplayDir != "" {
+		return HealthCheck{OK: true}
+	}
+	s.health.mutex.RLock()
+	defer s.health.mutex.RUnlock()
+	if s.health.heartbeat.IsZero() {
+		return HealthCheck{Error: "acquisition not started"}
+	}
+	if since := time.Since(s.health.heartbeat); since > acquisitionTimeout {
+		return HealthCheck{Error: fmt.Sprintf("acquisition stopped %v ago", since.Round(time.Second))}
+	}
+	return HealthCheck{OK: true}
+}
+
+// checkScrapes : checks that every visited inverter and telemetry target had a successful
+// scrape in its last periods. The targets are given as many periods for their first one from
+// when the acquisition took them, as when added at runtime or resumed.
+func (s *Server) checkScrapes() HealthCheck {
+	if s.ReplayDir != "" {
+		return HealthCheck{OK: true}
+	}
+	s.health.mutex.RLock()
+	scheduled := map[string]time.Time{}
+	for name, at := range s.health.scheduled {
+		scheduled[name] = at
+	}
+	s.health.mutex.RUnlock()
+	successes := map[string]time.Time{}
+	for _, st := range s.ScrapeStatuses() {
+		successes[st.URL] = st.LastSuccess
+	}
+	stale := []string{}
+	for _, t := range s.scheduledTargets() {
+		period := s.targetPeriod(t)
+		if t.Paused || period <= 0 || t.Kind == models.DiscoveryKind {
+			continue
+		}
+		since, ok := scheduled[t.Name]
+		if !ok {
+			continue
+		}
+		last := successes[t.URL]
+		if last.Before(since) {
+			last = since
+		}
+		if time.Since(last) > time.Duration(s.ReadyMissedPeriods*period)*time.Second {
+			stale = append(stale, t.Name)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return HealthCheck{Error: "targets without recent scrapes: " + strings.Join(stale, ", "), Stale: stale}
+	}
+	return HealthCheck{OK: true}
+}
+
+// Readiness : checks the DB, the collectors and the recent scrapes of the targets
+func (s *Server) Readiness() Readiness {
+	r := Readiness{
+		Ready: true,
+		Checks: map[string]HealthCheck{
+			"mongo":      s.checkDB(),
+			"collectors": s.checkCollectors(),
+			"scrapes":    s.checkScrapes(),
+		},
+	}
+	for _, c := range r.Checks {
+		r.Ready = r.Ready && c.OK
+	}
+	return r
+}
+
+// GetHealth : answers while the service process is alive
+func (s *Server) GetHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// GetReadiness : answers if the service is working, with the state of each dependency
+func (s *Server) GetReadiness(c *gin.Context) {
+	r := s.Readiness()
+	code := http.StatusOK
+	if !r.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, r)
+}
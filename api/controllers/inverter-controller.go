@@ -1,11 +1,12 @@
 package controllers
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
 	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
 )
 
 // InverterCollectorConfig : configures the inverter data scrapper
@@ -22,52 +23,49 @@ func (s *Server) InverterCollectorConfig() error {
 		if e.Attr("id") != "root" {
 			return
 		}
-		// Processes the HTML
+		// Processes the HTML, where unexpected pages are not stored
+		e.Request.Ctx.Put("parsed", "true")
+		ctx := requestContext(e.Request)
 		i := models.Inverter{}
-		i.FromScrapper(e)
+		if err := tracing.Track(ctx, "parse", func() error { return i.FromScrapper(e) }); err != nil {
+			s.scrapeFailed(models.InverterKind, e.Request, err)
+			return
+		}
+		s.scrapeSucceeded(models.InverterKind, e.Request)
+		// The inverter page has no time, so the fetch time is taken in the device clock
+		at := deviceTime(fetchTime(e.Request))
+		// Discards absurd values
+		vctx, span := tracing.Start(ctx, "validate", tracing.SerialKey.String(i.Serial))
+		ok := s.ValidateInverter(vctx, &i, at, e.Response.Body)
+		span.End()
+		if !ok {
+			return
+		}
+		logger := logging.With(logging.Fields{logging.URLField: e.Request.URL.String(), logging.SerialField: i.Serial})
 		// Adds to DB or updates
-		if !i.AlreadyInDB(s.DB) {
-			if _, err := i.AddInverterToDB(s.DB); err != nil {
-				fmt.Printf("Error while adding inverter: %v\n", err)
-			}
-		} else {
-			if err := i.UpdateInverterInDB(s.DB); err != nil {
-				fmt.Printf("Error while updating inverter: %v\n", err)
-			}
+		res, err := i.UpsertInverterInDB(ctx, s.DB)
+		if err != nil {
+			logger.Error("Error while upserting inverter", logging.Fields{logging.ErrorField: err})
+			return
+		}
+		if res == models.Created {
+			logger.Info("New inverter found")
+		}
+		// Keeps the energy of the day for the performance indicators
+		if err := models.RecordInverterEnergy(ctx, s.DB, i.Serial, at, i.EnergyToday); err != nil {
+			logger.Error("Error while recording inverter energy", logging.Fields{logging.ErrorField: err})
+		}
+		if err := s.RecordEnergyCounter(ctx, &i, at); err != nil {
+			logger.Error("Error while recording energy counter", logging.Fields{logging.ErrorField: err})
 		}
 	})
 
-	// Before making a request print "Visiting ..."
-	s.InverterCollector.OnRequest(func(r *colly.Request) {
-		fmt.Println("Visiting", r.URL.String())
+	// Records the outcome of the visits
+	s.trackScrapes(models.InverterKind, s.InverterCollector)
+
+	// Archives the fetched page, if configured
+	s.InverterCollector.OnResponse(func(r *colly.Response) {
+		s.archivePage(models.InverterKind, r)
 	})
 	return nil
 }
-
-// InverterAcquisition : uses the scrapper for acquire inverter data
-func (s *Server) InverterAcquisition(baseURL string, iPeriod int64, quit chan bool) {
-	// Prepares the timers
-	iTimers := map[string]int64{}
-	for _, i := range s.InverterPaths {
-		iTimers[i] = time.Now().Unix()
-	}
-	// Runs forever
-	for {
-		select {
-		case <-quit:
-			return
-		default:
-			// For each inverter
-			for _, i := range s.InverterPaths {
-				// Checks timeout
-				cTime := time.Now().Unix()
-				if cTime-iTimers[i] >= iPeriod {
-					iTimers[i] = cTime
-					iURL := baseURL + i + "/"
-					go s.InverterCollector.Visit(iURL)
-				}
-			}
-			time.Sleep(1 * time.Second)
-		}
-	}
-}
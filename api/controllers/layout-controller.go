@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoadLayoutFile : adds or updates the sites and strings described in a layout file, if given
+func (s *Server) LoadLayoutFile(ctx context.Context, path string) error {
+	if path == "" {
+		return nil
+	}
+	l, err := models.ReadLayoutFile(path)
+	if err != nil {
+		return err
+	}
+	if err := l.UpsertLayoutInDB(ctx, s.DB); err != nil {
+		return err
+	}
+	logging.Info("Loaded the plant layout", logging.Fields{"sites": len(l.Sites), "strings": len(l.Strings),
+		"file": path})
+	return nil
+}
+
+// GetSites : lists all the sites
+func (s *Server) GetSites(c *gin.Context) {
+	ctx := c.Request.Context()
+	sites, err := models.ListSites(ctx, s.DB)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, sites)
+}
+
+// GetSite : reads a site by name
+func (s *Server) GetSite(c *gin.Context) {
+	ctx := c.Request.Context()
+	st := models.Site{Name: c.Param("name")}
+	if err := st.ReadSite(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// PutSite : adds or updates a site, named by the path
+func (s *Server) PutSite(c *gin.Context) {
+	ctx := c.Request.Context()
+	st := models.Site{}
+	if err := c.ShouldBindJSON(&st); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	// The id is kept by the DB, so the one in the body is ignored
+	st.ID = primitive.NilObjectID
+	st.Name = c.Param("name")
+	res, err := st.UpsertSiteInDB(ctx, s.DB)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(upsertStatusCode(res), st)
+}
+
+// DeleteSite : deletes a site by name
+func (s *Server) DeleteSite(c *gin.Context) {
+	ctx := c.Request.Context()
+	st := models.Site{Name: c.Param("name")}
+	if err := st.ReadSite(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := st.DeleteSiteFromDB(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetSiteStrings : lists the strings of a site
+func (s *Server) GetSiteStrings(c *gin.Context) {
+	ctx := c.Request.Context()
+	strs, err := models.ListStringsOfSite(ctx, s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, strs)
+}
+
+// GetSiteTelemetryData : lists the telemetry data of the inverters of a site
+func (s *Server) GetSiteTelemetryData(c *gin.Context) {
+	ctx := c.Request.Context()
+	st := models.Site{Name: c.Param("name")}
+	if err := st.ReadSite(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.respondTelemetryData(c, st.TelemetryFilter())
+}
+
+// GetSiteTelemetryDailyData : lists the daily telemetry data of the inverters of a site
+func (s *Server) GetSiteTelemetryDailyData(c *gin.Context) {
+	ctx := c.Request.Context()
+	st := models.Site{Name: c.Param("name")}
+	if err := st.ReadSite(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.respondTelemetryDailyData(c, st.TelemetryFilter())
+}
+
+// GetStrings : lists all the strings
+func (s *Server) GetStrings(c *gin.Context) {
+	ctx := c.Request.Context()
+	strs, err := models.ListStrings(ctx, s.DB, bson.M{})
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, strs)
+}
+
+// GetString : reads a string by name
+func (s *Server) GetString(c *gin.Context) {
+	ctx := c.Request.Context()
+	str := models.String{Name: c.Param("name"), Site: c.Query("site")}
+	if err := str.ReadString(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, str)
+}
+
+// PutString : adds or updates a string, named by the path
+func (s *Server) PutString(c *gin.Context) {
+	ctx := c.Request.Context()
+	str := models.String{}
+	if err := c.ShouldBindJSON(&str); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	// The id is kept by the DB, so the one in the body is ignored
+	str.ID = primitive.NilObjectID
+	str.Name = c.Param("name")
+	res, err := str.UpsertStringInDB(ctx, s.DB)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(upsertStatusCode(res), str)
+}
+
+// DeleteString : deletes a string by name
+func (s *Server) DeleteString(c *gin.Context) {
+	ctx := c.Request.Context()
+	str := models.String{Name: c.Param("name"), Site: c.Query("site")}
+	if err := str.ReadString(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := str.DeleteStringFromDB(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetStringTelemetryData : lists the telemetry data of the modules of a string
+func (s *Server) GetStringTelemetryData(c *gin.Context) {
+	ctx := c.Request.Context()
+	str := models.String{Name: c.Param("name"), Site: c.Query("site")}
+	if err := str.ReadString(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.respondTelemetryData(c, str.TelemetryFilter())
+}
+
+// GetStringTelemetryDailyData : lists the daily telemetry data of the modules of a string
+func (s *Server) GetStringTelemetryDailyData(c *gin.Context) {
+	ctx := c.Request.Context()
+	str := models.String{Name: c.Param("name"), Site: c.Query("site")}
+	if err := str.ReadString(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.respondTelemetryDailyData(c, str.TelemetryFilter())
+}
+
+// respondTelemetryData : lists the telemetry data selected by a filter and the time range
+func (s *Server) respondTelemetryData(c *gin.Context, filter bson.M) {
+	ctx := c.Request.Context()
+	if err := addTimeRange(c, filter, "lastTelemetryTime", false); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	data, err := models.ListTelemetryData(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// respondTelemetryDailyData : lists the daily telemetry data selected by a filter and the time range
+func (s *Server) respondTelemetryDailyData(c *gin.Context, filter bson.M) {
+	ctx := c.Request.Context()
+	if err := addTimeRange(c, filter, "day", true); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	data, err := models.ListTelemetryDailyData(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// upsertStatusCode : the status code for the result of an upsert
+func upsertStatusCode(res models.UpsertResult) int {
+	if res == models.Created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
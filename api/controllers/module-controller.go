@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+)
+
+// Default health parameters of the modules
+const (
+	defaultModuleStaleAfter   = 3600
+	defaultModuleMaxDeviation = 0.2
+)
+
+// SetModuleHealth : configures after how many seconds without telemetry a module is stale and
+// how much its input current may deviate from its peers, as a fraction of their median.
+// Empty or invalid values use the defaults.
+func (s *Server) SetModuleHealth(staleAfter, maxDeviation string) {
+	s.ModuleStaleAfter = defaultModuleStaleAfter
+	if n, err := strconv.ParseInt(staleAfter, 10, 64); err == nil && n > 0 {
+		s.ModuleStaleAfter = n
+	}
+	s.ModuleMaxDeviation = defaultModuleMaxDeviation
+	if d, err := strconv.ParseFloat(maxDeviation, 64); err == nil && d > 0 {
+		s.ModuleMaxDeviation = d
+	}
+}
+
+// CheckModuleHealth : evaluates the health of the modules of every inverter
+func (s *Server) CheckModuleHealth(ctx context.Context) error {
+	serials, err := models.ListModuleSerials(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	now := deviceNow().Unix()
+	for _, serial := range serials {
+		modules, err := models.EvaluateModuleHealth(ctx, s.DB, serial, now, s.ModuleStaleAfter, s.ModuleMaxDeviation)
+		if err != nil {
+			return err
+		}
+		for _, m := range modules {
+			if m.Health != models.ModuleHealthy {
+				logging.Warn("Module is not healthy", logging.Fields{
+					logging.SerialField: m.Serial,
+					logging.ModuleField: m.Module,
+					"health":            m.Health,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// ModuleHealthCheck : periodically evaluates the health of the registered modules
+func (s *Server) ModuleHealthCheck(mPeriod int64, quit chan bool) {
+	// Prepares the timer
+	mTimer := int64(0)
+	// Runs forever
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+			// Checks timeout
+			cTime := time.Now().Unix()
+			if cTime-mTimer >= mPeriod {
+				mTimer = cTime
+				ctx, span := tracing.Start(context.Background(), "check module health")
+				err := s.CheckModuleHealth(ctx)
+				tracing.Fail(span, err)
+				span.End()
+				if err != nil {
+					logging.Error("Error while checking modules", logging.Fields{logging.ErrorField: err})
+				}
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The sources of the insolation used by the performance ratio
+const (
+	IrradianceFromReference = "reference"
+	IrradianceFromCSV       = "csv"
+	IrradianceFromModule    = "module"
+)
+
+// IrradianceConfig : where the daily insolation of the sites comes from
+type IrradianceConfig struct {
+	Source string
+	// Reference : the insolation of every day, in kWh/m²
+	Reference float64
+	// CSVPath : the file imported at startup with the insolation of each day and site
+	CSVPath string
+	// Module : the reference module, whose current gives the irradiance
+	Module string
+	// STCCurrent : the current of the reference module in the standard test conditions, in A
+	STCCurrent float64
+}
+
+// SetIrradiance : configures the source of the insolation, which can be a reference value in
+// kWh/m², a CSV file or a reference module with its current in the standard test conditions
+func (s *Server) SetIrradiance(source, reference, csvPath, module, stcCurrent string) error {
+	s.Irradiance = IrradianceConfig{
+		Source:  source,
+		CSVPath: csvPath,
+		Module:  module,
+	}
+	switch source {
+	case "":
+		return nil
+	case IrradianceFromReference:
+		r, err := strconv.ParseFloat(reference, 64)
+		if err != nil || r <= 0 {
+			return fmt.Errorf("invalid reference insolation: %v", reference)
+		}
+		s.Irradiance.Reference = r
+	case IrradianceFromCSV:
+		if csvPath == "" {
+			return fmt.Errorf("missing irradiance CSV file")
+		}
+	case IrradianceFromModule:
+		c, err := strconv.ParseFloat(stcCurrent, 64)
+		if err != nil || c <= 0 || module == "" {
+			return fmt.Errorf("invalid reference module: %v (%v A)", module, stcCurrent)
+		}
+		s.Irradiance.STCCurrent = c
+	default:
+		return fmt.Errorf("unknown irradiance source: %v", source)
+	}
+	return nil
+}
+
+// ImportIrradiance : imports the configured irradiance CSV file, if any
+func (s *Server) ImportIrradiance(ctx context.Context) error {
+	if s.Irradiance.Source != IrradianceFromCSV {
+		return nil
+	}
+	n, err := models.ImportIrradianceCSV(ctx, s.DB, s.Irradiance.CSVPath)
+	if err != nil {
+		return err
+	}
+	logging.Info("Imported the insolation", logging.Fields{"days": n, "file": s.Irradiance.CSVPath})
+	return nil
+}
+
+// Insolation : the insolation of a site in a day, in kWh/m², which is zero when unknown
+func (s *Server) Insolation(ctx context.Context, site string, day time.Time) (float64, error) {
+	switch s.Irradiance.Source {
+	case IrradianceFromReference:
+		return s.Irradiance.Reference, nil
+	case IrradianceFromCSV:
+		ir := models.Irradiance{
+			Site: site,
+			Day:  day,
+		}
+		if err := ir.ReadIrradiance(ctx, s.DB); err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return ir.Insolation, nil
+	case IrradianceFromModule:
+		return models.ModuleInsolation(ctx, s.DB, s.Irradiance.Module, day, s.Irradiance.STCCurrent)
+	}
+	return 0, nil
+}
+
+// UpdatePerformance : computes the specific yield and the performance ratio of each inverter
+// with energy in a day, and of each site. The peak power of an inverter is the sum of the peak
+// power of its strings, and the one of a site is its own or, if not given, the sum of the ones
+// of its inverters. An inverter out of the sites takes the insolation of no site.
+func (s *Server) UpdatePerformance(ctx context.Context, day time.Time) error {
+	sites, err := models.ListSites(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	strs, err := models.ListStrings(ctx, s.DB, bson.M{})
+	if err != nil {
+		return err
+	}
+	inverters, err := models.ListDailyPerformance(ctx, s.DB, bson.M{
+		"scope": models.InverterScope,
+		"day":   models.StartOfDay(day),
+	})
+	if err != nil {
+		return err
+	}
+	peakPowers := map[string]float64{}
+	for _, str := range strs {
+		peakPowers[str.Serial] += str.PeakPower
+	}
+	siteOf := map[string]string{}
+	for _, st := range sites {
+		for _, serial := range st.Inverters {
+			siteOf[serial] = st.Name
+		}
+	}
+	insolations := map[string]float64{}
+	insolation := func(site string) (float64, error) {
+		if in, ok := insolations[site]; ok {
+			return in, nil
+		}
+		in, err := s.Insolation(ctx, site, day)
+		if err != nil {
+			return 0, err
+		}
+		insolations[site] = in
+		return in, nil
+	}
+	bySerial := map[string]*models.DailyPerformance{}
+	for _, ip := range inverters {
+		in, err := insolation(siteOf[ip.Name])
+		if err != nil {
+			return err
+		}
+		ip.PeakPower = peakPowers[ip.Name]
+		ip.Insolation = in
+		ip.ComputeIndicators()
+		if _, err := ip.UpsertDailyPerformanceInDB(ctx, s.DB); err != nil {
+			return err
+		}
+		bySerial[ip.Name] = ip
+	}
+	for _, st := range sites {
+		in, err := insolation(st.Name)
+		if err != nil {
+			return err
+		}
+		sp := models.DailyPerformance{
+			Scope:      models.SiteScope,
+			Name:       st.Name,
+			Day:        day,
+			PeakPower:  st.PeakPower,
+			Insolation: in,
+		}
+		sum := 0.0
+		for _, serial := range st.Inverters {
+			ip, ok := bySerial[serial]
+			if !ok {
+				continue
+			}
+			sp.Energy += ip.Energy
+			sum += ip.PeakPower
+		}
+		if sp.PeakPower <= 0 {
+			sp.PeakPower = sum
+		}
+		sp.ComputeIndicators()
+		if _, err := sp.UpsertDailyPerformanceInDB(ctx, s.DB); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSitePerformance : lists the daily performance indicators of a site
+func (s *Server) GetSitePerformance(c *gin.Context) {
+	ctx := c.Request.Context()
+	st := models.Site{Name: c.Param("name")}
+	if err := st.ReadSite(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.respondDailyPerformance(c, bson.M{"scope": models.SiteScope, "name": st.Name})
+}
+
+// GetInverterPerformance : lists the daily performance indicators of an inverter
+func (s *Server) GetInverterPerformance(c *gin.Context) {
+	s.respondDailyPerformance(c, bson.M{"scope": models.InverterScope, "name": c.Param("serial")})
+}
+
+func (s *Server) respondDailyPerformance(c *gin.Context, filter bson.M) {
+	ctx := c.Request.Context()
+	if err := addTimeRange(c, filter, "day", true); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	perfs, err := models.ListDailyPerformance(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, perfs)
+}
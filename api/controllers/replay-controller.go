@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+)
+
+// replayBaseURL : the base URL of the replayed pages, which are never fetched from the network
+const replayBaseURL = "http://replay/"
+
+// ReplayPage : a captured page, in the "<path>/<Unix time of the fetch>.html" file of the
+// replay directory
+type ReplayPage struct {
+	Path      string
+	FetchedAt time.Time
+	File      string
+}
+
+// replayTransport : answers the requests of the collectors with the captured pages, passing
+// the requests to other hosts to the next transport
+type replayTransport struct {
+	mutex sync.Mutex
+	pages map[string][]byte
+	next  http.RoundTripper
+}
+
+func (rt *replayTransport) set(pageURL string, content []byte) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.pages[pageURL] = content
+}
+
+// RoundTrip : answers a request with the page set for its URL
+func (rt *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.String(), replayBaseURL) {
+		return rt.next.RoundTrip(req)
+	}
+	rt.mutex.Lock()
+	content, ok := rt.pages[req.URL.String()]
+	rt.mutex.Unlock()
+	res := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Request:    req,
+	}
+	if !ok {
+		res.Status = "404 Not Found"
+		res.StatusCode = http.StatusNotFound
+		content = []byte{}
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(content))
+	res.ContentLength = int64(len(content))
+	return res, nil
+}
+
+// SetReplay : configures the directory of the captured pages to replay instead of scraping
+// the device, and how many times faster than the capture they are replayed. A speed of zero
+// replays them as fast as possible. An empty directory scrapes the device.
+func (s *Server) SetReplay(dir, speed string) error {
+	s.ReplayDir = dir
+	s.ReplaySpeed = 1
+	if speed != "" {
+		sp, err := strconv.ParseFloat(speed, 64)
+		if err != nil || sp < 0 {
+			return fmt.Errorf("invalid replay speed: %v", speed)
+		}
+		s.ReplaySpeed = sp
+	}
+	return nil
+}
+
+// ReadReplayDir : lists the captured pages of a directory, sorted by their fetch time
+func ReadReplayDir(dir string) ([]*ReplayPage, error) {
+	pages := []*ReplayPage{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(file) != ".html" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(filepath.Dir(rel))
+		ts, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(file), ".html"), 10, 64)
+		if err != nil || path == "." {
+			logging.Warn("Skipping a file that is not a captured page", logging.Fields{"file": file})
+			return nil
+		}
+		pages = append(pages, &ReplayPage{
+			Path:      path,
+			FetchedAt: time.Unix(ts, 0).UTC(),
+			File:      file,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		if !pages[i].FetchedAt.Equal(pages[j].FetchedAt) {
+			return pages[i].FetchedAt.Before(pages[j].FetchedAt)
+		}
+		return pages[i].Path < pages[j].Path
+	})
+	return pages, nil
+}
+
+// fetchTime : when the page of a request was fetched, which is the capture time of the replayed
+// pages and the current time of the scraped ones
+func fetchTime(r *colly.Request) time.Time {
+	if r != nil && r.Ctx != nil {
+		if t, err := time.Parse(time.RFC3339, r.Ctx.Get("fetchedAt")); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// replayCollector : the collector of a captured path, by the kind of the target with the same
+// path, with the context its callbacks need, named after the target as the scraped pages
+func (s *Server) replayCollector(p *ReplayPage) (*colly.Collector, *colly.Context) {
+	ctx := colly.NewContext()
+	ctx.Put("fetchedAt", p.FetchedAt.Format(time.RFC3339))
+	for _, t := range s.Targets() {
+		u, err := url.Parse(t.URL)
+		if err != nil || strings.Trim(u.Path, "/") != p.Path {
+			continue
+		}
+		ctx.Put("target", t.Name)
+		switch t.Kind {
+		case models.InverterKind:
+			return s.InverterCollector, ctx
+		case models.DiscoveryKind:
+			ctx.Put("source", t.Name)
+			ctx.Put("baseURL", replayBaseURL)
+			return s.DiscoveryCollector, ctx
+		}
+	}
+	return s.TelemetryCollector, ctx
+}
+
+// Replay : feeds the captured pages of a directory to the collectors, in the order and at the
+// pace they were captured, multiplied by a speed. The pages go through the same callbacks as
+// the scraped ones.
+func (s *Server) Replay(dir string, speed float64, quit chan bool) error {
+	pages, err := ReadReplayDir(dir)
+	if err != nil {
+		return err
+	}
+	rt := &replayTransport{
+		pages: map[string][]byte{},
+		next:  s.Transport,
+	}
+	if rt.next == nil {
+		rt.next = http.DefaultTransport
+	}
+	for _, c := range []*colly.Collector{s.InverterCollector, s.TelemetryCollector, s.DiscoveryCollector} {
+		c.WithTransport(rt)
+	}
+	logging.Info("Replaying the captured pages", logging.Fields{"pages": len(pages), "dir": dir})
+	for i, p := range pages {
+		// Waits as long as between the captures
+		if speed > 0 && i > 0 {
+			wait := time.Duration(float64(p.FetchedAt.Sub(pages[i-1].FetchedAt)) / speed)
+			select {
+			case <-quit:
+				return nil
+			case <-time.After(wait):
+			}
+		}
+		content, err := ioutil.ReadFile(p.File)
+		if err != nil {
+			return err
+		}
+		pageURL := replayBaseURL + p.Path + "/"
+		rt.set(pageURL, content)
+		c, ctx := s.replayCollector(p)
+		if err := c.Request("GET", pageURL, nil, ctx, nil); err != nil {
+			logging.Warn("Error while replaying the page", logging.Fields{"file": p.File, logging.ErrorField: err})
+		}
+	}
+	logging.Info("Replay finished")
+	return nil
+}
@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationBatchSize : the number of documents copied at once from a capped collection
+const migrationBatchSize = 1000
+
+// SetRetention : configures for how many days the raw and the rolled up data are kept.
+// Empty or invalid values keep the data forever, and longer ones than the TTL indexes accept
+// fail when applying the retention.
+func (s *Server) SetRetention(telemetryDays, rollupDays string) {
+	s.TelemetryRetention = parseRetentionDays(telemetryDays)
+	s.RollupRetention = parseRetentionDays(rollupDays)
+}
+
+func parseRetentionDays(days string) time.Duration {
+	d, err := strconv.ParseInt(days, 10, 64)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	// Longer retentions are kept as the longest duration, which the TTL indexes reject
+	if d > int64(math.MaxInt64/(24*time.Hour)) {
+		return math.MaxInt64
+	}
+	return time.Duration(d) * 24 * time.Hour
+}
+
+// expireAfterSeconds : the seconds of a retention, which must fit the 32 bits of the TTL indexes
+func expireAfterSeconds(coll string, retention time.Duration) (int64, error) {
+	seconds := int64(retention.Seconds())
+	if seconds > math.MaxInt32 {
+		return 0, fmt.Errorf("the retention of %v is longer than %v seconds", coll, math.MaxInt32)
+	}
+	return seconds, nil
+}
+
+// ApplyRetentionPolicies : makes the TTL indexes of the collections follow the configured retention
+func (s *Server) ApplyRetentionPolicies(ctx context.Context) error {
+	if err := s.ensureTelemetryDataExpiration(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "rejectedData", "receivedAt", s.TelemetryRetention); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "telemetryDailyData", "day", s.RollupRetention); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "dailyPerformance", "day", s.RollupRetention); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "energyLedger", "day", s.RollupRetention); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "energyDaily", "day", s.RollupRetention); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "telemetryGaps", "day", s.RollupRetention); err != nil {
+		return err
+	}
+	if err := s.ensureTTLIndex(ctx, "telemetryCompleteness", "day", s.RollupRetention); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ensureTelemetryDataExpiration : applies the retention of the raw telemetry data, which is a
+// collection option for time-series collections and a TTL index otherwise
+func (s *Server) ensureTelemetryDataExpiration(ctx context.Context) error {
+	info, err := s.readCollectionInfo(ctx, "telemetryData")
+	if err != nil || info == nil {
+		return err
+	}
+	if info.Type != "timeseries" {
+		return s.ensureTTLIndex(ctx, "telemetryData", "telemetryTime", s.TelemetryRetention)
+	}
+	seconds, err := expireAfterSeconds("telemetryData", s.TelemetryRetention)
+	if err != nil {
+		return err
+	}
+	if seconds == info.Options.ExpireAfterSeconds {
+		return nil
+	}
+	var expire interface{} = seconds
+	if seconds <= 0 {
+		expire = "off"
+	}
+	cmd := bson.D{
+		{Key: "collMod", Value: "telemetryData"},
+		{Key: "expireAfterSeconds", Value: expire},
+	}
+	return s.DB.RunCommand(ctx, cmd).Err()
+}
+
+// ensureTTLIndex : creates, updates or drops the TTL index of a date field in a collection
+func (s *Server) ensureTTLIndex(ctx context.Context, coll, field string, retention time.Duration) error {
+	seconds, err := expireAfterSeconds(coll, retention)
+	if err != nil {
+		return err
+	}
+	name := field + "_ttl"
+	idx := s.DB.Collection(coll).Indexes()
+	// Looks for the current TTL index
+	cur, err := idx.List(ctx)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	found := false
+	current := int64(0)
+	for cur.Next(ctx) {
+		var spec struct {
+			Name               string `bson:"name"`
+			ExpireAfterSeconds int64  `bson:"expireAfterSeconds"`
+		}
+		if err := cur.Decode(&spec); err != nil {
+			return err
+		}
+		if spec.Name == name {
+			found = true
+			current = spec.ExpireAfterSeconds
+		}
+	}
+	switch {
+	case retention <= 0 && found:
+		_, err := idx.DropOne(ctx, name)
+		return err
+	case retention <= 0:
+		return nil
+	case !found:
+		mod := mongo.IndexModel{
+			Keys:    bson.D{{Key: field, Value: 1}},
+			Options: options.Index().SetName(name).SetExpireAfterSeconds(int32(seconds)),
+		}
+		_, err := idx.CreateOne(ctx, mod)
+		return err
+	case current != seconds:
+		cmd := bson.D{
+			{Key: "collMod", Value: coll},
+			{Key: "index", Value: bson.M{"name": name, "expireAfterSeconds": seconds}},
+		}
+		return s.DB.RunCommand(ctx, cmd).Err()
+	}
+	return nil
+}
+
+// collectionInfo : the layout of an existing collection
+type collectionInfo struct {
+	Type    string `bson:"type"`
+	Options struct {
+		Capped             bool  `bson:"capped"`
+		ExpireAfterSeconds int64 `bson:"expireAfterSeconds"`
+	} `bson:"options"`
+}
+
+// readCollectionInfo : reads the layout of a collection, which is nil if it doesn't exist
+func (s *Server) readCollectionInfo(ctx context.Context, coll string) (*collectionInfo, error) {
+	cur, err := s.DB.ListCollections(ctx, bson.M{"name": coll})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var info collectionInfo
+		if err := cur.Decode(&info); err != nil {
+			return nil, err
+		}
+		return &info, nil
+	}
+	return nil, cur.Err()
+}
+
+// MigrateCappedCollection : moves the data of a capped collection to a normal one, created by setup
+func (s *Server) MigrateCappedCollection(ctx context.Context, coll string, setup func(context.Context) error) error {
+	info, err := s.readCollectionInfo(ctx, coll)
+	if err != nil {
+		return err
+	}
+	capped := info != nil && info.Options.Capped
+	return s.migrateCollection(ctx, coll, capped, setup, nil)
+}
+
+// copiedIDs : the ids of some documents that are already in a collection, as when resuming a
+// migration into a collection without unique indexes
+func (s *Server) copiedIDs(ctx context.Context, coll string, ids []interface{}) (map[string]bool, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cur, err := s.DB.Collection(coll).Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	copied := map[string]bool{}
+	for cur.Next(ctx) {
+		copied[cur.Current.Lookup("_id").String()] = true
+	}
+	return copied, cur.Err()
+}
+
+// migrateCollection : moves the data of a collection to a new one, created by setup, transforming
+// each document if needed. An interrupted migration is resumed from the renamed old collection,
+// skipping the documents already copied.
+func (s *Server) migrateCollection(ctx context.Context, coll string, needed bool, setup func(context.Context) error, transform func(bson.Raw) (interface{}, error)) error {
+	old := coll + "Migrating"
+	resuming := !needed
+	if needed {
+		logging.Info("Migrating collection", logging.Fields{"collection": coll})
+		// Renames the old collection so the new one can take its name
+		rename := bson.D{
+			{Key: "renameCollection", Value: s.DB.Name() + "." + coll},
+			{Key: "to", Value: s.DB.Name() + "." + old},
+		}
+		if err := s.DB.Client().Database("admin").RunCommand(ctx, rename).Err(); err != nil {
+			return err
+		}
+		if err := setup(ctx); err != nil {
+			return err
+		}
+	}
+	colls, err := s.DB.ListCollectionNames(ctx, bson.M{"name": old})
+	if err != nil || len(colls) == 0 {
+		return err
+	}
+	// Copies the documents in batches. When resuming, the ones already copied are looked up,
+	// as the new collection may not have unique indexes to reject them.
+	cur, err := s.DB.Collection(old).Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	opts := options.InsertMany().SetOrdered(false)
+	batch := []interface{}{}
+	keys := []string{}
+	ids := []interface{}{}
+	copied := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if resuming {
+			done, err := s.copiedIDs(ctx, coll, ids)
+			if err != nil {
+				return err
+			}
+			remaining := []interface{}{}
+			for i, doc := range batch {
+				if !done[keys[i]] {
+					remaining = append(remaining, doc)
+				}
+			}
+			batch = remaining
+		}
+		if len(batch) > 0 {
+			if _, err := s.DB.Collection(coll).InsertMany(ctx, batch, opts); err != nil && !models.IsDuplicateKeyError(err) {
+				return err
+			}
+		}
+		copied += len(batch)
+		batch = []interface{}{}
+		keys = []string{}
+		ids = []interface{}{}
+		return nil
+	}
+	for cur.Next(ctx) {
+		var doc interface{}
+		raw := make(bson.Raw, len(cur.Current))
+		copy(raw, cur.Current)
+		doc = raw
+		if transform != nil {
+			if doc, err = transform(raw); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, doc)
+		id := raw.Lookup("_id")
+		keys = append(keys, id.String())
+		ids = append(ids, id)
+		if len(batch) >= migrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	logging.Info("Copied documents", logging.Fields{"documents": copied, "from": old, "to": coll})
+	return s.DB.Collection(old).Drop(ctx)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+)
+
+// deviceNow : the current local wall clock read as UTC, which is how the device times
+// in the telemetry pages are parsed
+func deviceNow() time.Time {
+	return deviceTime(time.Now())
+}
+
+// deviceTime : the local wall clock of a time read as UTC
+func deviceTime(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// UpdateRollups : updates the daily rollups, performance indicators, energy and completeness
+// of the current and the previous day
+func (s *Server) UpdateRollups(ctx context.Context) error {
+	today := models.StartOfDay(deviceNow())
+	for _, d := range []time.Time{today.AddDate(0, 0, -1), today} {
+		if err := s.UpdateDayRollups(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateDayRollups : updates the daily rollups, performance indicators, energy and
+// completeness of a day
+func (s *Server) UpdateDayRollups(ctx context.Context, d time.Time) error {
+	if err := models.UpdateTelemetryDailyData(ctx, s.DB, d); err != nil {
+		return err
+	}
+	if err := s.UpdatePerformance(ctx, d); err != nil {
+		return err
+	}
+	if err := s.UpdateEnergyLedger(ctx, d); err != nil {
+		return err
+	}
+	_, err := s.UpdateCompleteness(ctx, d)
+	return err
+}
+
+// RollupAggregation : periodically aggregates the acquired telemetry data
+func (s *Server) RollupAggregation(rPeriod int64, quit chan bool) {
+	// Prepares the timer
+	rTimer := int64(0)
+	// Runs forever
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+			// Checks timeout
+			cTime := time.Now().Unix()
+			if cTime-rTimer >= rPeriod {
+				rTimer = cTime
+				ctx, span := tracing.Start(context.Background(), "update rollups")
+				err := s.UpdateRollups(ctx)
+				tracing.Fail(span, err)
+				span.End()
+				if err != nil {
+					logging.Error("Error while updating rollups", logging.Fields{logging.ErrorField: err})
+				}
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// InitializeRouter : creates the router of the service API
+func (s *Server) InitializeRouter() {
+	gin.SetMode(gin.ReleaseMode)
+	s.Router = gin.New()
+	s.Router.Use(logRequests, gin.Recovery())
+	s.initializeRoutes()
+}
+
+// logRequests : logs each request to the API with its status and duration
+func logRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	fields := logging.Fields{
+		"method":              c.Request.Method,
+		"path":                c.Request.URL.Path,
+		"status":              c.Writer.Status(),
+		logging.DurationField: time.Since(start),
+	}
+	if len(c.Errors) > 0 {
+		fields[logging.ErrorField] = c.Errors.String()
+	}
+	logging.Info("Served request", fields)
+}
+
+// ServeAPI : serves the service API in a given port
+func (s *Server) ServeAPI(apiPort string) {
+	go func() {
+		if err := s.Router.Run(":" + apiPort); err != nil {
+			logging.Fatal("Error while serving the API", logging.Fields{logging.ErrorField: err})
+		}
+	}()
+}
+
+func (s *Server) initializeRoutes() {
+	// Health and readiness
+	s.Router.GET("/healthz", s.GetHealth)
+	s.Router.GET("/readyz", s.GetReadiness)
+	// Plant layout
+	s.Router.GET("/sites", s.GetSites)
+	s.Router.GET("/sites/:name", s.GetSite)
+	s.Router.PUT("/sites/:name", s.PutSite)
+	s.Router.DELETE("/sites/:name", s.DeleteSite)
+	s.Router.GET("/sites/:name/strings", s.GetSiteStrings)
+	s.Router.GET("/sites/:name/telemetry", s.GetSiteTelemetryData)
+	s.Router.GET("/sites/:name/daily", s.GetSiteTelemetryDailyData)
+	s.Router.GET("/sites/:name/performance", s.GetSitePerformance)
+	s.Router.GET("/strings", s.GetStrings)
+	s.Router.GET("/strings/:name", s.GetString)
+	s.Router.PUT("/strings/:name", s.PutString)
+	s.Router.DELETE("/strings/:name", s.DeleteString)
+	s.Router.GET("/strings/:name/telemetry", s.GetStringTelemetryData)
+	s.Router.GET("/strings/:name/daily", s.GetStringTelemetryDailyData)
+	// Performance indicators
+	s.Router.GET("/inverters/:serial/performance", s.GetInverterPerformance)
+	s.Router.GET("/inverters/:serial/energy", s.GetInverterEnergy)
+	// Module anomalies
+	s.Router.GET("/anomalies", s.GetAnomalies)
+	s.Router.PUT("/anomalies/:id/reviewed", s.ReviewAnomaly)
+	// Rejected reads
+	s.Router.GET("/rejected", s.GetRejectedData)
+	// Scrape outcomes
+	s.Router.GET("/scrapes", s.GetScrapeStatus)
+	// Scraped targets
+	s.Router.GET("/targets", s.GetTargets)
+	s.Router.POST("/targets", s.PostTarget)
+	s.Router.GET("/targets/changes", s.GetTargetChanges)
+	s.Router.PUT("/targets/:name/pause", s.PauseTargetHandler)
+	s.Router.PUT("/targets/:name/resume", s.ResumeTargetHandler)
+	s.Router.DELETE("/targets/:name", s.DeleteTarget)
+}
+
+// respondError : writes an error as the JSON response
+func respondError(c *gin.Context, code int, err error) {
+	c.JSON(code, gin.H{"error": err.Error()})
+}
+
+// addTimeRange : restricts a filter to the "from" and "to" query parameters, which are
+// Unix times, applied to a field with Unix times or with dates
+func addTimeRange(c *gin.Context, filter bson.M, field string, dates bool) error {
+	rng := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		if dates {
+			rng[op] = time.Unix(t, 0).UTC()
+		} else {
+			rng[op] = t
+		}
+	}
+	if len(rng) > 0 {
+		filter[field] = rng
+	}
+	return nil
+}
+
+// errorCode : the status code for the errors of the models
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound), errors.Is(err, mongo.ErrNoDocuments):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrDuplicate):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrUnavailable), models.IsUnavailableError(err):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+)
+
+// ScrapeStatus : the outcome of the visits to a page
+type ScrapeStatus struct {
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+	// Target, Labels : the name and the labels of the target of the page
+	Target      string            `json:"target,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	LastSuccess time.Time         `json:"lastSuccess"`
+	LastFailure time.Time         `json:"lastFailure"`
+	LastError   string            `json:"lastError,omitempty"`
+	// Failures : the failed visits since the last successful one
+	Failures int64 `json:"failures"`
+}
+
+// scrapeTracker : the outcome of the visits to each page, by URL
+type scrapeTracker struct {
+	mutex sync.RWMutex
+	pages map[string]*ScrapeStatus
+}
+
+func (s *Server) scrapeStatus(kind, url string) *ScrapeStatus {
+	if s.scrapes.pages == nil {
+		s.scrapes.pages = map[string]*ScrapeStatus{}
+	}
+	st, ok := s.scrapes.pages[url]
+	if !ok {
+		st = &ScrapeStatus{Kind: kind, URL: url}
+		s.scrapes.pages[url] = st
+	}
+	return st
+}
+
+// requestDuration : how long ago a request of a collector was made
+func requestDuration(r *colly.Request) time.Duration {
+	if r == nil || r.Ctx == nil {
+		return 0
+	}
+	t, err := time.Parse(time.RFC3339Nano, r.Ctx.Get("requestedAt"))
+	if err != nil {
+		return 0
+	}
+	return time.Since(t)
+}
+
+// scrapeSucceeded : records a page that was fetched and parsed
+func (s *Server) scrapeSucceeded(kind string, r *colly.Request) {
+	url := r.URL.String()
+	logging.Debug("Scraped page", logging.Fields{
+		logging.KindField:     kind,
+		logging.URLField:      url,
+		logging.DurationField: requestDuration(r),
+	})
+	s.scrapes.mutex.Lock()
+	defer s.scrapes.mutex.Unlock()
+	st := s.scrapeStatus(kind, url)
+	st.LastSuccess = time.Now()
+	st.Failures = 0
+}
+
+// scrapeFailed : records a page that could not be fetched or parsed
+func (s *Server) scrapeFailed(kind string, r *colly.Request, err error) {
+	url := r.URL.String()
+	logging.Warn("Error while scraping page", logging.Fields{
+		logging.KindField:     kind,
+		logging.URLField:      url,
+		logging.DurationField: requestDuration(r),
+		logging.ErrorField:    err,
+	})
+	failVisit(r, err)
+	s.scrapes.mutex.Lock()
+	defer s.scrapes.mutex.Unlock()
+	st := s.scrapeStatus(kind, url)
+	st.LastFailure = time.Now()
+	st.LastError = err.Error()
+	st.Failures++
+}
+
+// trackScrapes : records the outcome, the duration and the spans of the visits of a collector,
+// whose root element callback marks the pages it parsed with the "parsed" context key
+func (s *Server) trackScrapes(kind string, c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		r.Ctx.Put("requestedAt", time.Now().Format(time.RFC3339Nano))
+		logging.Debug("Visiting page", logging.Fields{logging.KindField: kind, logging.URLField: r.URL.String()})
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		s.scrapeFailed(kind, r.Request, err)
+	})
+	c.OnScraped(func(r *colly.Response) {
+		if r.Ctx.Get("parsed") == "" {
+			s.scrapeFailed(kind, r.Request, fmt.Errorf("root element not found"))
+		}
+	})
+	s.traceVisits(kind, c)
+}
+
+// ScrapeStatuses : the outcome of the visits to each page, sorted by URL
+func (s *Server) ScrapeStatuses() []ScrapeStatus {
+	targets := map[string]models.Target{}
+	for _, t := range s.scheduledTargets() {
+		targets[t.URL] = t
+	}
+	s.scrapes.mutex.RLock()
+	defer s.scrapes.mutex.RUnlock()
+	statuses := []ScrapeStatus{}
+	for _, st := range s.scrapes.pages {
+		status := *st
+		if t, ok := targets[st.URL]; ok {
+			status.Target = t.Name
+			status.Labels = t.Labels
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+	return statuses
+}
+
+// GetScrapeStatus : lists the outcome of the visits to each page
+func (s *Server) GetScrapeStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, s.ScrapeStatuses())
+}
@@ -0,0 +1,451 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// targetRegistry : the targets scraped by the service, by name, and the default period of
+// each kind
+type targetRegistry struct {
+	mutex   sync.RWMutex
+	targets map[string]*models.Target
+	periods map[string]int64
+}
+
+// targetsFile : the contents of the targets file
+type targetsFile struct {
+	Targets []models.Target `json:"targets"`
+}
+
+// SetTargets : configures the targets from a JSON file. An empty path takes the paths of the
+// APP_HOST device as the targets.
+func (s *Server) SetTargets(path string) error {
+	s.targets.mutex.Lock()
+	s.targets.targets = map[string]*models.Target{}
+	s.targets.mutex.Unlock()
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f := targetsFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	for _, t := range f.Targets {
+		if err := s.AddTarget(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPathTargets : configures the default period of each kind and, when no targets file was
+// given, takes the inverter, telemetry and discovery paths of a device as the targets
+func (s *Server) SetPathTargets(baseURL, iPeriod, tPeriod, dPeriod string) error {
+	periods := map[string]int64{}
+	for kind, period := range map[string]string{
+		models.InverterKind:      iPeriod,
+		models.TelemetryDataKind: tPeriod,
+		models.DiscoveryKind:     dPeriod,
+	} {
+		if p, err := strconv.ParseInt(period, 10, 64); err == nil {
+			periods[kind] = p
+		}
+	}
+	s.targets.mutex.Lock()
+	s.targets.periods = periods
+	configured := len(s.targets.targets) > 0
+	s.targets.mutex.Unlock()
+	if configured {
+		return nil
+	}
+	for kind, paths := range map[string][]string{
+		models.InverterKind:      s.InverterPaths,
+		models.TelemetryDataKind: s.TelemetryPaths,
+		models.DiscoveryKind:     s.DiscoveryPaths,
+	} {
+		for _, p := range paths {
+			if p == "" {
+				continue
+			}
+			if err := s.AddTarget(models.Target{URL: baseURL + p + "/", Kind: kind}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddTarget : starts scraping a target, whose name must not be taken
+func (s *Server) AddTarget(t models.Target) error {
+	if err := t.Check(); err != nil {
+		return err
+	}
+	s.targets.mutex.Lock()
+	defer s.targets.mutex.Unlock()
+	if s.targets.targets == nil {
+		s.targets.targets = map[string]*models.Target{}
+	}
+	if _, ok := s.targets.targets[t.Name]; ok {
+		return models.Duplicate("target %v", t.Name)
+	}
+	s.targets.targets[t.Name] = &t
+	return nil
+}
+
+// RemoveTarget : stops scraping a target
+func (s *Server) RemoveTarget(name string) error {
+	s.targets.mutex.Lock()
+	defer s.targets.mutex.Unlock()
+	if _, ok := s.targets.targets[name]; !ok {
+		return models.NotFound("target %v", name)
+	}
+	delete(s.targets.targets, name)
+	return nil
+}
+
+// PauseTarget : stops or resumes visiting a target, which is kept
+func (s *Server) PauseTarget(name string, paused bool) error {
+	s.targets.mutex.Lock()
+	defer s.targets.mutex.Unlock()
+	t, ok := s.targets.targets[name]
+	if !ok {
+		return models.NotFound("target %v", name)
+	}
+	t.Paused = paused
+	return nil
+}
+
+// LoadTargets : merges the targets stored in the DB, which were managed at runtime, with the
+// configured ones, which seed the DB. A stored target replaces the configured one of the same
+// name, and the configured targets not stored yet are stored, unless they were deleted.
+func (s *Server) LoadTargets(ctx context.Context) error {
+	stored, err := models.ListTargets(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	deleted, err := models.ListDeletedTargets(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+	byName := map[string]*models.Target{}
+	for _, t := range stored {
+		byName[t.Name] = t
+	}
+	seeded := 0
+	removed := []string{}
+	for _, t := range s.Targets() {
+		st, ok := byName[t.Name]
+		if !ok && deleted[t.Name] {
+			removed = append(removed, t.Name)
+			continue
+		}
+		if !ok {
+			if err := t.AddTargetToDB(ctx, s.DB); err != nil {
+				return err
+			}
+			seeded++
+			continue
+		}
+		if !reflect.DeepEqual(t, *st) {
+			logging.Warn("Configured target overridden by the stored one", logging.Fields{
+				logging.TargetField: t.Name,
+				logging.URLField:    st.URL,
+				"configuredURL":     t.URL,
+			})
+		}
+	}
+	s.targets.mutex.Lock()
+	defer s.targets.mutex.Unlock()
+	if s.targets.targets == nil {
+		s.targets.targets = map[string]*models.Target{}
+	}
+	for _, name := range removed {
+		delete(s.targets.targets, name)
+	}
+	for _, t := range stored {
+		s.targets.targets[t.Name] = t
+	}
+	logging.Info("Loaded the targets", logging.Fields{"stored": len(stored), "seeded": seeded,
+		"deleted": len(removed), "targets": len(s.targets.targets)})
+	return nil
+}
+
+// Targets : the configured targets, sorted by name
+func (s *Server) Targets() []models.Target {
+	s.targets.mutex.RLock()
+	defer s.targets.mutex.RUnlock()
+	targets := []models.Target{}
+	for _, t := range s.targets.targets {
+		targets = append(targets, *t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets
+}
+
+// targetPeriod : the seconds between the visits of a target, where zero is never
+func (s *Server) targetPeriod(t models.Target) int64 {
+	if t.Period > 0 {
+		return t.Period
+	}
+	s.targets.mutex.RLock()
+	defer s.targets.mutex.RUnlock()
+	return s.targets.periods[t.Kind]
+}
+
+// scheduledTargets : the configured targets together with the discovered telemetry pages
+func (s *Server) scheduledTargets() []models.Target {
+	targets := s.Targets()
+	return append(targets, s.DiscoveredTelemetry(targets)...)
+}
+
+// visitTarget : scrapes a target with the collector of its kind, in a span that is a child
+// of the one in a context
+func (s *Server) visitTarget(ctx context.Context, t models.Target) error {
+	switch t.Kind {
+	case models.InverterKind:
+		return s.InverterCollector.Request("GET", t.URL, nil, visitContext(ctx, t), nil)
+	case models.TelemetryDataKind:
+		return s.TelemetryCollector.Request("GET", t.URL, nil, visitContext(ctx, t), nil)
+	default:
+		return s.DiscoverTelemetry(ctx, t)
+	}
+}
+
+// visitTargets : scrapes some targets at once, in the span of an acquisition cycle that ends
+// with the last visit
+func (s *Server) visitTargets(targets []models.Target) {
+	ctx, span := tracing.Start(context.Background(), "acquisition cycle", attribute.Int("targets", len(targets)))
+	wg := sync.WaitGroup{}
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t models.Target) {
+			defer wg.Done()
+			s.visitTarget(ctx, t)
+		}(t)
+	}
+	go func() {
+		wg.Wait()
+		span.End()
+	}()
+}
+
+// Acquisition : visits each target in its period, following the targets added, paused and
+// removed meanwhile, where new targets are visited at once
+func (s *Server) Acquisition(quit chan bool) {
+	// The last visit of each target, by name
+	timers := map[string]int64{}
+	s.acquisitionStarted()
+	// Runs forever
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+			current := map[string]bool{}
+			visited := map[string]bool{}
+			due := []models.Target{}
+			for _, t := range s.scheduledTargets() {
+				current[t.Name] = true
+				period := s.targetPeriod(t)
+				if period <= 0 || t.Paused {
+					continue
+				}
+				visited[t.Name] = true
+				// Checks timeout
+				cTime := time.Now().Unix()
+				if last, ok := timers[t.Name]; !ok || cTime-last >= period {
+					timers[t.Name] = cTime
+					due = append(due, t)
+				}
+			}
+			s.acquisitionAlive(visited)
+			if len(due) > 0 {
+				s.visitTargets(due)
+			}
+			// Forgets the removed targets
+			for name := range timers {
+				if !current[name] {
+					delete(timers, name)
+				}
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+// SetProxyUserHeader : configures the header where an authenticating proxy in front of the API
+// sets the user, as "X-User". An empty header trusts no proxy.
+func (s *Server) SetProxyUserHeader(header string) {
+	s.ProxyUserHeader = header
+}
+
+// clientAddress : the address of the client of a request, where the forwarded ones are only
+// trusted behind an authenticating proxy
+func (s *Server) clientAddress(c *gin.Context) string {
+	if s.ProxyUserHeader != "" {
+		return c.ClientIP()
+	}
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// targetActor : who makes a change through the API, which is the user set by the authenticating
+// proxy, if configured, or else the client address
+func (s *Server) targetActor(c *gin.Context) string {
+	if s.ProxyUserHeader != "" {
+		if user := c.GetHeader(s.ProxyUserHeader); user != "" {
+			return user
+		}
+	}
+	return s.clientAddress(c)
+}
+
+// auditTarget : records a change made to a target through the API
+func (s *Server) auditTarget(c *gin.Context, action string, t models.Target) {
+	ctx := c.Request.Context()
+	change := models.TargetChange{
+		Time:   time.Now().UTC(),
+		Actor:  s.targetActor(c),
+		Client: s.clientAddress(c),
+		Action: action,
+		Name:   t.Name,
+		Target: t,
+	}
+	if err := change.AddTargetChangeToDB(ctx, s.DB); err != nil {
+		logging.Error("Error while auditing the target change", logging.Fields{logging.TargetField: t.Name,
+			"action": action, logging.ErrorField: err})
+	}
+}
+
+// GetTargets : lists the targets
+func (s *Server) GetTargets(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Targets())
+}
+
+// PostTarget : adds a target, which is visited from then on. The target is stored only after
+// being scheduled, so a name already taken by a configured target is not stored.
+func (s *Server) PostTarget(c *gin.Context) {
+	ctx := c.Request.Context()
+	t := models.Target{}
+	if err := c.ShouldBindJSON(&t); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := t.Check(); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := s.AddTarget(t); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := t.AddTargetToDB(ctx, s.DB); err != nil {
+		s.RemoveTarget(t.Name)
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.auditTarget(c, models.TargetAdded, t)
+	c.JSON(http.StatusCreated, t)
+}
+
+// setTargetPaused : pauses or resumes a target by name
+func (s *Server) setTargetPaused(c *gin.Context, paused bool) {
+	ctx := c.Request.Context()
+	t := models.Target{Name: c.Param("name")}
+	if err := t.ReadTarget(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := t.PauseTargetInDB(ctx, s.DB, paused); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := s.PauseTarget(t.Name, paused); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	action := models.TargetResumed
+	if paused {
+		action = models.TargetPaused
+	}
+	s.auditTarget(c, action, t)
+	c.JSON(http.StatusOK, t)
+}
+
+// PauseTargetHandler : stops visiting a target by name, which is kept
+func (s *Server) PauseTargetHandler(c *gin.Context) {
+	s.setTargetPaused(c, true)
+}
+
+// ResumeTargetHandler : visits a paused target by name again
+func (s *Server) ResumeTargetHandler(c *gin.Context) {
+	s.setTargetPaused(c, false)
+}
+
+// DeleteTarget : deletes a target by name, which is no longer visited. The deletion is
+// recorded, so a configured target of the same name is not seeded again.
+func (s *Server) DeleteTarget(c *gin.Context) {
+	ctx := c.Request.Context()
+	t := models.Target{Name: c.Param("name")}
+	if err := t.ReadTarget(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := models.MarkTargetDeleted(ctx, s.DB, t.Name); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	if err := t.DeleteTargetFromDB(ctx, s.DB); err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	s.RemoveTarget(t.Name)
+	s.auditTarget(c, models.TargetDeleted, t)
+	c.Status(http.StatusNoContent)
+}
+
+// GetTargetChanges : lists the changes made to the targets, the newest first, filtered by the
+// "name" and "actor" query parameters
+func (s *Server) GetTargetChanges(c *gin.Context) {
+	ctx := c.Request.Context()
+	filter := bson.M{}
+	for _, param := range []string{"name", "actor"} {
+		if v := c.Query(param); v != "" {
+			filter[param] = v
+		}
+	}
+	if err := addTimeRange(c, filter, "time", true); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	changes, err := models.ListTargetChanges(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
@@ -1,11 +1,11 @@
 package controllers
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/gocolly/colly"
 	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
 )
 
 // TelemetryDataCollectorConfig : configures the telemetry data scrapper
@@ -22,48 +22,32 @@ func (s *Server) TelemetryDataCollectorConfig() error {
 		if e.Attr("id") != "root" {
 			return
 		}
-		// Processes the HTML
+		// Processes the HTML, where unexpected pages are not stored
+		e.Request.Ctx.Put("parsed", "true")
+		ctx := requestContext(e.Request)
 		t := models.TelemetryData{}
-		t.FromScrapper(e)
-		// Adds to DB if needed
-		if !t.AlreadyAcquired(s.DB) {
-			if _, err := t.AddDataToDB(s.DB); err != nil {
-				fmt.Printf("Error while adding telemetryData: %v\n", err)
-			}
+		if err := tracing.Track(ctx, "parse", func() error { return t.FromScrapper(e) }); err != nil {
+			s.scrapeFailed(models.TelemetryDataKind, e.Request, err)
+			return
+		}
+		s.scrapeSucceeded(models.TelemetryDataKind, e.Request)
+		// Discards absurd values
+		vctx, span := tracing.Start(ctx, "validate", tracing.SerialKey.String(t.Serial), tracing.ModuleKey.String(t.Module))
+		ok := s.ValidateTelemetryData(vctx, &t, e.Response.Body)
+		span.End()
+		if !ok {
+			return
 		}
+		// Buffers for adding to DB, where the repeated data is discarded
+		s.TelemetryWriter.Add(ctx, &t)
 	})
 
-	// Before making a request print "Visiting ..."
-	s.TelemetryCollector.OnRequest(func(r *colly.Request) {
-		fmt.Println("Visiting", r.URL.String())
+	// Records the outcome of the visits
+	s.trackScrapes(models.TelemetryDataKind, s.TelemetryCollector)
+
+	// Archives the fetched page, if configured
+	s.TelemetryCollector.OnResponse(func(r *colly.Response) {
+		s.archivePage(models.TelemetryDataKind, r)
 	})
 	return nil
 }
-
-// TelemetryDataAcquisition : uses the scrapper for acquire telemetry data
-func (s *Server) TelemetryDataAcquisition(baseURL string, tPeriod int64, quit chan bool) {
-	// Prepares the timers
-	tTimers := map[string]int64{}
-	for _, t := range s.TelemetryPaths {
-		tTimers[t] = time.Now().Unix()
-	}
-	// Runs forever
-	for {
-		select {
-		case <-quit:
-			return
-		default:
-			// For each telemetry
-			for _, t := range s.TelemetryPaths {
-				// Checks timeout
-				cTime := time.Now().Unix()
-				if cTime-tTimers[t] >= tPeriod {
-					tTimers[t] = cTime
-					tURL := baseURL + t + "/"
-					go s.TelemetryCollector.Visit(tURL)
-				}
-			}
-			time.Sleep(1 * time.Second)
-		}
-	}
-}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Default batching parameters of the telemetry writer
+const (
+	defaultTelemetryBatchSize   = 100
+	defaultTelemetryFlushPeriod = 5 * time.Second
+)
+
+// TelemetryWriter : buffers the acquired telemetry data and writes it to the DB in batches
+type TelemetryWriter struct {
+	DB          *mongo.Database
+	BatchSize   int
+	FlushPeriod time.Duration
+	data        chan bufferedRead
+	done        chan bool
+	mutex       sync.RWMutex
+	stopped     bool
+}
+
+// bufferedRead : a telemetry read waiting to be written, with the context of the span of its
+// scrape
+type bufferedRead struct {
+	ctx  context.Context
+	data *models.TelemetryData
+}
+
+// SetTelemetryBatch : configures the batch size and the flush period, in seconds, of the
+// telemetry writer. Empty or invalid values use the defaults.
+func (s *Server) SetTelemetryBatch(size, period string) {
+	s.TelemetryBatchSize = defaultTelemetryBatchSize
+	if n, err := strconv.Atoi(size); err == nil && n > 0 {
+		s.TelemetryBatchSize = n
+	}
+	s.TelemetryFlushPeriod = defaultTelemetryFlushPeriod
+	if p, err := strconv.ParseInt(period, 10, 64); err == nil && p > 0 {
+		s.TelemetryFlushPeriod = time.Duration(p) * time.Second
+	}
+}
+
+// Start : launches the routine that writes the buffered data
+func (w *TelemetryWriter) Start() {
+	if w.BatchSize <= 0 {
+		w.BatchSize = defaultTelemetryBatchSize
+	}
+	if w.FlushPeriod <= 0 {
+		w.FlushPeriod = defaultTelemetryFlushPeriod
+	}
+	w.data = make(chan bufferedRead, w.BatchSize)
+	w.done = make(chan bool)
+	go w.run()
+}
+
+// Add : buffers a telemetry read to be written, whose write is linked to the span in a
+// context. Reads added after Stop are discarded.
+func (w *TelemetryWriter) Add(ctx context.Context, t *models.TelemetryData) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.stopped {
+		logging.Warn("Discarding telemetry data, the writer is stopped", logging.Fields{logging.SerialField: t.Serial})
+		return
+	}
+	w.data <- bufferedRead{ctx: ctx, data: t}
+}
+
+// Stop : writes the remaining buffered data and ends the writer routine
+func (w *TelemetryWriter) Stop() {
+	w.mutex.Lock()
+	if w.stopped {
+		w.mutex.Unlock()
+		return
+	}
+	w.stopped = true
+	close(w.data)
+	w.mutex.Unlock()
+	<-w.done
+}
+
+func (w *TelemetryWriter) run() {
+	ticker := time.NewTicker(w.FlushPeriod)
+	defer ticker.Stop()
+	batch := []bufferedRead{}
+	for {
+		select {
+		case t, ok := <-w.data:
+			if !ok {
+				w.flush(batch)
+				close(w.done)
+				return
+			}
+			batch = append(batch, t)
+			if len(batch) >= w.BatchSize {
+				w.flush(batch)
+				batch = []bufferedRead{}
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = []bufferedRead{}
+		}
+	}
+}
+
+// flush : writes a batch in a span linked to the scrapes of its reads
+func (w *TelemetryWriter) flush(batch []bufferedRead) {
+	if len(batch) == 0 {
+		return
+	}
+	data := []*models.TelemetryData{}
+	scrapes := []context.Context{}
+	for _, r := range batch {
+		data = append(data, r.data)
+		scrapes = append(scrapes, r.ctx)
+	}
+	ctx, span := tracing.StartLinked(context.Background(), "flush telemetry", scrapes, attribute.Int("reads", len(data)))
+	defer span.End()
+	if _, err := models.AddDataBatchToDB(ctx, w.DB, data); err != nil {
+		tracing.Fail(span, err)
+		logging.Error("Error while adding telemetry data", logging.Fields{"reads": len(data), logging.ErrorField: err})
+	}
+	if err := models.UpdateModuleRegistry(ctx, w.DB, data); err != nil {
+		tracing.Fail(span, err)
+		logging.Error("Error while updating modules", logging.Fields{logging.ErrorField: err})
+	}
+}
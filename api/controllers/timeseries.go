@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// timeSeriesMinVersion : the first MongoDB major version with time-series collections
+const timeSeriesMinVersion = 5
+
+// SetTimeSeries : configures if the telemetry data should be stored in a time-series collection
+func (s *Server) SetTimeSeries(enabled string) {
+	s.TelemetryTimeSeries, _ = strconv.ParseBool(enabled)
+}
+
+// supportsTimeSeries : checks if the connected MongoDB server has time-series collections
+func (s *Server) supportsTimeSeries(ctx context.Context) (bool, error) {
+	var info struct {
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	if err := s.DB.RunCommand(ctx, bson.M{"buildInfo": 1}).Decode(&info); err != nil {
+		return false, err
+	}
+	if len(info.VersionArray) == 0 {
+		return false, nil
+	}
+	return info.VersionArray[0] >= timeSeriesMinVersion, nil
+}
+
+// useTimeSeries : checks if the telemetry data collection should be created as time-series
+func (s *Server) useTimeSeries(ctx context.Context) (bool, error) {
+	if !s.TelemetryTimeSeries {
+		return false, nil
+	}
+	ok, err := s.supportsTimeSeries(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		logging.Warn("Time-series collections need MongoDB 5.0 or newer, using a regular collection")
+	}
+	return ok, nil
+}
+
+// IsTelemetryDataTimeSeries : checks if the existing telemetry data collection is time-series
+func (s *Server) IsTelemetryDataTimeSeries(ctx context.Context) (bool, error) {
+	info, err := s.readCollectionInfo(ctx, "telemetryData")
+	if err != nil {
+		return false, err
+	}
+	return info != nil && info.Type == "timeseries", nil
+}
+
+// setupTelemetryDataTimeSeries : creates the telemetry data as a time-series collection
+func (s *Server) setupTelemetryDataTimeSeries(ctx context.Context) error {
+	cmd := bson.D{
+		{Key: "create", Value: "telemetryData"},
+		{Key: "timeseries", Value: bson.D{
+			{Key: "timeField", Value: "telemetryTime"},
+			{Key: "metaField", Value: "meta"},
+			{Key: "granularity", Value: "minutes"},
+		}},
+	}
+	if s.TelemetryRetention > 0 {
+		seconds, err := expireAfterSeconds("telemetryData", s.TelemetryRetention)
+		if err != nil {
+			return err
+		}
+		cmd = append(cmd, bson.E{Key: "expireAfterSeconds", Value: seconds})
+	}
+	if err := s.DB.RunCommand(ctx, cmd).Err(); err != nil {
+		return err
+	}
+	// Unique indexes are not allowed, so the index only speeds up the repeated data checks
+	tMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meta.serial", Value: -1},
+			{Key: "telemetryTime", Value: -1},
+		},
+	}
+	_, err := s.DB.Collection("telemetryData").Indexes().CreateOne(ctx, tMod)
+	return err
+}
+
+// MigrateTelemetryDataCollection : moves the telemetry data to a new collection when the current
+// one is capped or when it should become a time-series collection
+func (s *Server) MigrateTelemetryDataCollection(ctx context.Context) error {
+	info, err := s.readCollectionInfo(ctx, "telemetryData")
+	if err != nil {
+		return err
+	}
+	ts, err := s.useTimeSeries(ctx)
+	if err != nil {
+		return err
+	}
+	current := info != nil && info.Type == "timeseries"
+	needed := info != nil && (info.Options.Capped || (ts && !current))
+	// The documents are written with the layout of the final collection
+	if needed {
+		current = ts
+	}
+	models.SetTelemetryDataTimeSeries(current)
+	transform := func(raw bson.Raw) (interface{}, error) {
+		var t models.TelemetryData
+		if err := bson.Unmarshal(raw, &t); err != nil {
+			return nil, err
+		}
+		t.FillDerivedFields()
+		return &t, nil
+	}
+	return s.migrateCollection(ctx, "telemetryData", needed, s.SetupTelemetryDataCollection, transform)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The colly context keys of the tracing of a visit
+const (
+	// traceContextKey : the context of the span of the visit, or of its acquisition cycle
+	// before the request
+	traceContextKey = "traceContext"
+	// fetchSpanKey : the span of the fetch of the page
+	fetchSpanKey = "fetchSpan"
+)
+
+// visitContext : the colly context of a visit to a target, whose spans are children of the
+// ones in a context
+func visitContext(ctx context.Context, t models.Target) *colly.Context {
+	c := colly.NewContext()
+	c.Put(traceContextKey, ctx)
+	c.Put("target", t.Name)
+	return c
+}
+
+// requestContext : the context of the span of the visit of a request
+func requestContext(r *colly.Request) context.Context {
+	if r != nil && r.Ctx != nil {
+		if ctx, ok := r.Ctx.GetAny(traceContextKey).(context.Context); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// failVisit : marks the span of the visit of a request as failed
+func failVisit(r *colly.Request, err error) {
+	tracing.Fail(trace.SpanFromContext(requestContext(r)), err)
+}
+
+// endVisit : ends the spans of the visit of a request, where the fetch failed on an error
+func endVisit(r *colly.Request, fetchErr error) {
+	if fetch, ok := r.Ctx.GetAny(fetchSpanKey).(trace.Span); ok {
+		tracing.Fail(fetch, fetchErr)
+		fetch.End()
+	}
+	trace.SpanFromContext(requestContext(r)).End()
+}
+
+// traceVisits : records a span for each visit of a collector, with a child span for fetching
+// the page. The callbacks of the collector add the spans of parsing and storing the page, and
+// mark the visit as failed, so it must be called after adding them.
+func (s *Server) traceVisits(kind string, c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		attrs := []attribute.KeyValue{tracing.URLKey.String(r.URL.String()), tracing.KindKey.String(kind)}
+		if target := r.Ctx.Get("target"); target != "" {
+			attrs = append(attrs, tracing.TargetKey.String(target))
+		}
+		ctx, _ := tracing.Start(requestContext(r), "scrape "+kind, attrs...)
+		r.Ctx.Put(traceContextKey, ctx)
+		_, fetch := tracing.Start(ctx, "fetch")
+		r.Ctx.Put(fetchSpanKey, fetch)
+	})
+	c.OnResponse(func(r *colly.Response) {
+		if fetch, ok := r.Ctx.GetAny(fetchSpanKey).(trace.Span); ok {
+			fetch.End()
+		}
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		endVisit(r.Request, err)
+	})
+	c.OnScraped(func(r *colly.Response) {
+		endVisit(r.Request, nil)
+	})
+}
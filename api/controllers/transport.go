@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocolly/colly"
+	"github.com/rjmalves/cpid-solar-telemetry/api/auth"
+	"github.com/rjmalves/cpid-solar-telemetry/api/transport"
+)
+
+// SetAppURL : configures the full base URL of the device, as "https://172.16.0.1:8443/", which
+// is used instead of APP_HOST and APP_PORT. An empty URL keeps them.
+func (s *Server) SetAppURL(appURL string) error {
+	s.AppURL = ""
+	if appURL == "" {
+		return nil
+	}
+	u, err := url.Parse(appURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid app URL: %v", appURL)
+	}
+	if !strings.HasSuffix(appURL, "/") {
+		appURL += "/"
+	}
+	s.AppURL = appURL
+	return nil
+}
+
+// SetTLS : configures the trusted authorities, pinned certificates and client certificates of
+// the HTTPS targets from a JSON file. An empty path verifies them with the system authorities.
+func (s *Server) SetTLS(path string) error {
+	c := &transport.TLSConfig{}
+	if path != "" {
+		var err error
+		if c, err = transport.ReadTLSFile(path); err != nil {
+			return err
+		}
+	}
+	r, err := transport.New(c)
+	if err != nil {
+		return err
+	}
+	s.TLS = r
+	return nil
+}
+
+// SetAuth : configures the credentials of the targets from a JSON file. An empty path visits
+// all the targets anonymously.
+func (s *Server) SetAuth(path string) error {
+	s.Auth = &auth.Config{}
+	if path == "" {
+		return nil
+	}
+	c, err := auth.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s.Auth = c
+	return nil
+}
+
+// ConfigureTransport : makes the collectors send their requests with the credentials and the TLS
+// settings of the targets
+func (s *Server) ConfigureTransport() {
+	if s.Auth == nil {
+		s.Auth = &auth.Config{}
+	}
+	var next http.RoundTripper = http.DefaultTransport
+	if s.TLS != nil {
+		next = s.TLS
+	}
+	s.Transport = auth.NewTransport(s.Auth, next)
+	for _, c := range []*colly.Collector{s.InverterCollector, s.TelemetryCollector, s.DiscoveryCollector} {
+		c.WithTransport(s.Transport)
+	}
+}
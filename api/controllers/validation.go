@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// dataValidator : the last accepted reads, which the rate of change of the new ones is checked against
+type dataValidator struct {
+	mutex             sync.Mutex
+	lastInverter      map[string]map[string]float64
+	lastInverterTime  map[string]int64
+	lastTelemetry     map[string]map[string]float64
+	lastTelemetryTime map[string]int64
+}
+
+// SetValidation : configures the limits of the scraped values from a JSON file, which are
+// added to the built-in physical bounds. An empty path uses only the built-in bounds.
+func (s *Server) SetValidation(path string) error {
+	s.Validation = models.DefaultValidationRules()
+	if path == "" {
+		return nil
+	}
+	r, err := models.ReadValidationFile(path)
+	if err != nil {
+		return err
+	}
+	s.Validation = r
+	return nil
+}
+
+// validate : checks a read against the limits of its fields and the last accepted read of the
+// same source, telling if it should be stored. Rejected reads are kept with their payload.
+func (s *Server) validate(ctx context.Context, kind, serial, module string, read interface{}, fields map[string]float64, at int64, payload []byte) ([]string, bool) {
+	s.validator.mutex.Lock()
+	defer s.validator.mutex.Unlock()
+	if s.validator.lastInverter == nil {
+		s.validator.lastInverter = map[string]map[string]float64{}
+		s.validator.lastInverterTime = map[string]int64{}
+		s.validator.lastTelemetry = map[string]map[string]float64{}
+		s.validator.lastTelemetryTime = map[string]int64{}
+	}
+	last, lastTime := s.validator.lastInverter, s.validator.lastInverterTime
+	key := serial
+	if kind == models.TelemetryDataKind {
+		last, lastTime = s.validator.lastTelemetry, s.validator.lastTelemetryTime
+		key = serial + "/" + module
+	}
+	violations := s.Validation.Validate(serial, fields, last[key], at-lastTime[key])
+	if models.Rejected(violations) {
+		logger := logging.With(logging.Fields{
+			logging.KindField:   kind,
+			logging.SerialField: serial,
+			logging.ModuleField: module,
+		})
+		logger.Warn("Rejecting read", logging.Fields{"violations": fmt.Sprint(violations)})
+		r := models.NewRejectedData(kind, serial, module, read, violations, payload)
+		if _, err := r.AddRejectedDataToDB(ctx, s.DB); err != nil {
+			logger.Error("Error while keeping rejected data", logging.Fields{logging.ErrorField: err})
+		}
+		return nil, false
+	}
+	if at > lastTime[key] {
+		last[key] = fields
+		lastTime[key] = at
+	}
+	return models.Flags(violations), true
+}
+
+// ValidateInverter : checks an inverter read at a time of the device clock, telling if it should be stored
+func (s *Server) ValidateInverter(ctx context.Context, i *models.Inverter, at time.Time, payload []byte) bool {
+	flags, ok := s.validate(ctx, models.InverterKind, i.Serial, "", i, i.ValidatedFields(), at.Unix(), payload)
+	i.Flags = flags
+	return ok
+}
+
+// ValidateTelemetryData : checks a telemetry read, telling if it should be stored
+func (s *Server) ValidateTelemetryData(ctx context.Context, t *models.TelemetryData, payload []byte) bool {
+	flags, ok := s.validate(ctx, models.TelemetryDataKind, t.Serial, t.Module, t, t.ValidatedFields(), t.LastTelemetryTime, payload)
+	if len(flags) > 0 {
+		t.Flags = flags
+	}
+	return ok
+}
+
+// GetRejectedData : lists the rejected reads, optionally of a kind or an inverter
+func (s *Server) GetRejectedData(c *gin.Context) {
+	ctx := c.Request.Context()
+	filter := bson.M{}
+	for _, param := range []string{"kind", "serial", "module"} {
+		if v := c.Query(param); v != "" {
+			filter[param] = v
+		}
+	}
+	if err := addTimeRange(c, filter, "receivedAt", true); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	rejected, err := models.ListRejectedData(ctx, s.DB, filter)
+	if err != nil {
+		respondError(c, errorCode(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, rejected)
+}
@@ -0,0 +1,266 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level : how important a message is, where only the messages at or above the configured level
+// are written
+type Level int
+
+// The levels of the messages
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel : reads a level by name, where an empty name is the info level
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return InfoLevel, nil
+	}
+	for l, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level: %v", name)
+}
+
+// The formats of the written messages
+const (
+	// LogfmtFormat : a line of key=value pairs
+	LogfmtFormat = "logfmt"
+	// JSONFormat : a JSON object per line
+	JSONFormat = "json"
+)
+
+// The fields shared by the messages of the service
+const (
+	URLField      = "url"
+	SerialField   = "serial"
+	ModuleField   = "module"
+	KindField     = "kind"
+	TargetField   = "target"
+	DurationField = "duration"
+	ErrorField    = "error"
+)
+
+// Fields : the context of a message, by name. Errors are written as their messages and
+// durations in seconds.
+type Fields map[string]interface{}
+
+// Logger : writes leveled messages with fields in a format
+type Logger struct {
+	mutex  *sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	fields Fields
+}
+
+// New : creates a logger that writes the messages at or above a level, in a format
+func New(out io.Writer, level Level, format string) (*Logger, error) {
+	switch format {
+	case "":
+		format = LogfmtFormat
+	case LogfmtFormat, JSONFormat:
+	default:
+		return nil, fmt.Errorf("unknown log format: %v", format)
+	}
+	return &Logger{
+		mutex:  &sync.Mutex{},
+		out:    out,
+		level:  level,
+		format: format,
+		fields: Fields{},
+	}, nil
+}
+
+// With : a logger that adds some fields to every message
+func (l *Logger) With(fields Fields) *Logger {
+	child := *l
+	child.fields = Fields{}
+	for k, v := range l.fields {
+		child.fields[k] = v
+	}
+	for k, v := range fields {
+		child.fields[k] = v
+	}
+	return &child
+}
+
+// Enabled : checks if the messages of a level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug : writes a message about the details of the work done
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.write(DebugLevel, msg, fields)
+}
+
+// Info : writes a message about the work done
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.write(InfoLevel, msg, fields)
+}
+
+// Warn : writes a message about something unexpected, which the service works around
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.write(WarnLevel, msg, fields)
+}
+
+// Error : writes a message about work that could not be done
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.write(ErrorLevel, msg, fields)
+}
+
+// Fatal : writes an error message and exits
+func (l *Logger) Fatal(msg string, fields ...Fields) {
+	l.write(ErrorLevel, msg, fields)
+	os.Exit(1)
+}
+
+// value : a field value as written, where errors are their messages and durations are seconds
+func value(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		if x == nil {
+			return nil
+		}
+		return x.Error()
+	case time.Duration:
+		return x.Seconds()
+	case fmt.Stringer:
+		return x.String()
+	}
+	return v
+}
+
+func (l *Logger) write(level Level, msg string, fields []Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := Fields{}
+	for k, v := range l.fields {
+		all[k] = value(v)
+	}
+	for _, f := range fields {
+		for k, v := range f {
+			all[k] = value(v)
+		}
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var line []byte
+	if l.format == JSONFormat {
+		all["time"] = now
+		all["level"] = level.String()
+		all["msg"] = msg
+		var err error
+		if line, err = json.Marshal(all); err != nil {
+			line, _ = json.Marshal(map[string]string{"time": now, "level": level.String(), "msg": msg,
+				ErrorField: err.Error()})
+		}
+	} else {
+		line = logfmt(now, level, msg, all)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// logfmt : a message as key=value pairs, with the fields sorted by name
+func logfmt(now string, level Level, msg string, fields Fields) []byte {
+	var b bytes.Buffer
+	b.WriteString("time=" + now + " level=" + level.String() + " msg=" + logfmtValue(msg))
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + logfmtValue(fmt.Sprint(fields[k])))
+	}
+	return b.Bytes()
+}
+
+// logfmtValue : a value quoted when it is empty or has spaces, quotes or equal signs
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+var std, _ = New(os.Stdout, InfoLevel, LogfmtFormat)
+
+// Configure : sets the level and the format of the service messages, as "debug" and "json"
+func Configure(level, format string) error {
+	lv, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l, err := New(os.Stdout, lv, format)
+	if err != nil {
+		return err
+	}
+	std = l
+	return nil
+}
+
+// Default : the logger of the service messages
+func Default() *Logger {
+	return std
+}
+
+// With : a logger of the service messages that adds some fields to every message
+func With(fields Fields) *Logger {
+	return std.With(fields)
+}
+
+// Debug : writes a service message about the details of the work done
+func Debug(msg string, fields ...Fields) {
+	std.Debug(msg, fields...)
+}
+
+// Info : writes a service message about the work done
+func Info(msg string, fields ...Fields) {
+	std.Info(msg, fields...)
+}
+
+// Warn : writes a service message about something unexpected, which the service works around
+func Warn(msg string, fields ...Fields) {
+	std.Warn(msg, fields...)
+}
+
+// Error : writes a service message about work that could not be done
+func Error(msg string, fields ...Fields) {
+	std.Error(msg, fields...)
+}
+
+// Fatal : writes a service error message and exits
+func Fatal(msg string, fields ...Fields) {
+	std.Fatal(msg, fields...)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingLevels(t *testing.T) {
+	var out bytes.Buffer
+	l, err := logging.New(&out, logging.WarnLevel, logging.LogfmtFormat)
+	if err != nil {
+		t.Fatalf("Error creating the logger: %v", err)
+	}
+	l.Debug("Visiting page")
+	l.Info("Scraped page")
+	l.Warn("Rejecting read")
+	l.Error("Error while adding telemetry data")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], `level=warn msg="Rejecting read"`)
+	assert.Contains(t, lines[1], "level=error")
+	// Unknown levels and formats are not accepted
+	_, err = logging.ParseLevel("verbose")
+	assert.Error(t, err)
+	_, err = logging.New(&out, logging.InfoLevel, "xml")
+	assert.Error(t, err)
+	lv, err := logging.ParseLevel("DEBUG")
+	assert.NoError(t, err)
+	assert.Equal(t, logging.DebugLevel, lv)
+}
+
+func TestLoggingFields(t *testing.T) {
+	var out bytes.Buffer
+	l, err := logging.New(&out, logging.DebugLevel, logging.JSONFormat)
+	if err != nil {
+		t.Fatalf("Error creating the logger: %v", err)
+	}
+	logger := l.With(logging.Fields{logging.URLField: "http://172.16.0.1/inverter/", logging.KindField: "inverter"})
+	logger.Warn("Error while scraping page", logging.Fields{
+		logging.DurationField: 1500 * time.Millisecond,
+		logging.ErrorField:    errors.New("connection refused"),
+	})
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &msg); err != nil {
+		t.Fatalf("Error decoding the message: %v", err)
+	}
+	assert.Equal(t, "warn", msg["level"])
+	assert.Equal(t, "Error while scraping page", msg["msg"])
+	assert.Equal(t, "http://172.16.0.1/inverter/", msg[logging.URLField])
+	assert.Equal(t, "inverter", msg[logging.KindField])
+	assert.Equal(t, 1.5, msg[logging.DurationField])
+	assert.Equal(t, "connection refused", msg[logging.ErrorField])
+	// The fields of the parent logger are not changed
+	out.Reset()
+	l.Info("Replay finished")
+	assert.NotContains(t, out.String(), logging.URLField)
+	// Values with spaces are quoted in logfmt
+	out.Reset()
+	l, _ = logging.New(&out, logging.InfoLevel, "")
+	l.Info("Served request", logging.Fields{"path": "/inverters/", "status": 200})
+	assert.Contains(t, out.String(), `msg="Served request" path=/inverters/ status=200`)
+}
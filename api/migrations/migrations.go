@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var migrationsCollection = "schemaMigrations"
+
+// Migration : a versioned change of the DB schema or data
+type Migration struct {
+	Version     int64
+	Description string
+	Steps       []Step
+}
+
+// Record : a migration that was applied to the DB
+type Record struct {
+	Version     int64     `bson:"version" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+	Duration    int64     `bson:"duration" json:"duration"`
+}
+
+// Applied : reads the versions of the migrations already applied to the DB
+func Applied(ctx context.Context, db *mongo.Database) (map[int64]bool, error) {
+	cur, err := db.Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	applied := map[int64]bool{}
+	for cur.Next(ctx) {
+		var r Record
+		if err := cur.Decode(&r); err != nil {
+			return nil, err
+		}
+		applied[r.Version] = true
+	}
+	return applied, cur.Err()
+}
+
+// Pending : lists the migrations not yet applied to the DB, in order
+func Pending(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	applied, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, m := range All {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Run : applies the pending migrations in order. In a dry run, only prints what each
+// step would change.
+func Run(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	if err := setup(ctx, db); err != nil {
+		return err
+	}
+	pending, err := Pending(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		logging.Info("Migration", logging.Fields{"version": m.Version, "description": m.Description})
+		if dryRun {
+			for _, st := range m.Steps {
+				desc, err := st.Describe(ctx, db)
+				if err != nil {
+					return err
+				}
+				logging.Info("Migration step", logging.Fields{"version": m.Version, "would": desc})
+			}
+			continue
+		}
+		start := time.Now()
+		for _, st := range m.Steps {
+			if err := st.Apply(ctx, db); err != nil {
+				return fmt.Errorf("migration %v: %w", m.Version, err)
+			}
+		}
+		r := Record{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+			Duration:    time.Since(start).Milliseconds(),
+		}
+		if _, err := db.Collection(migrationsCollection).InsertOne(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setup : creates the unique index that keeps each migration applied only once
+func setup(ctx context.Context, db *mongo.Database) error {
+	mod := mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := db.Collection(migrationsCollection).Indexes().CreateOne(ctx, mod)
+	return err
+}
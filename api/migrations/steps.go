@@ -0,0 +1,184 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Step : a single change made by a migration
+type Step interface {
+	// Describe : tells what Apply would change, without changing anything
+	Describe(ctx context.Context, db *mongo.Database) (string, error)
+	// Apply : makes the change in the DB
+	Apply(ctx context.Context, db *mongo.Database) error
+}
+
+// CreateIndex : creates an index in a collection. Unique indexes are skipped in
+// time-series collections, which do not support them.
+type CreateIndex struct {
+	Collection string
+	Keys       bson.D
+	Unique     bool
+}
+
+// Describe : tells which index would be created
+func (c CreateIndex) Describe(ctx context.Context, db *mongo.Database) (string, error) {
+	skip, err := c.skip(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if skip {
+		return fmt.Sprintf("skip unique index %v on time-series %v", c.Keys, c.Collection), nil
+	}
+	return fmt.Sprintf("create index %v (unique: %v) on %v", c.Keys, c.Unique, c.Collection), nil
+}
+
+// Apply : creates the index, which does nothing if it already exists
+func (c CreateIndex) Apply(ctx context.Context, db *mongo.Database) error {
+	skip, err := c.skip(ctx, db)
+	if err != nil || skip {
+		return err
+	}
+	mod := mongo.IndexModel{
+		Keys:    c.Keys,
+		Options: options.Index().SetUnique(c.Unique),
+	}
+	_, err = db.Collection(c.Collection).Indexes().CreateOne(ctx, mod)
+	return err
+}
+
+func (c CreateIndex) skip(ctx context.Context, db *mongo.Database) (bool, error) {
+	if !c.Unique {
+		return false, nil
+	}
+	return isTimeSeries(ctx, db, c.Collection)
+}
+
+// DropIndex : drops an index of a collection by name, which does nothing if it doesn't exist
+type DropIndex struct {
+	Collection string
+	Name       string
+}
+
+// Describe : tells if the index would be dropped
+func (d DropIndex) Describe(ctx context.Context, db *mongo.Database) (string, error) {
+	found, err := d.exists(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return fmt.Sprintf("skip missing index %v on %v", d.Name, d.Collection), nil
+	}
+	return fmt.Sprintf("drop index %v on %v", d.Name, d.Collection), nil
+}
+
+// Apply : drops the index, if it exists
+func (d DropIndex) Apply(ctx context.Context, db *mongo.Database) error {
+	found, err := d.exists(ctx, db)
+	if err != nil || !found {
+		return err
+	}
+	_, err = db.Collection(d.Collection).Indexes().DropOne(ctx, d.Name)
+	return err
+}
+
+func (d DropIndex) exists(ctx context.Context, db *mongo.Database) (bool, error) {
+	colls, err := db.ListCollectionNames(ctx, bson.M{"name": d.Collection})
+	if err != nil || len(colls) == 0 {
+		return false, err
+	}
+	cur, err := db.Collection(d.Collection).Indexes().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var spec struct {
+			Name string `bson:"name"`
+		}
+		if err := cur.Decode(&spec); err != nil {
+			return false, err
+		}
+		if spec.Name == d.Name {
+			return true, nil
+		}
+	}
+	return false, cur.Err()
+}
+
+// RenameField : renames a field in all the documents of a collection
+type RenameField struct {
+	Collection string
+	From       string
+	To         string
+}
+
+// Describe : tells how many documents would have the field renamed
+func (r RenameField) Describe(ctx context.Context, db *mongo.Database) (string, error) {
+	n, err := db.Collection(r.Collection).CountDocuments(ctx, r.filter())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rename %v to %v in %v documents of %v", r.From, r.To, n, r.Collection), nil
+}
+
+// Apply : renames the field
+func (r RenameField) Apply(ctx context.Context, db *mongo.Database) error {
+	update := bson.M{"$rename": bson.M{r.From: r.To}}
+	_, err := db.Collection(r.Collection).UpdateMany(ctx, r.filter(), update)
+	return err
+}
+
+func (r RenameField) filter() bson.M {
+	return bson.M{r.From: bson.M{"$exists": true}}
+}
+
+// Backfill : updates the documents of a collection that match a filter
+type Backfill struct {
+	Collection string
+	Filter     bson.M
+	Update     interface{}
+}
+
+// Describe : tells how many documents would be updated
+func (b Backfill) Describe(ctx context.Context, db *mongo.Database) (string, error) {
+	n, err := db.Collection(b.Collection).CountDocuments(ctx, b.Filter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("update %v documents of %v", n, b.Collection), nil
+}
+
+// Apply : updates the documents, if there is any
+func (b Backfill) Apply(ctx context.Context, db *mongo.Database) error {
+	// Avoids updating collections that do not support it when there is nothing to do
+	n, err := db.Collection(b.Collection).CountDocuments(ctx, b.Filter)
+	if err != nil || n == 0 {
+		return err
+	}
+	_, err = db.Collection(b.Collection).UpdateMany(ctx, b.Filter, b.Update)
+	return err
+}
+
+// isTimeSeries : checks if a collection is a time-series collection
+func isTimeSeries(ctx context.Context, db *mongo.Database, coll string) (bool, error) {
+	cur, err := db.ListCollections(ctx, bson.M{"name": coll})
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var info struct {
+			Type string `bson:"type"`
+		}
+		if err := cur.Decode(&info); err != nil {
+			return false, err
+		}
+		return info.Type == "timeseries", nil
+	}
+	return false, cur.Err()
+}
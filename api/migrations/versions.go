@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// All : every migration of the DB, ordered by version. Applied migrations must never
+// be changed, new ones are appended with the next version.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "Unique serial index on inverters",
+		Steps: []Step{
+			CreateIndex{
+				Collection: "inverters",
+				Keys:       bson.D{{Key: "serial", Value: -1}},
+				Unique:     true,
+			},
+		},
+	},
+	{
+		Version:     2,
+		Description: "Unique serial and time index on telemetry data",
+		Steps: []Step{
+			CreateIndex{
+				Collection: "telemetryData",
+				Keys: bson.D{
+					{Key: "serial", Value: -1},
+					{Key: "lastTelemetryTime", Value: -1},
+				},
+				Unique: true,
+			},
+		},
+	},
+	{
+		Version:     3,
+		Description: "Date field of the telemetry data, used by the retention",
+		Steps: []Step{
+			Backfill{
+				Collection: "telemetryData",
+				Filter: bson.M{
+					"telemetryTime": bson.M{"$exists": false},
+				},
+				Update: bson.A{
+					bson.M{"$set": bson.M{
+						"telemetryTime": bson.M{"$toDate": bson.M{"$multiply": bson.A{"$lastTelemetryTime", 1000}}},
+					}},
+				},
+			},
+		},
+	},
+	{
+		Version:     4,
+		Description: "Unique serial, module and day index on daily telemetry data",
+		Steps: []Step{
+			CreateIndex{
+				Collection: "telemetryDailyData",
+				Keys: bson.D{
+					{Key: "serial", Value: -1},
+					{Key: "module", Value: -1},
+					{Key: "day", Value: -1},
+				},
+				Unique: true,
+			},
+		},
+	},
+	{
+		Version:     5,
+		Description: "Unique site and name index on strings, replacing the name one",
+		Steps: []Step{
+			DropIndex{
+				Collection: "strings",
+				Name:       "name_1",
+			},
+			CreateIndex{
+				Collection: "strings",
+				Keys: bson.D{
+					{Key: "site", Value: 1},
+					{Key: "name", Value: 1},
+				},
+				Unique: true,
+			},
+		},
+	},
+}
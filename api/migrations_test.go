@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/migrations"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/seed"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAppliedAtStartup(t *testing.T) {
+	ctx := context.Background()
+	// The service initialization should have applied every migration
+	pending, err := migrations.Pending(ctx, s.DB)
+	if err != nil {
+		t.Errorf("Error while listing pending migrations: %v\n", err)
+		return
+	}
+	assert.Equal(t, 0, len(pending))
+	// Running again should not apply anything
+	if err := migrations.Run(ctx, s.DB, false); err != nil {
+		t.Errorf("Error while running migrations again: %v\n", err)
+		return
+	}
+	applied, _ := migrations.Applied(ctx, s.DB)
+	assert.Equal(t, len(migrations.All), len(applied))
+}
+
+func TestRenameFieldStep(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Seeds the collection for testing data
+	if err := seed.LoadInverters(s.DB); err != nil {
+		log.Fatalf("Error seeding the DB: %v", err)
+	}
+	// Renames a field forth and back, checking the data after
+	forth := migrations.RenameField{Collection: "inverters", From: "voltage", To: "acVoltage"}
+	back := migrations.RenameField{Collection: "inverters", From: "acVoltage", To: "voltage"}
+	desc, err := forth.Describe(ctx, s.DB)
+	if err != nil {
+		t.Errorf("Error while describing the step: %v\n", err)
+		return
+	}
+	assert.Equal(t, "rename voltage to acVoltage in 3 documents of inverters", desc)
+	if err := forth.Apply(ctx, s.DB); err != nil {
+		t.Errorf("Error while renaming the field: %v\n", err)
+		return
+	}
+	i := models.Inverter{
+		Serial: "INVERTER1",
+	}
+	i.ReadInverter(ctx, s.DB)
+	assert.Equal(t, 0.0, i.Voltage)
+	if err := back.Apply(ctx, s.DB); err != nil {
+		t.Errorf("Error while renaming the field: %v\n", err)
+		return
+	}
+	i.ReadInverter(ctx, s.DB)
+	assert.Equal(t, 127.0, i.Voltage)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDetectModuleAnomalies(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshAnomalyCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Four modules report in six slots, and one of them has half the current of its peers
+	start := int64(1600000200)
+	currents := map[string]float64{
+		"MODULE-1": 8.0,
+		"MODULE-2": 8.2,
+		"MODULE-3": 7.9,
+		"MODULE-4": 4.0,
+	}
+	data := []*models.TelemetryData{}
+	for slot := int64(0); slot < 6; slot++ {
+		offset := int64(0)
+		for module, current := range currents {
+			offset++
+			data = append(data, &models.TelemetryData{
+				Serial:            "INVERTER1",
+				Module:            module,
+				LastTelemetryTime: start + 300*slot + offset,
+				InputVoltage:      40.0,
+				InputCurrent:      current,
+			})
+		}
+	}
+	if _, err := models.AddDataBatchToDB(ctx, s.DB, data); err != nil {
+		t.Errorf("Error while adding data: %v\n", err)
+		return
+	}
+	// Detects twice, which should not repeat the anomalies
+	cfg := models.AnomalyConfig{SlotPeriod: 300, Threshold: 0.2, Persistence: 0.6, MinSlots: 3}
+	var anomalies []*models.Anomaly
+	for i := 0; i < 2; i++ {
+		var err error
+		anomalies, err = models.DetectModuleAnomalies(ctx, s.DB, "INVERTER1", start, start+1800, cfg)
+		if err != nil {
+			t.Errorf("Error while detecting anomalies: %v\n", err)
+			return
+		}
+	}
+	assert.Equal(t, 1, len(anomalies))
+	assert.Equal(t, "MODULE-4", anomalies[0].Module)
+	assert.Equal(t, "inputCurrent", anomalies[0].Field)
+	assert.Equal(t, int64(6), anomalies[0].Outliers)
+	assert.Equal(t, models.AnomalyMedium, anomalies[0].Severity)
+	// Reviews the anomaly, which is kept when detected again
+	stored, _ := models.ListAnomalies(ctx, s.DB, bson.M{})
+	assert.Equal(t, 1, len(stored))
+	if err := models.ReviewAnomaly(ctx, s.DB, stored[0].ID); err != nil {
+		t.Errorf("Error while reviewing the anomaly: %v\n", err)
+		return
+	}
+	if _, err := models.DetectModuleAnomalies(ctx, s.DB, "INVERTER1", start, start+1800, cfg); err != nil {
+		t.Errorf("Error while detecting anomalies: %v\n", err)
+		return
+	}
+	stored, _ = models.ListAnomalies(ctx, s.DB, bson.M{"reviewed": true})
+	assert.Equal(t, 1, len(stored))
+}
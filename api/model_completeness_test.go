@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUpdateTelemetryCompleteness(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshModuleCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshCompletenessCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// A module reports in the first hour, except from 6:20 to 6:35, while another one
+	// was registered the day before and does not report
+	day := time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)
+	start := day.Add(6 * time.Hour).Unix()
+	data := []*models.TelemetryData{
+		{Serial: "INVERTER1", Module: "MODULE-2", LastTelemetryTime: start - 86400},
+	}
+	for i := int64(0); i < 12; i++ {
+		if i >= 4 && i < 7 {
+			continue
+		}
+		data = append(data, &models.TelemetryData{
+			Serial:            "INVERTER1",
+			Module:            "MODULE-1",
+			LastTelemetryTime: start + 300*i + 10,
+		})
+	}
+	if _, err := models.AddDataBatchToDB(ctx, s.DB, data); err != nil {
+		t.Errorf("Error while adding data: %v\n", err)
+		return
+	}
+	if err := models.UpdateModuleRegistry(ctx, s.DB, data); err != nil {
+		t.Errorf("Error while updating the registry: %v\n", err)
+		return
+	}
+	// Finds the gaps twice, which should not repeat them
+	w := models.CompletenessWindow{Period: 300, FromHour: 6, ToHour: 7}
+	var report []*models.TelemetryCompleteness
+	for i := 0; i < 2; i++ {
+		var err error
+		report, err = models.UpdateTelemetryCompleteness(ctx, s.DB, day, w, day.AddDate(0, 0, 1).Unix())
+		if err != nil {
+			t.Errorf("Error while finding gaps: %v\n", err)
+			return
+		}
+	}
+	// Verifies the report of the inverter and its modules
+	assert.Equal(t, 3, len(report))
+	completeness := map[string]float64{}
+	for _, c := range report {
+		completeness[c.Module] = c.Completeness
+	}
+	// The inverter expects the samples of both modules
+	assert.InDelta(t, 37.5, completeness[""], 1e-9)
+	assert.InDelta(t, 75.0, completeness["MODULE-1"], 1e-9)
+	assert.InDelta(t, 0.0, completeness["MODULE-2"], 1e-9)
+	// Verifies the gaps in DB
+	gaps, err := models.ListTelemetryGaps(ctx, s.DB, bson.M{"module": "MODULE-1"})
+	if err != nil {
+		t.Errorf("Error while listing gaps: %v\n", err)
+		return
+	}
+	assert.Equal(t, 1, len(gaps))
+	assert.Equal(t, start+1200, gaps[0].Start)
+	assert.Equal(t, start+2100, gaps[0].End)
+	assert.Equal(t, int64(3), gaps[0].Missing)
+	stored, _ := models.ListTelemetryCompleteness(ctx, s.DB, bson.M{"day": day})
+	assert.Equal(t, 3, len(stored))
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdatePerformance(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshLayoutCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshPerformanceCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.LoadLayoutFile(ctx, "./tests/assets/layout.json"); err != nil {
+		log.Fatalf("Error loading the layout: %v", err)
+	}
+	if err := s.SetIrradiance("reference", "5.0", "", "", ""); err != nil {
+		log.Fatalf("Error configuring the irradiance: %v", err)
+	}
+	defer s.SetIrradiance("", "", "", "", "")
+	// Records the energy of an inverter, keeping the largest one in the day
+	day := time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC)
+	for _, e := range []float64{30.0, 50.0, 40.0} {
+		if err := models.RecordInverterEnergy(ctx, s.DB, "INVERTER1", day.Add(12*time.Hour), e); err != nil {
+			t.Errorf("Error while recording energy: %v\n", err)
+			return
+		}
+	}
+	// And of an inverter out of the sites
+	loose := models.String{Name: "LOOSE-A", Serial: "INVERTER9", PeakPower: 4.0}
+	if _, err := loose.UpsertStringInDB(ctx, s.DB); err != nil {
+		t.Errorf("Error while adding a string: %v\n", err)
+		return
+	}
+	if err := models.RecordInverterEnergy(ctx, s.DB, "INVERTER9", day.Add(12*time.Hour), 10.0); err != nil {
+		t.Errorf("Error while recording energy: %v\n", err)
+		return
+	}
+	if err := s.UpdatePerformance(ctx, day); err != nil {
+		t.Errorf("Error while updating performance: %v\n", err)
+		return
+	}
+	// Verifies the inverter indicators, with the peak power of its strings
+	ip := models.DailyPerformance{Scope: models.InverterScope, Name: "INVERTER1", Day: day}
+	if err := ip.ReadDailyPerformance(ctx, s.DB); err != nil {
+		t.Errorf("Error while reading performance: %v\n", err)
+		return
+	}
+	assert.Equal(t, 50.0, ip.Energy)
+	assert.Equal(t, 10.0, ip.PeakPower)
+	assert.InDelta(t, 5.0, ip.SpecificYield, 1e-9)
+	assert.InDelta(t, 1.0, ip.PerformanceRatio, 1e-9)
+	// The inverter out of the sites has its indicators too
+	lp := models.DailyPerformance{Scope: models.InverterScope, Name: "INVERTER9", Day: day}
+	if err := lp.ReadDailyPerformance(ctx, s.DB); err != nil {
+		t.Errorf("Error while reading performance: %v\n", err)
+		return
+	}
+	assert.Equal(t, 4.0, lp.PeakPower)
+	assert.InDelta(t, 2.5, lp.SpecificYield, 1e-9)
+	assert.InDelta(t, 0.5, lp.PerformanceRatio, 1e-9)
+	// Verifies the site indicators, with the peak power of the site
+	sp := models.DailyPerformance{Scope: models.SiteScope, Name: "CPID", Day: day}
+	if err := sp.ReadDailyPerformance(ctx, s.DB); err != nil {
+		t.Errorf("Error while reading performance: %v\n", err)
+		return
+	}
+	assert.Equal(t, 50.0, sp.Energy)
+	assert.Equal(t, 30.0, sp.PeakPower)
+	assert.InDelta(t, 5.0/3.0, sp.SpecificYield, 1e-9)
+	assert.InDelta(t, 1.0/3.0, sp.PerformanceRatio, 1e-9)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAppendEnergyLedger(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshEnergyCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshPerformanceCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Reads the counter with a delta, a rollback, a reset and a gap
+	start := time.Date(2020, 9, 13, 8, 0, 0, 0, time.UTC).Unix()
+	reads := []struct {
+		at      int64
+		counter float64
+		kind    string
+		energy  float64
+	}{
+		{start, 1000.0, models.EnergyBaseline, 0.0},
+		{start + 60, 1002.0, models.EnergyDelta, 2.0},
+		{start + 120, 1001.5, models.EnergyRollback, 0.0},
+		{start + 180, 1003.0, models.EnergyDelta, 1.0},
+		{start + 240, 4.0, models.EnergyReset, 4.0},
+		{start + 3840, 10.0, models.EnergyGap, 6.0},
+	}
+	for _, r := range reads {
+		e, err := models.AppendEnergyLedger(ctx, s.DB, "INVERTER1", r.at, r.counter, 900)
+		if err != nil {
+			t.Errorf("Error while appending to the ledger: %v\n", err)
+			return
+		}
+		assert.Equal(t, r.kind, e.Kind)
+		assert.InDelta(t, r.energy, e.Energy, 1e-9)
+	}
+	// A repeated read is ignored
+	if _, err := models.AppendEnergyLedger(ctx, s.DB, "INVERTER1", start+3840, 10.0, 900); err != nil {
+		t.Errorf("Error while appending to the ledger: %v\n", err)
+		return
+	}
+	entries, _ := models.ListEnergyLedger(ctx, s.DB, bson.M{"serial": "INVERTER1"})
+	assert.Equal(t, len(reads), len(entries))
+	// Reconciles the day against the daily counter of the device
+	day := time.Unix(start, 0).UTC()
+	if err := models.RecordInverterEnergy(ctx, s.DB, "INVERTER1", day, 12.0); err != nil {
+		t.Errorf("Error while recording energy: %v\n", err)
+		return
+	}
+	d, err := models.ReconcileEnergy(ctx, s.DB, "INVERTER1", day)
+	if err != nil {
+		t.Errorf("Error while reconciling energy: %v\n", err)
+		return
+	}
+	assert.InDelta(t, 13.0, d.Energy, 1e-9)
+	assert.InDelta(t, 1.0, d.Difference, 1e-9)
+	assert.Equal(t, int64(1), d.Gaps)
+	assert.Equal(t, int64(1), d.Resets)
+	assert.Equal(t, int64(1), d.Rollbacks)
+}
+
+func TestAppendEnergyLedgerConcurrently(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshEnergyCollections(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	start := time.Date(2020, 9, 13, 8, 0, 0, 0, time.UTC).Unix()
+	if _, err := models.AppendEnergyLedger(ctx, s.DB, "INVERTER1", start, 1000.0, 900); err != nil {
+		t.Errorf("Error while appending to the ledger: %v\n", err)
+		return
+	}
+	// Appends the reads of concurrent scrapes, which must not count the same energy twice
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			at := start + int64(60*i)
+			if _, err := models.AppendEnergyLedger(ctx, s.DB, "INVERTER1", at, 1000.0+float64(i), 900); err != nil {
+				t.Errorf("Error while appending to the ledger: %v\n", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	entries, _ := models.ListEnergyLedger(ctx, s.DB, bson.M{"serial": "INVERTER1"})
+	energy := 0.0
+	for _, e := range entries {
+		energy += e.Energy
+	}
+	assert.InDelta(t, 10.0, energy, 1e-9)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +12,9 @@ import (
 	"github.com/rjmalves/cpid-solar-telemetry/api/models"
 	"github.com/rjmalves/cpid-solar-telemetry/api/seed"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func TestListInverters(t *testing.T) {
@@ -24,7 +28,7 @@ func TestListInverters(t *testing.T) {
 		log.Fatalf("Error seeding the DB: %v", err)
 	}
 	// Verifies the inverters in DB
-	invs, err := models.ListInverters(s.DB)
+	invs, err := models.ListInverters(ctx, s.DB)
 	if err != nil {
 		t.Errorf("Error while listing inverters in DB: %v\n", err)
 		return
@@ -42,7 +46,12 @@ func TestDetectInverterNotInDB(t *testing.T) {
 	i := models.Inverter{
 		Serial: "INVERTER1",
 	}
-	if i.AlreadyInDB(s.DB) {
+	found, err := i.AlreadyInDB(ctx, s.DB)
+	if err != nil {
+		t.Errorf("Error while looking for the inverter: %v\n", err)
+		return
+	}
+	if found {
 		t.Errorf("Found an inverter that should not exist in DB\n")
 		return
 	}
@@ -62,8 +71,8 @@ func TestDetectInverterAlreadyInDB(t *testing.T) {
 	i := models.Inverter{
 		Serial: "INVERTER1",
 	}
-	if !i.AlreadyInDB(s.DB) {
-		t.Errorf("Failed to detect an inverter already in the DB\n")
+	if found, err := i.AlreadyInDB(ctx, s.DB); !found || err != nil {
+		t.Errorf("Failed to detect an inverter already in the DB: %v\n", err)
 		return
 	}
 }
@@ -82,8 +91,8 @@ func TestCreatingRepeatedInverter(t *testing.T) {
 	i := models.Inverter{
 		Serial: "INVERTER1",
 	}
-	if _, err := i.AddInverterToDB(s.DB); err == nil {
-		t.Errorf("Should have failed while adding an repeated inverter\n")
+	if _, err := i.AddInverterToDB(ctx, s.DB); !errors.Is(err, models.ErrDuplicate) {
+		t.Errorf("Should have failed while adding an repeated inverter: %v\n", err)
 		return
 	}
 }
@@ -102,12 +111,12 @@ func TestCreatingNewInverter(t *testing.T) {
 	i := models.Inverter{
 		Serial: "INVERTER4",
 	}
-	if _, err := i.AddInverterToDB(s.DB); err != nil {
+	if _, err := i.AddInverterToDB(ctx, s.DB); err != nil {
 		t.Errorf("Failed while adding a new inverter to DB: %v\n", err)
 		return
 	}
 	// List the existing inverters and checks the amount
-	invs, _ := models.ListInverters(s.DB)
+	invs, _ := models.ListInverters(ctx, s.DB)
 	assert.Equal(t, 4, len(invs))
 }
 
@@ -121,8 +130,8 @@ func TestUpdateNonExistingInverter(t *testing.T) {
 	i := models.Inverter{
 		Serial: "INVERTER1",
 	}
-	if err := i.UpdateInverterInDB(s.DB); err == nil {
-		t.Errorf("Should have failed while updating inverter that didn't exist in DB\n")
+	if err := i.UpdateInverterInDB(ctx, s.DB); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("Should have failed while updating inverter that didn't exist in DB: %v\n", err)
 		return
 	}
 }
@@ -141,10 +150,10 @@ func TestUpdateExistingInverter(t *testing.T) {
 	i := models.Inverter{
 		Serial: "INVERTER1",
 	}
-	i.ReadInverter(s.DB)
+	i.ReadInverter(ctx, s.DB)
 	i.Voltage = 380.0
 	// Tries to update the inverter
-	if err := i.UpdateInverterInDB(s.DB); err != nil {
+	if err := i.UpdateInverterInDB(ctx, s.DB); err != nil {
 		t.Errorf("Should have succeeded while updating inverter that existed in DB, but found: %v\n", err)
 		return
 	}
@@ -152,7 +161,7 @@ func TestUpdateExistingInverter(t *testing.T) {
 	newi := models.Inverter{
 		Serial: "INVERTER1",
 	}
-	newi.ReadInverter(s.DB)
+	newi.ReadInverter(ctx, s.DB)
 	assert.Equal(t, i, newi)
 }
 
@@ -171,7 +180,95 @@ func TestCreateInverterFromScrapper(t *testing.T) {
 	i := models.Inverter{
 		Serial: "7E1504FE-95",
 	}
-	if err := i.ReadInverter(s.DB); err != nil {
+	if err := i.ReadInverter(ctx, s.DB); err != nil {
 		t.Errorf("Couldn't create inverter from scrapper\n")
 	}
 }
+
+func TestUpsertInverter(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Upserts a new inverter, then the same data, then changed data
+	i := models.Inverter{
+		Serial:  "INVERTER1",
+		Voltage: 127.0,
+	}
+	results := []models.UpsertResult{}
+	for _, v := range []float64{127.0, 127.0, 220.0} {
+		i.Voltage = v
+		res, err := i.UpsertInverterInDB(ctx, s.DB)
+		if err != nil {
+			t.Errorf("Failed while upserting inverter: %v\n", err)
+			return
+		}
+		results = append(results, res)
+	}
+	assert.Equal(t, []models.UpsertResult{models.Created, models.Unchanged, models.Changed}, results)
+	// Reads again from DB and compares modified fields
+	newi := models.Inverter{
+		Serial: "INVERTER1",
+	}
+	newi.ReadInverter(ctx, s.DB)
+	assert.Equal(t, 220.0, newi.Voltage)
+}
+
+func TestConcurrentUpsertInverter(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshInverterCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Upserts the same new inverter from many routines
+	errs := make(chan error, 8)
+	for n := 0; n < 8; n++ {
+		go func() {
+			i := models.Inverter{
+				Serial: "INVERTER1",
+			}
+			_, err := i.UpsertInverterInDB(ctx, s.DB)
+			errs <- err
+		}()
+	}
+	for n := 0; n < 8; n++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Failed while upserting inverter: %v\n", err)
+		}
+	}
+	// Checks if the DB has repeated inverters
+	invs, _ := models.ListInverters(ctx, s.DB)
+	assert.Equal(t, 1, len(invs))
+}
+
+func TestInverterDBUnavailable(t *testing.T) {
+	ctx := context.Background()
+	// A DB that is never reached
+	opts := options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(500 * time.Millisecond)
+	client, err := mongo.NewClient(opts)
+	if err != nil {
+		log.Fatalf("Error creating the DB client: %v", err)
+	}
+	if err := client.Connect(context.Background()); err != nil {
+		log.Fatalf("Error connecting the DB client: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("unavailable")
+	// Network failures are not taken as the inverter being found or not
+	i := models.Inverter{
+		Serial: "INVERTER1",
+	}
+	found, err := i.AlreadyInDB(ctx, db)
+	assert.False(t, found)
+	assert.True(t, errors.Is(err, models.ErrUnavailable), err)
+	assert.True(t, errors.Is(i.ReadInverter(ctx, db), models.ErrUnavailable))
+	assert.False(t, errors.Is(i.ReadInverter(ctx, db), models.ErrNotFound))
+	// The lists and the rollups are classified the same way
+	_, err = models.ListInverters(ctx, db)
+	assert.True(t, errors.Is(err, models.ErrUnavailable), err)
+	_, err = models.ListModules(ctx, db, bson.M{})
+	assert.True(t, errors.Is(err, models.ErrUnavailable), err)
+	err = models.UpdateTelemetryDailyData(ctx, db, time.Now())
+	assert.True(t, errors.Is(err, models.ErrUnavailable), err)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleRegistryFromTelemetry(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshModuleCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Registers a module from reads out of order
+	data := []*models.TelemetryData{
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 200, InputCurrent: 2.0},
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 100, InputCurrent: 1.0},
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 300, InputCurrent: 3.0},
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 250, InputCurrent: 2.5},
+	}
+	if err := models.UpdateModuleRegistry(ctx, s.DB, data); err != nil {
+		t.Errorf("Error while updating the registry: %v\n", err)
+		return
+	}
+	modules, err := models.ListModulesOfInverter(ctx, s.DB, "INVERTER1")
+	if err != nil {
+		t.Errorf("Error while listing modules in DB: %v\n", err)
+		return
+	}
+	assert.Equal(t, 1, len(modules))
+	assert.Equal(t, int64(100), modules[0].FirstSeen)
+	assert.Equal(t, int64(300), modules[0].LastSeen)
+	assert.Equal(t, 3.0, modules[0].LastInputCurrent)
+}
+
+func TestEvaluateModuleHealth(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshModuleCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Registers modules with a stale one and a deviating one
+	data := []*models.TelemetryData{
+		{Serial: "INVERTER1", Module: "MODULE-1", LastTelemetryTime: 1000, InputCurrent: 8.0},
+		{Serial: "INVERTER1", Module: "MODULE-2", LastTelemetryTime: 1000, InputCurrent: 8.2},
+		{Serial: "INVERTER1", Module: "MODULE-3", LastTelemetryTime: 1000, InputCurrent: 7.9},
+		{Serial: "INVERTER1", Module: "MODULE-4", LastTelemetryTime: 1000, InputCurrent: 4.0},
+		{Serial: "INVERTER1", Module: "MODULE-5", LastTelemetryTime: 10, InputCurrent: 8.0},
+	}
+	if err := models.UpdateModuleRegistry(ctx, s.DB, data); err != nil {
+		t.Errorf("Error while updating the registry: %v\n", err)
+		return
+	}
+	if _, err := models.EvaluateModuleHealth(ctx, s.DB, "INVERTER1", 1100, 500, 0.2); err != nil {
+		t.Errorf("Error while evaluating the modules: %v\n", err)
+		return
+	}
+	modules, _ := models.ListModulesOfInverter(ctx, s.DB, "INVERTER1")
+	health := map[string]string{}
+	for _, m := range modules {
+		health[m.Module] = m.Health
+	}
+	assert.Equal(t, map[string]string{
+		"MODULE-1": models.ModuleHealthy,
+		"MODULE-2": models.ModuleHealthy,
+		"MODULE-3": models.ModuleHealthy,
+		"MODULE-4": models.ModuleDeviating,
+		"MODULE-5": models.ModuleStale,
+	}, health)
+}
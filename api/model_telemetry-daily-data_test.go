@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/seed"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUpdateTelemetryDailyData(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collections
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	if err := s.RefreshTelemetryDailyDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Seeds the collection for testing data
+	if err := seed.LoadTelemetryData(s.DB); err != nil {
+		log.Fatalf("Error seeding the DB: %v", err)
+	}
+	// Aggregates the seeded day twice, which should not repeat rollups
+	day := time.Unix(0, 0)
+	for i := 0; i < 2; i++ {
+		if err := models.UpdateTelemetryDailyData(ctx, s.DB, day); err != nil {
+			t.Errorf("Error while updating daily data: %v\n", err)
+			return
+		}
+	}
+	// Verifies the rollups in DB
+	daily, err := models.ListTelemetryDailyData(ctx, s.DB, bson.M{})
+	if err != nil {
+		t.Errorf("Error while listing daily data in DB: %v\n", err)
+		return
+	}
+	assert.Equal(t, 3, len(daily))
+	for _, d := range daily {
+		assert.Equal(t, int64(100), d.Samples)
+	}
+}
+
+func TestTelemetryDataHasRetentionTime(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Adds data and reads it back
+	d := models.TelemetryData{
+		Serial:            "INVERTER1",
+		LastTelemetryTime: 1600000000,
+	}
+	if _, err := d.AddDataToDB(ctx, s.DB); err != nil {
+		t.Errorf("Failed while adding new data to DB: %v\n", err)
+		return
+	}
+	data, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 1, len(data))
+	assert.Equal(t, int64(1600000000), data[0].TelemetryTime.Unix())
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -25,7 +26,7 @@ func TestListTelemetryData(t *testing.T) {
 		log.Fatalf("Error seeding the DB: %v", err)
 	}
 	// Verifies the data in DB
-	invs, err := models.ListTelemetryData(s.DB, bson.M{})
+	invs, err := models.ListTelemetryData(ctx, s.DB, bson.M{})
 	if err != nil {
 		t.Errorf("Error while listing telemetry data in DB: %v\n", err)
 		return
@@ -44,7 +45,12 @@ func TestDetectTelemetryDataNotInDB(t *testing.T) {
 		Serial:            "INVERTER1",
 		LastTelemetryTime: 0,
 	}
-	if d.AlreadyAcquired(s.DB) {
+	found, err := d.AlreadyAcquired(ctx, s.DB)
+	if err != nil {
+		t.Errorf("Error while looking for the data: %v\n", err)
+		return
+	}
+	if found {
 		t.Errorf("Found data that should not exist in DB\n")
 		return
 	}
@@ -65,8 +71,8 @@ func TestDetectTelemetryDataAlreadyInDB(t *testing.T) {
 		Serial:            "INVERTER1",
 		LastTelemetryTime: 0,
 	}
-	if !d.AlreadyAcquired(s.DB) {
-		t.Errorf("Failed to detect data already in the DB\n")
+	if found, err := d.AlreadyAcquired(ctx, s.DB); !found || err != nil {
+		t.Errorf("Failed to detect data already in the DB: %v\n", err)
 		return
 	}
 }
@@ -86,8 +92,8 @@ func TestCreatingRepeatedTelemetryData(t *testing.T) {
 		Serial:            "INVERTER1",
 		LastTelemetryTime: 0,
 	}
-	if _, err := d.AddDataToDB(s.DB); err == nil {
-		t.Errorf("Should have failed while adding repeated data\n")
+	if _, err := d.AddDataToDB(ctx, s.DB); !errors.Is(err, models.ErrDuplicate) {
+		t.Errorf("Should have failed while adding repeated data: %v\n", err)
 		return
 	}
 }
@@ -107,12 +113,12 @@ func TestCreatingNewTelemetryData(t *testing.T) {
 		Serial:            "INVERTER4",
 		LastTelemetryTime: 0,
 	}
-	if _, err := d.AddDataToDB(s.DB); err != nil {
+	if _, err := d.AddDataToDB(ctx, s.DB); err != nil {
 		t.Errorf("Failed while adding new data to DB: %v\n", err)
 		return
 	}
 	// List the existing data and checks the amount
-	invs, _ := models.ListTelemetryData(s.DB, bson.M{})
+	invs, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
 	assert.Equal(t, 301, len(invs))
 }
 
@@ -128,6 +134,34 @@ func TestCreateTelemetryDataFromScrapper(t *testing.T) {
 	go s.TelemetryCollector.Visit(tURL)
 	time.Sleep(100 * time.Millisecond)
 	// Checks if the data is in DB
-	td, _ := models.ListTelemetryData(s.DB, bson.M{})
+	td, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
 	assert.Equal(t, 1, len(td))
 }
+
+func TestAddingTelemetryDataBatch(t *testing.T) {
+	ctx := context.Background()
+	// Removes all data in the collection
+	if err := s.RefreshTelemetryDataCollection(ctx); err != nil {
+		log.Fatalf("Error refreshing the DB: %v", err)
+	}
+	// Seeds the collection for testing data
+	if err := seed.LoadTelemetryData(s.DB); err != nil {
+		log.Fatalf("Error seeding the DB: %v", err)
+	}
+	// Adds a batch with data already in DB and repeated in the batch
+	batch := []*models.TelemetryData{
+		{Serial: "INVERTER1", LastTelemetryTime: 0},
+		{Serial: "INVERTER4", LastTelemetryTime: 0},
+		{Serial: "INVERTER4", LastTelemetryTime: 0},
+		{Serial: "INVERTER4", LastTelemetryTime: 100},
+	}
+	n, err := models.AddDataBatchToDB(ctx, s.DB, batch)
+	if err != nil {
+		t.Errorf("Failed while adding a batch to DB: %v\n", err)
+		return
+	}
+	assert.Equal(t, 2, n)
+	// List the existing data and checks the amount
+	invs, _ := models.ListTelemetryData(ctx, s.DB, bson.M{})
+	assert.Equal(t, 302, len(invs))
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var anomalyCollection = "anomalies"
+
+// The severities of the anomalies, by how far the module is from its peers
+const (
+	AnomalyLow    = "low"
+	AnomalyMedium = "medium"
+	AnomalyHigh   = "high"
+)
+
+// minPeers : the fewest modules reporting in a slot for it to be compared
+const minPeers = 3
+
+// AnomalyConfig : how the modules are compared to their peers. The window is split in slots of
+// SlotPeriod seconds, and in each slot with enough peers a module is an outlier when a value
+// deviates from the median of the peers by more than Threshold, as a fraction of the median. A
+// module with outliers in at least Persistence of the compared slots, and in MinSlots or more,
+// is an anomaly.
+type AnomalyConfig struct {
+	SlotPeriod  int64
+	Threshold   float64
+	Persistence float64
+	MinSlots    int64
+}
+
+// Anomaly : a module whose value persistently deviated from its peers in a time window
+type Anomaly struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Serial      string             `bson:"serial" json:"serial"`
+	Module      string             `bson:"module" json:"module"`
+	Field       string             `bson:"field" json:"field"`
+	WindowStart int64              `bson:"windowStart" json:"windowStart"`
+	WindowEnd   int64              `bson:"windowEnd" json:"windowEnd"`
+	Slots       int64              `bson:"slots" json:"slots"`
+	Outliers    int64              `bson:"outliers" json:"outliers"`
+	// Deviation : the median deviation of the module in the outlier slots, as a fraction
+	Deviation  float64   `bson:"deviation" json:"deviation"`
+	Severity   string    `bson:"severity" json:"severity"`
+	DetectedAt time.Time `bson:"detectedAt" json:"detectedAt"`
+	Reviewed   bool      `bson:"reviewed" json:"reviewed"`
+}
+
+// anomalyFields : the values of the telemetry compared between peers
+var anomalyFields = map[string]func(*TelemetryData) float64{
+	"inputVoltage": func(t *TelemetryData) float64 { return t.InputVoltage },
+	"inputCurrent": func(t *TelemetryData) float64 { return t.InputCurrent },
+}
+
+// ListAnomalies : reads anomalies from DB using an filter, the newest first
+func ListAnomalies(ctx context.Context, db *mongo.Database, filter bson.M) ([]*Anomaly, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "windowStart", Value: -1}})
+	cur, err := db.Collection(anomalyCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*Anomaly{}, dbError(err, "anomalies")
+	}
+	defer cur.Close(ctx)
+	anomalies := []*Anomaly{}
+	for cur.Next(ctx) {
+		var a Anomaly
+		if err := cur.Decode(&a); err != nil {
+			return anomalies, dbError(err, "anomalies")
+		}
+		anomalies = append(anomalies, &a)
+	}
+	return anomalies, nil
+}
+
+// ReviewAnomaly : marks an anomaly as reviewed
+func ReviewAnomaly(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"reviewed": true}}
+	res, err := db.Collection(anomalyCollection).UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return dbError(err, "anomaly %v", id.Hex())
+	}
+	if res.MatchedCount == 0 {
+		return NotFound("anomaly %v", id.Hex())
+	}
+	return nil
+}
+
+// anomalySeverity : the severity of a deviation, by how many thresholds it is away
+func anomalySeverity(deviation, threshold float64) string {
+	switch d := math.Abs(deviation); {
+	case d >= 3*threshold:
+		return AnomalyHigh
+	case d >= 2*threshold:
+		return AnomalyMedium
+	default:
+		return AnomalyLow
+	}
+}
+
+// DetectModuleAnomalies : compares the modules of an inverter with their peers between two
+// Unix times and stores the anomalies found, keeping if the ones found before were reviewed
+func DetectModuleAnomalies(ctx context.Context, db *mongo.Database, serial string, start, end int64, cfg AnomalyConfig) ([]*Anomaly, error) {
+	anomalies := []*Anomaly{}
+	if cfg.SlotPeriod <= 0 {
+		return anomalies, nil
+	}
+	data, err := ListTelemetryData(ctx, db, bson.M{
+		"serial":            serial,
+		"lastTelemetryTime": bson.M{"$gte": start, "$lt": end},
+	})
+	if err != nil {
+		return anomalies, err
+	}
+	// Groups the newest read of each module in each slot
+	slots := map[int64]map[string]*TelemetryData{}
+	for _, t := range data {
+		slot := (t.LastTelemetryTime - start) / cfg.SlotPeriod
+		if slots[slot] == nil {
+			slots[slot] = map[string]*TelemetryData{}
+		}
+		if old, ok := slots[slot][t.Module]; !ok || old.LastTelemetryTime < t.LastTelemetryTime {
+			slots[slot][t.Module] = t
+		}
+	}
+	for field, value := range anomalyFields {
+		compared := map[string]int64{}
+		deviations := map[string][]float64{}
+		for _, reads := range slots {
+			if len(reads) < minPeers {
+				continue
+			}
+			values := []float64{}
+			for _, t := range reads {
+				values = append(values, value(t))
+			}
+			med := Median(values)
+			// Without sun the values are too small to be compared
+			if (field == "inputCurrent" && med < minPeerCurrent) || med <= 0 {
+				continue
+			}
+			for module, t := range reads {
+				compared[module]++
+				d := (value(t) - med) / med
+				if math.Abs(d) > cfg.Threshold {
+					deviations[module] = append(deviations[module], d)
+				}
+			}
+		}
+		for module, n := range compared {
+			outliers := int64(len(deviations[module]))
+			if n < cfg.MinSlots || float64(outliers) < cfg.Persistence*float64(n) || outliers == 0 {
+				continue
+			}
+			dev := Median(deviations[module])
+			anomalies = append(anomalies, &Anomaly{
+				Serial:      serial,
+				Module:      module,
+				Field:       field,
+				WindowStart: start,
+				WindowEnd:   end,
+				Slots:       n,
+				Outliers:    outliers,
+				Deviation:   dev,
+				Severity:    anomalySeverity(dev, cfg.Threshold),
+				DetectedAt:  time.Now(),
+			})
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].Module != anomalies[j].Module {
+			return anomalies[i].Module < anomalies[j].Module
+		}
+		return anomalies[i].Field < anomalies[j].Field
+	})
+	for _, a := range anomalies {
+		filter := bson.M{
+			"serial":      a.Serial,
+			"module":      a.Module,
+			"field":       a.Field,
+			"windowStart": a.WindowStart,
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"windowEnd":  a.WindowEnd,
+				"slots":      a.Slots,
+				"outliers":   a.Outliers,
+				"deviation":  a.Deviation,
+				"severity":   a.Severity,
+				"detectedAt": a.DetectedAt,
+			},
+			"$setOnInsert": bson.M{"reviewed": false},
+		}
+		opts := options.Update().SetUpsert(true)
+		if _, err := db.Collection(anomalyCollection).UpdateOne(ctx, filter, update, opts); err != nil {
+			return anomalies, dbError(err, "anomaly of %v %v", a.Serial, a.Module)
+		}
+	}
+	return anomalies, nil
+}
@@ -0,0 +1,259 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var telemetryGapCollection = "telemetryGaps"
+var telemetryCompletenessCollection = "telemetryCompleteness"
+
+// CompletenessWindow : the expected telemetry samples of a day, one in each period between
+// two hours of the device clock, as the modules do not report without sun
+type CompletenessWindow struct {
+	Period   int64
+	FromHour int
+	ToHour   int
+}
+
+// TelemetryGap : an interval of a day without the expected telemetry of a module
+type TelemetryGap struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Serial  string             `bson:"serial" json:"serial"`
+	Module  string             `bson:"module" json:"module"`
+	Day     time.Time          `bson:"day" json:"day"`
+	Start   int64              `bson:"start" json:"start"`
+	End     int64              `bson:"end" json:"end"`
+	Missing int64              `bson:"missing" json:"missing"`
+}
+
+// TelemetryCompleteness : how many of the expected telemetry samples of a day were acquired
+// for a module, or for all the modules of an inverter when the module is empty
+type TelemetryCompleteness struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Serial       string             `bson:"serial" json:"serial"`
+	Module       string             `bson:"module" json:"module"`
+	Day          time.Time          `bson:"day" json:"day"`
+	Expected     int64              `bson:"expected" json:"expected"`
+	Received     int64              `bson:"received" json:"received"`
+	Completeness float64            `bson:"completeness" json:"completeness"`
+	Gaps         int64              `bson:"gaps" json:"gaps"`
+}
+
+// ExpectedSampleTimes : the Unix times that start each expected sample period of a day
+func (w CompletenessWindow) ExpectedSampleTimes(day time.Time) []int64 {
+	times := []int64{}
+	if w.Period <= 0 {
+		return times
+	}
+	start := StartOfDay(day)
+	end := start.Add(time.Duration(w.ToHour) * time.Hour).Unix()
+	for t := start.Add(time.Duration(w.FromHour) * time.Hour).Unix(); t < end; t += w.Period {
+		times = append(times, t)
+	}
+	return times
+}
+
+// ListTelemetryGaps : reads telemetry gaps from DB using an filter
+func ListTelemetryGaps(ctx context.Context, db *mongo.Database, filter bson.M) ([]*TelemetryGap, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}})
+	cur, err := db.Collection(telemetryGapCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*TelemetryGap{}, dbError(err, "telemetry gaps")
+	}
+	defer cur.Close(ctx)
+	gaps := []*TelemetryGap{}
+	for cur.Next(ctx) {
+		var g TelemetryGap
+		if err := cur.Decode(&g); err != nil {
+			return gaps, dbError(err, "telemetry gaps")
+		}
+		gaps = append(gaps, &g)
+	}
+	return gaps, nil
+}
+
+// ListTelemetryCompleteness : reads telemetry completeness from DB using an filter
+func ListTelemetryCompleteness(ctx context.Context, db *mongo.Database, filter bson.M) ([]*TelemetryCompleteness, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "serial", Value: 1}, {Key: "module", Value: 1}})
+	cur, err := db.Collection(telemetryCompletenessCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*TelemetryCompleteness{}, dbError(err, "telemetry completeness")
+	}
+	defer cur.Close(ctx)
+	completeness := []*TelemetryCompleteness{}
+	for cur.Next(ctx) {
+		var c TelemetryCompleteness
+		if err := cur.Decode(&c); err != nil {
+			return completeness, dbError(err, "telemetry completeness")
+		}
+		completeness = append(completeness, &c)
+	}
+	return completeness, nil
+}
+
+// readSampleTimes : reads the telemetry times of each serial and module between two Unix times
+func readSampleTimes(ctx context.Context, db *mongo.Database, start, end int64) (map[string]map[string][]int64, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"lastTelemetryTime": bson.M{"$gte": start, "$lt": end},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"serial": "$serial", "module": "$module"},
+			"times": bson.M{"$push": "$lastTelemetryTime"},
+		}},
+	}
+	cur, err := db.Collection(telemetryDataCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, dbError(err, "telemetry samples")
+	}
+	defer cur.Close(ctx)
+	samples := map[string]map[string][]int64{}
+	for cur.Next(ctx) {
+		var g struct {
+			ID struct {
+				Serial string `bson:"serial"`
+				Module string `bson:"module"`
+			} `bson:"_id"`
+			Times []int64 `bson:"times"`
+		}
+		if err := cur.Decode(&g); err != nil {
+			return nil, dbError(err, "telemetry samples")
+		}
+		if samples[g.ID.Serial] == nil {
+			samples[g.ID.Serial] = map[string][]int64{}
+		}
+		samples[g.ID.Serial][g.ID.Module] = g.Times
+	}
+	return samples, dbError(cur.Err(), "telemetry samples")
+}
+
+// findGaps : the runs of expected periods without samples. The samples must be sorted.
+func findGaps(expected, samples []int64, period int64) (int64, []*TelemetryGap) {
+	received := int64(0)
+	gaps := []*TelemetryGap{}
+	var gap *TelemetryGap
+	i := 0
+	for _, t := range expected {
+		for i < len(samples) && samples[i] < t {
+			i++
+		}
+		if i < len(samples) && samples[i] < t+period {
+			received++
+			gap = nil
+			continue
+		}
+		if gap == nil {
+			gap = &TelemetryGap{Start: t}
+			gaps = append(gaps, gap)
+		}
+		gap.End = t + period
+		gap.Missing++
+	}
+	return received, gaps
+}
+
+// UpdateTelemetryCompleteness : finds the gaps in the telemetry of each registered module in a
+// day and stores them, replacing the ones found before, together with the completeness of each
+// module and inverter. The periods not ended at the Unix time now are not expected yet.
+func UpdateTelemetryCompleteness(ctx context.Context, db *mongo.Database, day time.Time, w CompletenessWindow, now int64) ([]*TelemetryCompleteness, error) {
+	day = StartOfDay(day)
+	expected := []int64{}
+	for _, t := range w.ExpectedSampleTimes(day) {
+		if t+w.Period <= now {
+			expected = append(expected, t)
+		}
+	}
+	report := []*TelemetryCompleteness{}
+	if len(expected) == 0 {
+		return report, nil
+	}
+	start := expected[0]
+	end := expected[len(expected)-1] + w.Period
+	samples, err := readSampleTimes(ctx, db, start, end)
+	if err != nil {
+		return report, err
+	}
+	// Modules registered until the end of the window are expected, even without any sample
+	modules, err := ListModules(ctx, db, bson.M{"firstSeen": bson.M{"$lt": end}})
+	if err != nil {
+		return report, err
+	}
+	for _, m := range modules {
+		if samples[m.Serial] == nil {
+			samples[m.Serial] = map[string][]int64{}
+		}
+		if _, ok := samples[m.Serial][m.Module]; !ok {
+			samples[m.Serial][m.Module] = []int64{}
+		}
+	}
+	if _, err := db.Collection(telemetryGapCollection).DeleteMany(ctx, bson.M{"day": day}); err != nil {
+		return report, dbError(err, "telemetry gaps on %v", day.Format("2006-01-02"))
+	}
+	for serial, bySerial := range samples {
+		total := &TelemetryCompleteness{
+			Serial: serial,
+			Day:    day,
+		}
+		for module, times := range bySerial {
+			sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+			received, gaps := findGaps(expected, times, w.Period)
+			c := &TelemetryCompleteness{
+				Serial:   serial,
+				Module:   module,
+				Day:      day,
+				Expected: int64(len(expected)),
+				Received: received,
+				Gaps:     int64(len(gaps)),
+			}
+			report = append(report, c)
+			total.Expected += c.Expected
+			total.Received += c.Received
+			total.Gaps += c.Gaps
+			docs := []interface{}{}
+			for _, g := range gaps {
+				g.Serial = serial
+				g.Module = module
+				g.Day = day
+				docs = append(docs, g)
+			}
+			if len(docs) > 0 {
+				if _, err := db.Collection(telemetryGapCollection).InsertMany(ctx, docs); err != nil {
+					return report, dbError(err, "telemetry gaps on %v", day.Format("2006-01-02"))
+				}
+			}
+		}
+		report = append(report, total)
+	}
+	for _, c := range report {
+		c.Completeness = 100 * float64(c.Received) / float64(c.Expected)
+		filter := bson.M{
+			"serial": c.Serial,
+			"module": c.Module,
+			"day":    c.Day,
+		}
+		update := bson.M{"$set": bson.M{
+			"expected":     c.Expected,
+			"received":     c.Received,
+			"completeness": c.Completeness,
+			"gaps":         c.Gaps,
+		}}
+		opts := options.Update().SetUpsert(true)
+		if _, err := db.Collection(telemetryCompletenessCollection).UpdateOne(ctx, filter, update, opts); err != nil {
+			return report, dbError(err, "completeness of %v %v on %v", c.Serial, c.Module, day.Format("2006-01-02"))
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Serial != report[j].Serial {
+			return report[i].Serial < report[j].Serial
+		}
+		return report[i].Module < report[j].Module
+	})
+	return report, nil
+}
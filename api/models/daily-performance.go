@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var dailyPerformanceCollection = "dailyPerformance"
+
+// The scopes of the daily performance indicators
+const (
+	InverterScope = "inverter"
+	SiteScope     = "site"
+)
+
+// DailyPerformance : the energy and performance indicators of an inverter or a site in a day
+type DailyPerformance struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Scope            string             `bson:"scope" json:"scope"`
+	Name             string             `bson:"name" json:"name"`
+	Day              time.Time          `bson:"day" json:"day"`
+	Energy           float64            `bson:"energy" json:"energy"`
+	PeakPower        float64            `bson:"peakPower" json:"peakPower"`
+	Insolation       float64            `bson:"insolation" json:"insolation"`
+	SpecificYield    float64            `bson:"specificYield" json:"specificYield"`
+	PerformanceRatio float64            `bson:"performanceRatio" json:"performanceRatio"`
+}
+
+// ListDailyPerformance : reads daily performance indicators from DB using an filter
+func ListDailyPerformance(ctx context.Context, db *mongo.Database, filter bson.M) ([]*DailyPerformance, error) {
+	cur, err := db.Collection(dailyPerformanceCollection).Find(ctx, filter)
+	if err != nil {
+		return []*DailyPerformance{}, dbError(err, "daily performance")
+	}
+	defer cur.Close(ctx)
+	perfs := []*DailyPerformance{}
+	for cur.Next(ctx) {
+		var p DailyPerformance
+		if err := cur.Decode(&p); err != nil {
+			return perfs, dbError(err, "daily performance")
+		}
+		perfs = append(perfs, &p)
+	}
+	return perfs, nil
+}
+
+// RecordInverterEnergy : keeps the largest energy counter of an inverter seen in a day
+func RecordInverterEnergy(ctx context.Context, db *mongo.Database, serial string, day time.Time, energy float64) error {
+	filter := bson.M{
+		"scope": InverterScope,
+		"name":  serial,
+		"day":   StartOfDay(day),
+	}
+	update := bson.M{"$max": bson.M{"energy": energy}}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.Collection(dailyPerformanceCollection).UpdateOne(ctx, filter, update, opts)
+	return dbError(err, "energy of %v on %v", serial, StartOfDay(day).Format("2006-01-02"))
+}
+
+// ReplaceInverterEnergy : sets the energy counter of an inverter in a day, even if lower than
+// the one kept before, as when its pages are parsed again
+func ReplaceInverterEnergy(ctx context.Context, db *mongo.Database, serial string, day time.Time, energy float64) error {
+	filter := bson.M{
+		"scope": InverterScope,
+		"name":  serial,
+		"day":   StartOfDay(day),
+	}
+	update := bson.M{"$set": bson.M{"energy": energy}}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.Collection(dailyPerformanceCollection).UpdateOne(ctx, filter, update, opts)
+	return dbError(err, "energy of %v on %v", serial, StartOfDay(day).Format("2006-01-02"))
+}
+
+// ReadDailyPerformance : reads the indicators of a scope, name and day
+func (p *DailyPerformance) ReadDailyPerformance(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{
+		"scope": p.Scope,
+		"name":  p.Name,
+		"day":   StartOfDay(p.Day),
+	}
+	res := db.Collection(dailyPerformanceCollection).FindOne(ctx, filter)
+	if res.Err() != nil {
+		return dbError(res.Err(), "performance of %v %v on %v", p.Scope, p.Name, StartOfDay(p.Day).Format("2006-01-02"))
+	}
+	return res.Decode(p)
+}
+
+// ComputeIndicators : computes the specific yield (kWh/kWp) and the performance ratio from
+// the energy (kWh), the peak power (kWp) and the insolation (kWh/m²)
+func (p *DailyPerformance) ComputeIndicators() {
+	p.SpecificYield = 0
+	p.PerformanceRatio = 0
+	if p.PeakPower > 0 {
+		p.SpecificYield = p.Energy / p.PeakPower
+	}
+	// The reference yield is the insolation divided by the 1 kW/m² of the rated conditions
+	if p.Insolation > 0 {
+		p.PerformanceRatio = p.SpecificYield / p.Insolation
+	}
+}
+
+// UpsertDailyPerformanceInDB : adds or updates the indicators of a scope, name and day. The
+// energy of inverters is only changed by RecordInverterEnergy and ReplaceInverterEnergy.
+func (p *DailyPerformance) UpsertDailyPerformanceInDB(ctx context.Context, db *mongo.Database) (UpsertResult, error) {
+	p.Day = StartOfDay(p.Day)
+	filter := bson.M{
+		"scope": p.Scope,
+		"name":  p.Name,
+		"day":   p.Day,
+	}
+	set := bson.M{
+		"peakPower":        p.PeakPower,
+		"insolation":       p.Insolation,
+		"specificYield":    p.SpecificYield,
+		"performanceRatio": p.PerformanceRatio,
+	}
+	if p.Scope != InverterScope {
+		set["energy"] = p.Energy
+	}
+	update := bson.M{"$set": set}
+	opts := options.Update().SetUpsert(true)
+	res, err := db.Collection(dailyPerformanceCollection).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return Unchanged, dbError(err, "performance of %v %v on %v", p.Scope, p.Name, StartOfDay(p.Day).Format("2006-01-02"))
+	}
+	return upsertResultFrom(res), nil
+}
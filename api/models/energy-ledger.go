@@ -0,0 +1,307 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var energyLedgerCollection = "energyLedger"
+var energyDailyCollection = "energyDaily"
+
+// The kinds of the intervals in the energy ledger
+const (
+	// EnergyBaseline : the first counter of a serial, which has no energy
+	EnergyBaseline = "baseline"
+	// EnergyDelta : the energy is the increase of the counter
+	EnergyDelta = "delta"
+	// EnergyGap : as a delta, but over an interval longer than the expected
+	EnergyGap = "gap"
+	// EnergyReset : the counter restarted from zero, so the energy is the counter itself
+	EnergyReset = "reset"
+	// EnergyRollback : the counter went back a little, so the energy is ignored
+	EnergyRollback = "rollback"
+)
+
+// energyResetFraction : a counter below this fraction of the previous one is taken as a reset
+const energyResetFraction = 0.5
+
+// energyLedgerLocks : the locks of the ledger of each serial, as an append reads the last entry
+// before inserting the next one, and concurrent appends would count the same energy twice
+var energyLedgerLocks = struct {
+	sync.Mutex
+	serials map[string]*sync.Mutex
+}{serials: map[string]*sync.Mutex{}}
+
+// lockEnergyLedger : locks the ledger of a serial, returning the function that unlocks it
+func lockEnergyLedger(serial string) func() {
+	energyLedgerLocks.Lock()
+	l, ok := energyLedgerLocks.serials[serial]
+	if !ok {
+		l = &sync.Mutex{}
+		energyLedgerLocks.serials[serial] = l
+	}
+	energyLedgerLocks.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// EnergyLedgerEntry : the energy produced by an inverter between two reads of its total
+// energy counter, which is the only one that does not reset with the day, month or year
+type EnergyLedgerEntry struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Serial string             `bson:"serial" json:"serial"`
+	Day    time.Time          `bson:"day" json:"day"`
+	Start  int64              `bson:"start" json:"start"`
+	End    int64              `bson:"end" json:"end"`
+	// Counter : the total energy read at the end of the interval, in kWh
+	Counter float64 `bson:"counter" json:"counter"`
+	// Baseline : the counter that the next read is compared to, which is kept on rollbacks
+	Baseline float64 `bson:"baseline" json:"baseline"`
+	Energy   float64 `bson:"energy" json:"energy"`
+	Kind     string  `bson:"kind" json:"kind"`
+}
+
+// EnergyDaily : the energy of an inverter in a day from the ledger, reconciled against the
+// daily counter of the device
+type EnergyDaily struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Serial       string             `bson:"serial" json:"serial"`
+	Day          time.Time          `bson:"day" json:"day"`
+	Energy       float64            `bson:"energy" json:"energy"`
+	DeviceEnergy float64            `bson:"deviceEnergy" json:"deviceEnergy"`
+	Difference   float64            `bson:"difference" json:"difference"`
+	Intervals    int64              `bson:"intervals" json:"intervals"`
+	Gaps         int64              `bson:"gaps" json:"gaps"`
+	Resets       int64              `bson:"resets" json:"resets"`
+	Rollbacks    int64              `bson:"rollbacks" json:"rollbacks"`
+}
+
+// ListEnergyLedger : reads energy ledger entries from DB using an filter, sorted by time
+func ListEnergyLedger(ctx context.Context, db *mongo.Database, filter bson.M) ([]*EnergyLedgerEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "end", Value: 1}})
+	cur, err := db.Collection(energyLedgerCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*EnergyLedgerEntry{}, dbError(err, "energy ledger")
+	}
+	defer cur.Close(ctx)
+	entries := []*EnergyLedgerEntry{}
+	for cur.Next(ctx) {
+		var e EnergyLedgerEntry
+		if err := cur.Decode(&e); err != nil {
+			return entries, dbError(err, "energy ledger")
+		}
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+// lastEnergyLedgerEntry : reads the newest ledger entry of a serial that matches a filter
+func lastEnergyLedgerEntry(ctx context.Context, db *mongo.Database, serial string, filter bson.M) (*EnergyLedgerEntry, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "end", Value: -1}})
+	filter["serial"] = serial
+	res := db.Collection(energyLedgerCollection).FindOne(ctx, filter, opts)
+	if res.Err() != nil {
+		return nil, dbError(res.Err(), "energy ledger of %v", serial)
+	}
+	var e EnergyLedgerEntry
+	if err := res.Decode(&e); err != nil {
+		return nil, dbError(err, "energy ledger of %v", serial)
+	}
+	return &e, nil
+}
+
+// nextEnergyLedgerEntry : the interval from the last entry of a serial, if any, to a read of
+// its total energy counter at a Unix time of the device clock. Intervals longer than maxGap
+// seconds are flagged as gaps, and reads not newer than the last one have no interval.
+func nextEnergyLedgerEntry(last *EnergyLedgerEntry, serial string, at int64, counter float64, maxGap int64) *EnergyLedgerEntry {
+	e := EnergyLedgerEntry{
+		Serial:   serial,
+		Day:      StartOfDay(time.Unix(at, 0).UTC()),
+		Start:    at,
+		End:      at,
+		Counter:  counter,
+		Baseline: counter,
+		Kind:     EnergyBaseline,
+	}
+	if last == nil {
+		return &e
+	}
+	if at <= last.End {
+		return nil
+	}
+	e.Start = last.End
+	delta := counter - last.Baseline
+	switch {
+	case delta >= 0 && maxGap > 0 && at-last.End > maxGap:
+		e.Kind = EnergyGap
+		e.Energy = delta
+	case delta >= 0:
+		e.Kind = EnergyDelta
+		e.Energy = delta
+	case counter < last.Baseline*energyResetFraction:
+		e.Kind = EnergyReset
+		e.Energy = counter
+	default:
+		e.Kind = EnergyRollback
+		e.Baseline = last.Baseline
+	}
+	return &e
+}
+
+// AppendEnergyLedger : adds the interval since the previous read of the total energy counter
+// of an inverter, read at a Unix time of the device clock. Intervals longer than maxGap seconds
+// are flagged as gaps, and reads not newer than the last one are ignored. The appends to the
+// ledger of a serial are serialized.
+func AppendEnergyLedger(ctx context.Context, db *mongo.Database, serial string, at int64, counter float64, maxGap int64) (*EnergyLedgerEntry, error) {
+	defer lockEnergyLedger(serial)()
+	last, err := lastEnergyLedgerEntry(ctx, db, serial, bson.M{})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	e := nextEnergyLedgerEntry(last, serial, at, counter, maxGap)
+	if e == nil {
+		return last, nil
+	}
+	if _, err := db.Collection(energyLedgerCollection).InsertOne(ctx, e); err != nil {
+		return nil, dbError(err, "energy ledger of %v at %v", serial, at)
+	}
+	return e, nil
+}
+
+// EnergyRead : a read of the total energy counter of an inverter, at a Unix time of the
+// device clock
+type EnergyRead struct {
+	At      int64
+	Counter float64
+}
+
+// RebuildEnergyLedger : replaces the ledger entries of an inverter that end between two Unix
+// times of the device clock with the intervals of some reads, which follow the last entry
+// before them. Reads out of the times are ignored.
+func RebuildEnergyLedger(ctx context.Context, db *mongo.Database, serial string, from, to int64, reads []EnergyRead, maxGap int64) ([]*EnergyLedgerEntry, error) {
+	defer lockEnergyLedger(serial)()
+	filter := bson.M{"serial": serial, "end": bson.M{"$gte": from, "$lt": to}}
+	if _, err := db.Collection(energyLedgerCollection).DeleteMany(ctx, filter); err != nil {
+		return nil, dbError(err, "energy ledger of %v", serial)
+	}
+	last, err := lastEnergyLedgerEntry(ctx, db, serial, bson.M{"end": bson.M{"$lt": from}})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	sort.Slice(reads, func(i, j int) bool { return reads[i].At < reads[j].At })
+	entries := []*EnergyLedgerEntry{}
+	docs := []interface{}{}
+	for _, r := range reads {
+		if r.At < from || r.At >= to {
+			continue
+		}
+		e := nextEnergyLedgerEntry(last, serial, r.At, r.Counter, maxGap)
+		if e == nil {
+			continue
+		}
+		entries = append(entries, e)
+		docs = append(docs, e)
+		last = e
+	}
+	if len(docs) == 0 {
+		return entries, nil
+	}
+	if _, err := db.Collection(energyLedgerCollection).InsertMany(ctx, docs); err != nil {
+		return nil, dbError(err, "energy ledger of %v", serial)
+	}
+	return entries, nil
+}
+
+// ListEnergyDaily : reads the daily energy of inverters from DB using an filter
+func ListEnergyDaily(ctx context.Context, db *mongo.Database, filter bson.M) ([]*EnergyDaily, error) {
+	cur, err := db.Collection(energyDailyCollection).Find(ctx, filter)
+	if err != nil {
+		return []*EnergyDaily{}, dbError(err, "daily energy")
+	}
+	defer cur.Close(ctx)
+	daily := []*EnergyDaily{}
+	for cur.Next(ctx) {
+		var d EnergyDaily
+		if err := cur.Decode(&d); err != nil {
+			return daily, dbError(err, "daily energy")
+		}
+		daily = append(daily, &d)
+	}
+	return daily, nil
+}
+
+// ListEnergyLedgerSerials : reads the serials with ledger entries in a day
+func ListEnergyLedgerSerials(ctx context.Context, db *mongo.Database, day time.Time) ([]string, error) {
+	values, err := db.Collection(energyLedgerCollection).Distinct(ctx, "serial", bson.M{"day": StartOfDay(day)})
+	if err != nil {
+		return []string{}, dbError(err, "energy ledger on %v", StartOfDay(day).Format("2006-01-02"))
+	}
+	serials := []string{}
+	for _, v := range values {
+		if serial, ok := v.(string); ok {
+			serials = append(serials, serial)
+		}
+	}
+	return serials, nil
+}
+
+// ReconcileEnergy : sums the ledger of an inverter in a day and compares it to the largest
+// daily counter read from the device in that day
+func ReconcileEnergy(ctx context.Context, db *mongo.Database, serial string, day time.Time) (*EnergyDaily, error) {
+	d := EnergyDaily{
+		Serial: serial,
+		Day:    StartOfDay(day),
+	}
+	entries, err := ListEnergyLedger(ctx, db, bson.M{"serial": serial, "day": d.Day})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		d.Energy += e.Energy
+		d.Intervals++
+		switch e.Kind {
+		case EnergyGap:
+			d.Gaps++
+		case EnergyReset:
+			d.Resets++
+		case EnergyRollback:
+			d.Rollbacks++
+		}
+	}
+	p := DailyPerformance{
+		Scope: InverterScope,
+		Name:  serial,
+		Day:   d.Day,
+	}
+	if err := p.ReadDailyPerformance(ctx, db); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	d.DeviceEnergy = p.Energy
+	d.Difference = d.Energy - d.DeviceEnergy
+	filter := bson.M{
+		"serial": d.Serial,
+		"day":    d.Day,
+	}
+	update := bson.M{"$set": bson.M{
+		"energy":       d.Energy,
+		"deviceEnergy": d.DeviceEnergy,
+		"difference":   d.Difference,
+		"intervals":    d.Intervals,
+		"gaps":         d.Gaps,
+		"resets":       d.Resets,
+		"rollbacks":    d.Rollbacks,
+	}}
+	opts := options.Update().SetUpsert(true)
+	if _, err := db.Collection(energyDailyCollection).UpdateOne(ctx, filter, update, opts); err != nil {
+		return nil, dbError(err, "daily energy of %v on %v", serial, d.Day.Format("2006-01-02"))
+	}
+	return &d, nil
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The kinds of the errors of the models, which are checked with errors.Is
+var (
+	// ErrNotFound : the document is not in the DB
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate : the document is already in the DB
+	ErrDuplicate = errors.New("already exists")
+	// ErrUnavailable : the DB could not be reached
+	ErrUnavailable = errors.New("DB unavailable")
+	// ErrInvalid : the data or the parameters are not valid
+	ErrInvalid = errors.New("invalid")
+)
+
+// Error : an error of a kind about a document, as "inverter INVERTER1: not found"
+type Error struct {
+	// Subject : the document, as "inverter INVERTER1"
+	Subject string
+	Kind    error
+	// Err : the error of the DB, if any
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Subject != "" {
+		msg = e.Subject + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap : the error of the DB
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is : checks the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// NotFound : the error of a document that is not in the DB
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Subject: fmt.Sprintf(format, args...), Kind: ErrNotFound}
+}
+
+// Duplicate : the error of a document that is already in the DB
+func Duplicate(format string, args ...interface{}) error {
+	return &Error{Subject: fmt.Sprintf(format, args...), Kind: ErrDuplicate}
+}
+
+// dbError : classifies the error of a DB operation about a document. The errors of no kind
+// are only prefixed by the document.
+func dbError(err error, format string, args ...interface{}) error {
+	subject := fmt.Sprintf(format, args...)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrDuplicate), errors.Is(err, ErrUnavailable):
+		return err
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return &Error{Subject: subject, Kind: ErrNotFound}
+	case IsDuplicateKeyError(err):
+		return &Error{Subject: subject, Kind: ErrDuplicate}
+	case IsUnavailableError(err):
+		return &Error{Subject: subject, Kind: ErrUnavailable, Err: err}
+	}
+	return fmt.Errorf("%v: %w", subject, err)
+}
+
+// IsUnavailableError : checks if err is a failure to reach the DB, rather than of the operation
+func IsUnavailableError(err error) bool {
+	if errors.Is(err, mongo.ErrClientDisconnected) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var labeled interface{ HasErrorLabel(string) bool }
+	if errors.As(err, &labeled) && labeled.HasErrorLabel("NetworkError") {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// The driver does not wrap the server selection errors
+	return strings.HasPrefix(err.Error(), "server selection error")
+}
+
+// duplicateKeyCode : the server error code for unique index violations
+const duplicateKeyCode = 11000
+
+// IsDuplicateKeyError : checks if all the write errors in err are unique index violations
+func IsDuplicateKeyError(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		if len(we.WriteErrors) == 0 || we.WriteConcernError != nil {
+			return false
+		}
+		for _, e := range we.WriteErrors {
+			if e.Code != duplicateKeyCode {
+				return false
+			}
+		}
+		return true
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		if len(bwe.WriteErrors) == 0 || bwe.WriteConcernError != nil {
+			return false
+		}
+		for _, e := range bwe.WriteErrors {
+			if e.Code != duplicateKeyCode {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
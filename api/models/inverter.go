@@ -2,14 +2,13 @@ package models
 
 import (
 	"context"
-	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/gocolly/colly"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var inverterCollection = "inverters"
@@ -19,52 +18,53 @@ type Inverter struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Serial          string             `bson:"serial" json:"serial"`
 	Power           float64            `bson:"power" json:"power"`
-	Voltage         float64            `bson:"voltage" json:"voltae"`
-	Frequency       float64            `bson:"frequency" json:"frequnc"`
-	Communication   bool               `bson:"communication" json:"cmmuniation"`
+	Voltage         float64            `bson:"voltage" json:"voltage"`
+	Frequency       float64            `bson:"frequency" json:"frequency"`
+	Communication   bool               `bson:"communication" json:"communication"`
 	Status          bool               `bson:"status" json:"status"`
 	Switch          bool               `bson:"switch" json:"switch"`
 	EnergyToday     float64            `bson:"energyToday" json:"energyToday"`
 	EnergyThisMonth float64            `bson:"energyThisMonth" json:"energyThisMonth"`
 	EnergyThisYear  float64            `bson:"energyThisYear" json:"energyThisYear"`
 	TotalEnergy     float64            `bson:"totalEnergy" json:"totalEnergy"`
+	Flags           []string           `bson:"flags" json:"flags"`
 }
 
 // AlreadyInDB : checks if a given inverter data is already in the DB
-func (i *Inverter) AlreadyInDB(db *mongo.Database) bool {
-	ctx := context.Background()
+func (i *Inverter) AlreadyInDB(ctx context.Context, db *mongo.Database) (bool, error) {
 	filter := bson.M{
 		"serial": i.Serial,
 	}
-	res := db.Collection(inverterCollection).FindOne(ctx, filter)
-	return !(res.Err() == mongo.ErrNoDocuments)
+	n, err := db.Collection(inverterCollection).CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, dbError(err, "inverter %v", i.Serial)
+	}
+	return n > 0, nil
 }
 
 // AddInverterToDB : adds info about a inverter to the DB
-func (i *Inverter) AddInverterToDB(db *mongo.Database) (primitive.ObjectID, error) {
-	ctx := context.Background()
+func (i *Inverter) AddInverterToDB(ctx context.Context, db *mongo.Database) (primitive.ObjectID, error) {
 	res, err := db.Collection(inverterCollection).InsertOne(ctx, i)
 	if err != nil {
-		return primitive.NilObjectID, err
+		return primitive.NilObjectID, dbError(err, "inverter %v", i.Serial)
 	}
 	oid, _ := res.InsertedID.(primitive.ObjectID)
 	return oid, nil
 }
 
 // ListInverters : reads all the current inverters in the DB
-func ListInverters(db *mongo.Database) ([]*Inverter, error) {
-	ctx := context.Background()
+func ListInverters(ctx context.Context, db *mongo.Database) ([]*Inverter, error) {
 	filter := bson.M{}
 	cur, err := db.Collection(inverterCollection).Find(ctx, filter)
 	if err != nil {
-		return []*Inverter{}, err
+		return []*Inverter{}, dbError(err, "inverters")
 	}
 	defer cur.Close(ctx)
 	inverters := []*Inverter{}
 	for cur.Next(ctx) {
 		var i Inverter
 		if err := cur.Decode(&i); err != nil {
-			return inverters, err
+			return inverters, dbError(err, "inverters")
 		}
 		inverters = append(inverters, &i)
 	}
@@ -72,42 +72,62 @@ func ListInverters(db *mongo.Database) ([]*Inverter, error) {
 }
 
 // ReadInverter : reads data from a specific inverter serial
-func (i *Inverter) ReadInverter(db *mongo.Database) error {
-	ctx := context.Background()
+func (i *Inverter) ReadInverter(ctx context.Context, db *mongo.Database) error {
 	filter := bson.M{
 		"serial": i.Serial,
 	}
 	res := db.Collection(inverterCollection).FindOne(ctx, filter)
 	if res.Err() != nil {
-		return res.Err()
+		return dbError(res.Err(), "inverter %v", i.Serial)
 	}
 	res.Decode(&i)
 	return nil
 }
 
 // UpdateInverterInDB : updates information of an inverter in the DB
-func (i *Inverter) UpdateInverterInDB(db *mongo.Database) error {
-	ctx := context.Background()
+func (i *Inverter) UpdateInverterInDB(ctx context.Context, db *mongo.Database) error {
 	filter := bson.M{"serial": i.Serial}
 	update := bson.M{"$set": i}
 	res, err := db.Collection(inverterCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return dbError(err, "inverter %v", i.Serial)
+	}
 	if res.MatchedCount == 0 {
-		return fmt.Errorf("Inverter not found")
+		return NotFound("inverter %v", i.Serial)
+	}
+	return nil
+}
+
+// UpsertInverterInDB : adds an inverter to the DB or updates it, in a single operation
+func (i *Inverter) UpsertInverterInDB(ctx context.Context, db *mongo.Database) (UpsertResult, error) {
+	filter := bson.M{"serial": i.Serial}
+	update := bson.M{"$set": i}
+	opts := options.Update().SetUpsert(true)
+	res, err := db.Collection(inverterCollection).UpdateOne(ctx, filter, update, opts)
+	// Concurrent upserts of a new serial may both try to insert, and the one rejected
+	// by the unique index succeeds as an update when retried
+	if IsDuplicateKeyError(err) {
+		res, err = db.Collection(inverterCollection).UpdateOne(ctx, filter, update, opts)
+	}
+	if err != nil {
+		return Unchanged, dbError(err, "inverter %v", i.Serial)
 	}
-	return err
+	return upsertResultFrom(res), nil
 }
 
 // DeleteInverterFromDB : deletes an inverter from the DB
-func (i *Inverter) DeleteInverterFromDB(db *mongo.Database) error {
-	ctx := context.Background()
+func (i *Inverter) DeleteInverterFromDB(ctx context.Context, db *mongo.Database) error {
 	filter := bson.M{
 		"serial": i.Serial,
 	}
 	res, err := db.Collection(inverterCollection).DeleteOne(ctx, filter)
+	if err != nil {
+		return dbError(err, "inverter %v", i.Serial)
+	}
 	if res.DeletedCount == 0 {
-		return fmt.Errorf("Inverter not found")
+		return NotFound("inverter %v", i.Serial)
 	}
-	return err
+	return nil
 }
 
 // FromScrapper : fills the inverter with data from the HTML scrapper
@@ -133,6 +153,8 @@ func (i *Inverter) FromScrapper(e *colly.HTMLElement) error {
 	const OTHER5 = "container-vertical-padding-single"
 	const OTHER6 = "grey-strong-class"
 	const OTHER7 = "font-16 grey-primary-font bold"
+	// The problems found in the page
+	problems := []string{}
 	// Looks in all divs with classes
 	e.ForEach("div[class]", func(_ int, el *colly.HTMLElement) {
 		// Looks for the inverter serial
@@ -159,32 +181,17 @@ func (i *Inverter) FromScrapper(e *colly.HTMLElement) error {
 							case "Potência":
 								if !foundPower {
 									foundPower = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if pow, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.Power = pow
-										}
-									}
+									i.Power = scrapedValue(divData, elem.Text, &problems)
 								}
 							case "Tensão":
 								if !foundVoltage {
 									foundVoltage = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if vol, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.Voltage = vol
-										}
-									}
+									i.Voltage = scrapedValue(divData, elem.Text, &problems)
 								}
 							case "Frequência":
 								if !foundFreq {
 									foundFreq = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if f, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.Frequency = f
-										}
-									}
+									i.Frequency = scrapedValue(divData, elem.Text, &problems)
 								}
 							case "Comunic. c/ Servidor.":
 								if !foundComm {
@@ -210,42 +217,22 @@ func (i *Inverter) FromScrapper(e *colly.HTMLElement) error {
 							case "Hoje":
 								if !foundEnergyToday {
 									foundEnergyToday = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if en, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.EnergyToday = en
-										}
-									}
+									i.EnergyToday = scrapedValue(divData, elem.Text, &problems)
 								}
 							case "Este Mês":
 								if !foundEnergyMonth {
 									foundEnergyMonth = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if en, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.EnergyThisMonth = en
-										}
-									}
+									i.EnergyThisMonth = scrapedValue(divData, elem.Text, &problems)
 								}
 							case "Este Ano":
 								if !foundEnergyYear {
 									foundEnergyYear = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if en, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.EnergyThisYear = en
-										}
-									}
+									i.EnergyThisYear = scrapedValue(divData, elem.Text, &problems)
 								}
 							case "Total":
 								if !foundTotalEnergy {
 									foundTotalEnergy = true
-									s := strings.Split(elem.Text, " ")
-									if len(s) == 2 {
-										if en, err := strconv.ParseFloat(s[0], 64); err == nil {
-											i.TotalEnergy = en
-										}
-									}
+									i.TotalEnergy = scrapedValue(divData, elem.Text, &problems)
 								}
 							default:
 							}
@@ -255,5 +242,20 @@ func (i *Inverter) FromScrapper(e *colly.HTMLElement) error {
 			})
 		}
 	})
-	return nil
+	if i.Serial == "" {
+		problems = append(problems, "missing serial")
+	}
+	missingValues(map[string]bool{
+		"Potência":              foundPower,
+		"Tensão":                foundVoltage,
+		"Frequência":            foundFreq,
+		"Comunic. c/ Servidor.": foundComm,
+		"Status":                foundStatus,
+		"Chave está":            foundSwitch,
+		"Hoje":                  foundEnergyToday,
+		"Este Mês":              foundEnergyMonth,
+		"Este Ano":              foundEnergyYear,
+		"Total":                 foundTotalEnergy,
+	}, &problems)
+	return scrapeError(problems)
 }
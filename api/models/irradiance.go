@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var irradianceCollection = "irradiance"
+
+// stcIrradiance : the irradiance of the standard test conditions, in W/m²
+const stcIrradiance = 1000.0
+
+// maxInsolationGap : intervals between reads longer than this (s) are not integrated
+const maxInsolationGap = 3600
+
+// Irradiance : the daily insolation on the modules of a site, in kWh/m². An empty site
+// applies to all the sites.
+type Irradiance struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Site       string             `bson:"site" json:"site"`
+	Day        time.Time          `bson:"day" json:"day"`
+	Insolation float64            `bson:"insolation" json:"insolation"`
+}
+
+// ReadIrradiance : reads the insolation of a site and day, falling back to the one of all sites
+func (ir *Irradiance) ReadIrradiance(ctx context.Context, db *mongo.Database) error {
+	ir.Day = StartOfDay(ir.Day)
+	for _, site := range []string{ir.Site, ""} {
+		filter := bson.M{
+			"site": site,
+			"day":  ir.Day,
+		}
+		res := db.Collection(irradianceCollection).FindOne(ctx, filter)
+		if res.Err() == mongo.ErrNoDocuments {
+			continue
+		}
+		if res.Err() != nil {
+			return dbError(res.Err(), "insolation of %v", ir.Site)
+		}
+		return res.Decode(ir)
+	}
+	return NotFound("insolation of %v on %v", ir.Site, ir.Day.Format("2006-01-02"))
+}
+
+// UpsertIrradianceInDB : adds or updates the insolation of a site and day
+func (ir *Irradiance) UpsertIrradianceInDB(ctx context.Context, db *mongo.Database) (UpsertResult, error) {
+	ir.Day = StartOfDay(ir.Day)
+	filter := bson.M{
+		"site": ir.Site,
+		"day":  ir.Day,
+	}
+	update := bson.M{"$set": bson.M{"insolation": ir.Insolation}}
+	opts := options.Update().SetUpsert(true)
+	res, err := db.Collection(irradianceCollection).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return Unchanged, dbError(err, "insolation of %v", ir.Site)
+	}
+	return upsertResultFrom(res), nil
+}
+
+// ImportIrradianceCSV : adds or updates the insolations of a CSV file, whose lines are
+// "date,site,insolation" with dates as 2006-01-02 and insolations in kWh/m². An empty site
+// applies to all the sites, and lines that are not data, as headers, are skipped.
+func ImportIrradianceCSV(ctx context.Context, db *mongo.Database, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("%w insolation file: %v", ErrInvalid, err)
+	}
+	n := 0
+	for i, rec := range records {
+		day, err := time.Parse("2006-01-02", strings.TrimSpace(rec[0]))
+		if err != nil {
+			continue
+		}
+		ins, err := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+		if err != nil {
+			return n, fmt.Errorf("%w insolation in line %v: %v", ErrInvalid, i+1, err)
+		}
+		ir := Irradiance{
+			Site:       strings.TrimSpace(rec[1]),
+			Day:        day,
+			Insolation: ins,
+		}
+		if _, err := ir.UpsertIrradianceInDB(ctx, db); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ModuleInsolation : estimates the insolation of a day (kWh/m²) from the input current of a
+// reference module, taking its current in the standard test conditions (A) as 1000 W/m²
+func ModuleInsolation(ctx context.Context, db *mongo.Database, module string, day time.Time, stcCurrent float64) (float64, error) {
+	if stcCurrent <= 0 {
+		return 0, fmt.Errorf("%w reference current: %v", ErrInvalid, stcCurrent)
+	}
+	start := StartOfDay(day)
+	end := start.AddDate(0, 0, 1)
+	filter := bson.M{
+		"module":            module,
+		"lastTelemetryTime": bson.M{"$gte": start.Unix(), "$lt": end.Unix()},
+	}
+	opts := options.Find().SetSort(bson.M{"lastTelemetryTime": 1})
+	cur, err := db.Collection(telemetryDataCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return 0, dbError(err, "telemetry data of %v", module)
+	}
+	defer cur.Close(ctx)
+	// Integrates the irradiance with the trapezoidal rule, in Wh/m²
+	energy := 0.0
+	var prev *TelemetryData
+	for cur.Next(ctx) {
+		var t TelemetryData
+		if err := cur.Decode(&t); err != nil {
+			return 0, dbError(err, "telemetry data of %v", module)
+		}
+		if prev != nil {
+			dt := t.LastTelemetryTime - prev.LastTelemetryTime
+			if dt > 0 && dt <= maxInsolationGap {
+				g0 := stcIrradiance * prev.InputCurrent / stcCurrent
+				g1 := stcIrradiance * t.InputCurrent / stcCurrent
+				energy += (g0 + g1) / 2 * float64(dt) / 3600
+			}
+		}
+		prev = &t
+	}
+	if err := cur.Err(); err != nil {
+		return 0, dbError(err, "telemetry data of %v", module)
+	}
+	return energy / 1000, nil
+}
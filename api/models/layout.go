@@ -0,0 +1,45 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Layout : the sites and strings of the PV plant, as described in a layout file
+type Layout struct {
+	Sites   []Site   `json:"sites"`
+	Strings []String `json:"strings"`
+}
+
+// ReadLayoutFile : reads a layout from a JSON file
+func ReadLayoutFile(path string) (*Layout, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := Layout{}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%w layout file: %v", ErrInvalid, err)
+	}
+	return &l, nil
+}
+
+// UpsertLayoutInDB : adds or updates every site and string of the layout in the DB.
+// Sites and strings that are not in the layout are kept.
+func (l *Layout) UpsertLayoutInDB(ctx context.Context, db *mongo.Database) error {
+	for i := range l.Sites {
+		if _, err := l.Sites[i].UpsertSiteInDB(ctx, db); err != nil {
+			return err
+		}
+	}
+	for i := range l.Strings {
+		if _, err := l.Strings[i].UpsertStringInDB(ctx, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
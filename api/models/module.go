@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var moduleCollection = "modules"
+
+// The possible health states of a module
+const (
+	ModuleHealthy   = "ok"
+	ModuleStale     = "stale"
+	ModuleDeviating = "deviating"
+)
+
+// minPeerCurrent : below this median current (A) the peers are not compared, as in the night
+const minPeerCurrent = 0.5
+
+// Module : an optimizer connected to an inverter, registered from its telemetry data
+type Module struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Module            string             `bson:"module" json:"module"`
+	Serial            string             `bson:"serial" json:"serial"`
+	FirstSeen         int64              `bson:"firstSeen" json:"firstSeen"`
+	LastSeen          int64              `bson:"lastSeen" json:"lastSeen"`
+	LastOutputVoltage float64            `bson:"lastOutputVoltage" json:"lastOutputVoltage"`
+	LastInputVoltage  float64            `bson:"lastInputVoltage" json:"lastInputVoltage"`
+	LastInputCurrent  float64            `bson:"lastInputCurrent" json:"lastInputCurrent"`
+	Health            string             `bson:"health" json:"health"`
+	CurrentDeviation  float64            `bson:"currentDeviation" json:"currentDeviation"`
+	HealthCheckedAt   int64              `bson:"healthCheckedAt" json:"healthCheckedAt"`
+}
+
+// ListModules : reads the registered modules from DB using an filter
+func ListModules(ctx context.Context, db *mongo.Database, filter bson.M) ([]*Module, error) {
+	cur, err := db.Collection(moduleCollection).Find(ctx, filter)
+	if err != nil {
+		return []*Module{}, dbError(err, "modules")
+	}
+	defer cur.Close(ctx)
+	modules := []*Module{}
+	for cur.Next(ctx) {
+		var m Module
+		if err := cur.Decode(&m); err != nil {
+			return modules, dbError(err, "modules")
+		}
+		modules = append(modules, &m)
+	}
+	return modules, nil
+}
+
+// ListModulesOfInverter : reads the modules registered for an inverter serial
+func ListModulesOfInverter(ctx context.Context, db *mongo.Database, serial string) ([]*Module, error) {
+	return ListModules(ctx, db, bson.M{"serial": serial})
+}
+
+// ListModuleSerials : reads the serials of the inverters with registered modules
+func ListModuleSerials(ctx context.Context, db *mongo.Database) ([]string, error) {
+	values, err := db.Collection(moduleCollection).Distinct(ctx, "serial", bson.M{})
+	if err != nil {
+		return []string{}, dbError(err, "modules")
+	}
+	serials := []string{}
+	for _, v := range values {
+		if serial, ok := v.(string); ok {
+			serials = append(serials, serial)
+		}
+	}
+	return serials, nil
+}
+
+// UpdateModuleRegistry : registers the modules of the telemetry reads, keeping when each
+// one was first and last seen and its newest readings
+func UpdateModuleRegistry(ctx context.Context, db *mongo.Database, data []*TelemetryData) error {
+	writes := []mongo.WriteModel{}
+	for _, t := range data {
+		if t.Module == "" {
+			continue
+		}
+		filter := bson.M{
+			"module": t.Module,
+			"serial": t.Serial,
+		}
+		seen := bson.M{
+			"$min":         bson.M{"firstSeen": t.LastTelemetryTime},
+			"$max":         bson.M{"lastSeen": t.LastTelemetryTime},
+			"$setOnInsert": bson.M{"health": ModuleHealthy},
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(seen).
+			SetUpsert(true))
+		// Only the newest read matches the last seen time
+		newest := bson.M{
+			"module":   t.Module,
+			"serial":   t.Serial,
+			"lastSeen": t.LastTelemetryTime,
+		}
+		readings := bson.M{"$set": bson.M{
+			"lastOutputVoltage": t.OutputVoltage,
+			"lastInputVoltage":  t.InputVoltage,
+			"lastInputCurrent":  t.InputCurrent,
+		}}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(newest).
+			SetUpdate(readings))
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	opts := options.BulkWrite().SetOrdered(true)
+	_, err := db.Collection(moduleCollection).BulkWrite(ctx, writes, opts)
+	return dbError(err, "modules")
+}
+
+// EvaluateModuleHealth : flags the modules of an inverter that stopped reporting for longer
+// than staleAfter seconds, or whose input current deviates from the median of the reporting
+// peers by more than maxDeviation (a fraction of the median)
+func EvaluateModuleHealth(ctx context.Context, db *mongo.Database, serial string, now, staleAfter int64, maxDeviation float64) ([]*Module, error) {
+	modules, err := ListModulesOfInverter(ctx, db, serial)
+	if err != nil {
+		return modules, err
+	}
+	// The peers are the modules still reporting
+	currents := []float64{}
+	for _, m := range modules {
+		if now-m.LastSeen <= staleAfter {
+			currents = append(currents, m.LastInputCurrent)
+		}
+	}
+	med := Median(currents)
+	for _, m := range modules {
+		m.Health = ModuleHealthy
+		m.CurrentDeviation = 0
+		if now-m.LastSeen > staleAfter {
+			m.Health = ModuleStale
+		} else if med >= minPeerCurrent {
+			m.CurrentDeviation = (m.LastInputCurrent - med) / med
+			if math.Abs(m.CurrentDeviation) > maxDeviation {
+				m.Health = ModuleDeviating
+			}
+		}
+		m.HealthCheckedAt = now
+		update := bson.M{"$set": bson.M{
+			"health":           m.Health,
+			"currentDeviation": m.CurrentDeviation,
+			"healthCheckedAt":  m.HealthCheckedAt,
+		}}
+		if _, err := db.Collection(moduleCollection).UpdateOne(ctx, bson.M{"_id": m.ID}, update); err != nil {
+			return modules, dbError(err, "module %v %v", m.Serial, m.Module)
+		}
+	}
+	return modules, nil
+}
+
+// Median : the median of a set of values, which is zero for an empty set
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scrapedValue : reads the number of a value shown with its unit, as "31.81 kW", keeping the
+// problem found if it is not a number
+func scrapedValue(label, text string, problems *[]string) float64 {
+	s := strings.Split(text, " ")
+	if len(s) == 2 {
+		if v, err := strconv.ParseFloat(s[0], 64); err == nil {
+			return v
+		}
+	}
+	*problems = append(*problems, fmt.Sprintf("invalid %v: %q", label, text))
+	return 0
+}
+
+// missingValues : keeps the problem of the values not found in a page
+func missingValues(found map[string]bool, problems *[]string) {
+	missing := []string{}
+	for label, ok := range found {
+		if !ok {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		*problems = append(*problems, fmt.Sprintf("missing %v", strings.Join(missing, ", ")))
+	}
+}
+
+// scrapeError : the error of the problems found while parsing a page, if any
+func scrapeError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w page: %v", ErrInvalid, strings.Join(problems, "; "))
+}
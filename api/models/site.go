@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var siteCollection = "sites"
+
+// Location : where a site is installed
+type Location struct {
+	Latitude    float64 `bson:"latitude" json:"latitude"`
+	Longitude   float64 `bson:"longitude" json:"longitude"`
+	Description string  `bson:"description" json:"description"`
+}
+
+// Site : a PV array, which groups inverters
+type Site struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Location  Location           `bson:"location" json:"location"`
+	PeakPower float64            `bson:"peakPower" json:"peakPower"`
+	Tilt      float64            `bson:"tilt" json:"tilt"`
+	Azimuth   float64            `bson:"azimuth" json:"azimuth"`
+	Inverters []string           `bson:"inverters" json:"inverters"`
+}
+
+// ListSites : reads all the current sites in the DB
+func ListSites(ctx context.Context, db *mongo.Database) ([]*Site, error) {
+	cur, err := db.Collection(siteCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return []*Site{}, dbError(err, "sites")
+	}
+	defer cur.Close(ctx)
+	sites := []*Site{}
+	for cur.Next(ctx) {
+		var st Site
+		if err := cur.Decode(&st); err != nil {
+			return sites, dbError(err, "sites")
+		}
+		sites = append(sites, &st)
+	}
+	return sites, nil
+}
+
+// ReadSite : reads data from a specific site name
+func (st *Site) ReadSite(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{
+		"name": st.Name,
+	}
+	res := db.Collection(siteCollection).FindOne(ctx, filter)
+	if res.Err() != nil {
+		return dbError(res.Err(), "site %v", st.Name)
+	}
+	return res.Decode(st)
+}
+
+// UpsertSiteInDB : adds a site to the DB or updates it, in a single operation
+func (st *Site) UpsertSiteInDB(ctx context.Context, db *mongo.Database) (UpsertResult, error) {
+	if st.Inverters == nil {
+		st.Inverters = []string{}
+	}
+	filter := bson.M{"name": st.Name}
+	update := bson.M{"$set": st}
+	opts := options.Update().SetUpsert(true)
+	res, err := db.Collection(siteCollection).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return Unchanged, dbError(err, "site %v", st.Name)
+	}
+	return upsertResultFrom(res), nil
+}
+
+// DeleteSiteFromDB : deletes a site from the DB
+func (st *Site) DeleteSiteFromDB(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{
+		"name": st.Name,
+	}
+	res, err := db.Collection(siteCollection).DeleteOne(ctx, filter)
+	if err != nil {
+		return dbError(err, "site %v", st.Name)
+	}
+	if res.DeletedCount == 0 {
+		return NotFound("site %v", st.Name)
+	}
+	return nil
+}
+
+// TelemetryFilter : the filter that selects the telemetry data of the site inverters
+func (st *Site) TelemetryFilter() bson.M {
+	return bson.M{
+		"serial": bson.M{"$in": st.Inverters},
+	}
+}
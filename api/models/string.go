@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var stringCollection = "strings"
+
+// String : a series of modules connected to an inverter input, in a site
+type String struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Site      string             `bson:"site" json:"site"`
+	Serial    string             `bson:"serial" json:"serial"`
+	PeakPower float64            `bson:"peakPower" json:"peakPower"`
+	Tilt      float64            `bson:"tilt" json:"tilt"`
+	Azimuth   float64            `bson:"azimuth" json:"azimuth"`
+	Modules   []string           `bson:"modules" json:"modules"`
+}
+
+// ListStrings : reads the strings from DB using an filter
+func ListStrings(ctx context.Context, db *mongo.Database, filter bson.M) ([]*String, error) {
+	cur, err := db.Collection(stringCollection).Find(ctx, filter)
+	if err != nil {
+		return []*String{}, dbError(err, "strings")
+	}
+	defer cur.Close(ctx)
+	strs := []*String{}
+	for cur.Next(ctx) {
+		var str String
+		if err := cur.Decode(&str); err != nil {
+			return strs, dbError(err, "strings")
+		}
+		strs = append(strs, &str)
+	}
+	return strs, nil
+}
+
+// ListStringsOfSite : reads the strings of a site
+func ListStringsOfSite(ctx context.Context, db *mongo.Database, site string) ([]*String, error) {
+	return ListStrings(ctx, db, bson.M{"site": site})
+}
+
+// filter : the filter of a string by name and, if given, by site, as the names are only
+// unique in a site
+func (str *String) filter() bson.M {
+	filter := bson.M{"name": str.Name}
+	if str.Site != "" {
+		filter["site"] = str.Site
+	}
+	return filter
+}
+
+// ReadString : reads data from a specific string name
+func (str *String) ReadString(ctx context.Context, db *mongo.Database) error {
+	res := db.Collection(stringCollection).FindOne(ctx, str.filter())
+	if res.Err() != nil {
+		return dbError(res.Err(), "string %v", str.Name)
+	}
+	return res.Decode(str)
+}
+
+// UpsertStringInDB : adds a string to the DB or updates it, in a single operation
+func (str *String) UpsertStringInDB(ctx context.Context, db *mongo.Database) (UpsertResult, error) {
+	if str.Modules == nil {
+		str.Modules = []string{}
+	}
+	filter := bson.M{"site": str.Site, "name": str.Name}
+	update := bson.M{"$set": str}
+	opts := options.Update().SetUpsert(true)
+	res, err := db.Collection(stringCollection).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return Unchanged, dbError(err, "string %v", str.Name)
+	}
+	return upsertResultFrom(res), nil
+}
+
+// DeleteStringFromDB : deletes a string from the DB
+func (str *String) DeleteStringFromDB(ctx context.Context, db *mongo.Database) error {
+	res, err := db.Collection(stringCollection).DeleteOne(ctx, str.filter())
+	if err != nil {
+		return dbError(err, "string %v", str.Name)
+	}
+	if res.DeletedCount == 0 {
+		return NotFound("string %v", str.Name)
+	}
+	return nil
+}
+
+// TelemetryFilter : the filter that selects the telemetry data of the string modules
+func (str *String) TelemetryFilter() bson.M {
+	return bson.M{
+		"serial": str.Serial,
+		"module": bson.M{"$in": str.Modules},
+	}
+}
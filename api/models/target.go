@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var targetCollection = "targets"
+
+var targetChangeCollection = "targetChanges"
+
+var deletedTargetCollection = "deletedTargets"
+
+// DiscoveryKind : the kind of the pages whose links are followed for finding telemetry pages
+const DiscoveryKind = "discovery"
+
+// Target : a page of a device that is scraped periodically, with the labels that place it
+// in the plant
+type Target struct {
+	// Name : identifies the target, which is made of the host and path of its URL when not given
+	Name string `json:"name" bson:"name"`
+	URL  string `json:"url" bson:"url"`
+	Kind string `json:"kind" bson:"kind"`
+	// Period : the seconds between the visits, where zero takes the period of the kind
+	Period int64             `json:"period,omitempty" bson:"period,omitempty"`
+	Labels map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`
+	// Paused : if the target is kept but not visited
+	Paused bool `json:"paused" bson:"paused"`
+}
+
+// Check : validates the URL and the kind of the target, naming it after its URL if needed
+func (t *Target) Check() error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w target URL: %v", ErrInvalid, t.URL)
+	}
+	switch t.Kind {
+	case InverterKind, TelemetryDataKind, DiscoveryKind:
+	default:
+		return fmt.Errorf("%w target kind: %v", ErrInvalid, t.Kind)
+	}
+	if t.Period < 0 {
+		return fmt.Errorf("%w target period: %v", ErrInvalid, t.Period)
+	}
+	if t.Name == "" {
+		t.Name = TargetName(t.URL)
+	}
+	return nil
+}
+
+// notNameChars : the characters of an URL that are not kept in the target names
+var notNameChars = regexp.MustCompile(`[^A-Za-z0-9._]+`)
+
+// TargetName : the default name of the target of an URL, as "172.16.0.1-50050-inverter"
+func TargetName(targetURL string) string {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return targetURL
+	}
+	return strings.Trim(notNameChars.ReplaceAllString(u.Host+u.Path, "-"), "-")
+}
+
+// DeviceURL : the root URL of the device of the target, as "http://172.16.0.1:50050/"
+func (t *Target) DeviceURL() string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/"
+}
+
+// ListTargets : reads all the targets in the DB, sorted by name
+func ListTargets(ctx context.Context, db *mongo.Database) ([]*Target, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.Collection(targetCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return []*Target{}, dbError(err, "targets")
+	}
+	defer cur.Close(ctx)
+	targets := []*Target{}
+	for cur.Next(ctx) {
+		var t Target
+		if err := cur.Decode(&t); err != nil {
+			return targets, dbError(err, "targets")
+		}
+		targets = append(targets, &t)
+	}
+	return targets, nil
+}
+
+// ReadTarget : reads data from a specific target name
+func (t *Target) ReadTarget(ctx context.Context, db *mongo.Database) error {
+	res := db.Collection(targetCollection).FindOne(ctx, bson.M{"name": t.Name})
+	if res.Err() != nil {
+		return dbError(res.Err(), "target %v", t.Name)
+	}
+	return res.Decode(t)
+}
+
+// AddTargetToDB : adds a target to the DB, failing with ErrDuplicate if its name is taken
+func (t *Target) AddTargetToDB(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(targetCollection).InsertOne(ctx, t)
+	return dbError(err, "target %v", t.Name)
+}
+
+// PauseTargetInDB : pauses or resumes a target in the DB
+func (t *Target) PauseTargetInDB(ctx context.Context, db *mongo.Database, paused bool) error {
+	update := bson.M{"$set": bson.M{"paused": paused}}
+	res, err := db.Collection(targetCollection).UpdateOne(ctx, bson.M{"name": t.Name}, update)
+	if err != nil {
+		return dbError(err, "target %v", t.Name)
+	}
+	if res.MatchedCount == 0 {
+		return NotFound("target %v", t.Name)
+	}
+	t.Paused = paused
+	return nil
+}
+
+// DeleteTargetFromDB : deletes a target from the DB
+func (t *Target) DeleteTargetFromDB(ctx context.Context, db *mongo.Database) error {
+	res, err := db.Collection(targetCollection).DeleteOne(ctx, bson.M{"name": t.Name})
+	if err != nil {
+		return dbError(err, "target %v", t.Name)
+	}
+	if res.DeletedCount == 0 {
+		return NotFound("target %v", t.Name)
+	}
+	return nil
+}
+
+// MarkTargetDeleted : records that a target was deleted, so the configured target of the same
+// name does not seed the DB again
+func MarkTargetDeleted(ctx context.Context, db *mongo.Database, name string) error {
+	filter := bson.M{"name": name}
+	update := bson.M{"$set": bson.M{"name": name, "time": time.Now().UTC()}}
+	opts := options.Update().SetUpsert(true)
+	if _, err := db.Collection(deletedTargetCollection).UpdateOne(ctx, filter, update, opts); err != nil {
+		return dbError(err, "deletion of target %v", name)
+	}
+	return nil
+}
+
+// ListDeletedTargets : reads the names of the targets that were deleted
+func ListDeletedTargets(ctx context.Context, db *mongo.Database) (map[string]bool, error) {
+	names, err := db.Collection(deletedTargetCollection).Distinct(ctx, "name", bson.M{})
+	if err != nil {
+		return map[string]bool{}, dbError(err, "deleted targets")
+	}
+	deleted := map[string]bool{}
+	for _, n := range names {
+		if name, ok := n.(string); ok {
+			deleted[name] = true
+		}
+	}
+	return deleted, nil
+}
+
+// The changes made to the targets
+const (
+	TargetAdded   = "add"
+	TargetPaused  = "pause"
+	TargetResumed = "resume"
+	TargetDeleted = "delete"
+)
+
+// TargetChange : the audit of a change made to a target, by whom, from which address and when
+type TargetChange struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Time   time.Time          `bson:"time" json:"time"`
+	Actor  string             `bson:"actor" json:"actor"`
+	Client string             `bson:"client" json:"client"`
+	Action string             `bson:"action" json:"action"`
+	Name   string             `bson:"name" json:"name"`
+	// Target : the target as it was left by the change, or as it was before being deleted
+	Target Target `bson:"target" json:"target"`
+}
+
+// AddTargetChangeToDB : records a change made to a target
+func (c *TargetChange) AddTargetChangeToDB(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(targetChangeCollection).InsertOne(ctx, c)
+	return dbError(err, "change of target %v", c.Name)
+}
+
+// ListTargetChanges : reads the changes made to the targets using a filter, the newest first
+func ListTargetChanges(ctx context.Context, db *mongo.Database, filter bson.M) ([]*TargetChange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := db.Collection(targetChangeCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*TargetChange{}, dbError(err, "target changes")
+	}
+	defer cur.Close(ctx)
+	changes := []*TargetChange{}
+	for cur.Next(ctx) {
+		var c TargetChange
+		if err := cur.Decode(&c); err != nil {
+			return changes, dbError(err, "target changes")
+		}
+		changes = append(changes, &c)
+	}
+	return changes, nil
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var telemetryDailyDataCollection = "telemetryDailyData"
+
+// TelemetryDailyData : aggregated telemetry data of a module in a single day
+type TelemetryDailyData struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Serial             string             `bson:"serial" json:"serial"`
+	Module             string             `bson:"module" json:"module"`
+	Day                time.Time          `bson:"day" json:"day"`
+	Samples            int64              `bson:"samples" json:"samples"`
+	FirstTelemetryTime int64              `bson:"firstTelemetryTime" json:"firstTelemetryTime"`
+	LastTelemetryTime  int64              `bson:"lastTelemetryTime" json:"lastTelemetryTime"`
+	AvgOutputVoltage   float64            `bson:"avgOutputVoltage" json:"avgOutputVoltage"`
+	AvgInputVoltage    float64            `bson:"avgInputVoltage" json:"avgInputVoltage"`
+	AvgInputCurrent    float64            `bson:"avgInputCurrent" json:"avgInputCurrent"`
+	MaxInputCurrent    float64            `bson:"maxInputCurrent" json:"maxInputCurrent"`
+}
+
+// StartOfDay : returns the midnight that starts the day of a given time, in UTC.
+// The telemetry times are parsed from the device clock without a location, so the
+// days of the rollups are also the days of the device clock.
+func StartOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ListTelemetryDailyData : reads daily telemetry data from DB using an filter
+func ListTelemetryDailyData(ctx context.Context, db *mongo.Database, filter bson.M) ([]*TelemetryDailyData, error) {
+	cur, err := db.Collection(telemetryDailyDataCollection).Find(ctx, filter)
+	if err != nil {
+		return []*TelemetryDailyData{}, dbError(err, "telemetry daily data")
+	}
+	defer cur.Close(ctx)
+	daily := []*TelemetryDailyData{}
+	for cur.Next(ctx) {
+		var d TelemetryDailyData
+		if err := cur.Decode(&d); err != nil {
+			return daily, dbError(err, "telemetry daily data")
+		}
+		daily = append(daily, &d)
+	}
+	return daily, nil
+}
+
+// UpdateTelemetryDailyData : aggregates the telemetry data of a day into the daily rollups
+func UpdateTelemetryDailyData(ctx context.Context, db *mongo.Database, day time.Time) error {
+	start := StartOfDay(day)
+	end := start.AddDate(0, 0, 1)
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"lastTelemetryTime": bson.M{"$gte": start.Unix(), "$lt": end.Unix()},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":                bson.M{"serial": "$serial", "module": "$module"},
+			"samples":            bson.M{"$sum": 1},
+			"firstTelemetryTime": bson.M{"$min": "$lastTelemetryTime"},
+			"lastTelemetryTime":  bson.M{"$max": "$lastTelemetryTime"},
+			"avgOutputVoltage":   bson.M{"$avg": "$outputVoltage"},
+			"avgInputVoltage":    bson.M{"$avg": "$inputVoltage"},
+			"avgInputCurrent":    bson.M{"$avg": "$inputCurrent"},
+			"maxInputCurrent":    bson.M{"$max": "$inputCurrent"},
+		}},
+	}
+	cur, err := db.Collection(telemetryDataCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return dbError(err, "telemetry data on %v", day.Format("2006-01-02"))
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var g struct {
+			ID struct {
+				Serial string `bson:"serial"`
+				Module string `bson:"module"`
+			} `bson:"_id"`
+			Samples            int64   `bson:"samples"`
+			FirstTelemetryTime int64   `bson:"firstTelemetryTime"`
+			LastTelemetryTime  int64   `bson:"lastTelemetryTime"`
+			AvgOutputVoltage   float64 `bson:"avgOutputVoltage"`
+			AvgInputVoltage    float64 `bson:"avgInputVoltage"`
+			AvgInputCurrent    float64 `bson:"avgInputCurrent"`
+			MaxInputCurrent    float64 `bson:"maxInputCurrent"`
+		}
+		if err := cur.Decode(&g); err != nil {
+			return dbError(err, "telemetry data on %v", day.Format("2006-01-02"))
+		}
+		d := TelemetryDailyData{
+			Serial:             g.ID.Serial,
+			Module:             g.ID.Module,
+			Day:                start,
+			Samples:            g.Samples,
+			FirstTelemetryTime: g.FirstTelemetryTime,
+			LastTelemetryTime:  g.LastTelemetryTime,
+			AvgOutputVoltage:   g.AvgOutputVoltage,
+			AvgInputVoltage:    g.AvgInputVoltage,
+			AvgInputCurrent:    g.AvgInputCurrent,
+			MaxInputCurrent:    g.MaxInputCurrent,
+		}
+		filter := bson.M{
+			"serial": d.Serial,
+			"module": d.Module,
+			"day":    d.Day,
+		}
+		update := bson.M{"$set": d}
+		opts := options.Update().SetUpsert(true)
+		if _, err := db.Collection(telemetryDailyDataCollection).UpdateOne(ctx, filter, update, opts); err != nil {
+			return dbError(err, "telemetry daily data of %v %v", d.Serial, d.Module)
+		}
+	}
+	return dbError(cur.Err(), "telemetry data on %v", day.Format("2006-01-02"))
+}
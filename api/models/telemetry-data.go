@@ -2,8 +2,8 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -11,34 +11,52 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var telemetryDataCollection = "telemetryData"
 
+// telemetryDataTimeSeries : if the telemetry data is stored in a time-series collection,
+// which does not support unique indexes
+var telemetryDataTimeSeries = false
+
+// SetTelemetryDataTimeSeries : informs the layout of the telemetry data collection
+func SetTelemetryDataTimeSeries(timeSeries bool) {
+	telemetryDataTimeSeries = timeSeries
+}
+
+// TelemetryMeta : the fields that identify the source of a telemetry read
+type TelemetryMeta struct {
+	Serial string `bson:"serial" json:"serial"`
+	Module string `bson:"module" json:"module"`
+}
+
 // TelemetryData : PV system state captured by the data acquisition service
 type TelemetryData struct {
 	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Serial            string             `bson:"serial" json:"serial"`
 	Module            string             `bson:"module" json:"module"`
 	LastTelemetryTime int64              `bson:"lastTelemetryTime" json:"lastTelemetryTime"`
+	TelemetryTime     time.Time          `bson:"telemetryTime" json:"telemetryTime"`
 	OutputVoltage     float64            `bson:"outputVoltage" json:"outputVoltage"`
 	InputVoltage      float64            `bson:"inputVoltage" json:"inputVoltage"`
 	InputCurrent      float64            `bson:"inputCurrent" json:"inputCurrent"`
+	Flags             []string           `bson:"flags,omitempty" json:"flags,omitempty"`
+	Meta              *TelemetryMeta     `bson:"meta,omitempty" json:"-"`
 }
 
 // ListTelemetryData : reads telemetry data from DB using an filter
-func ListTelemetryData(db *mongo.Database, filter bson.M) ([]*TelemetryData, error) {
-	ctx := context.Background()
+func ListTelemetryData(ctx context.Context, db *mongo.Database, filter bson.M) ([]*TelemetryData, error) {
 	cur, err := db.Collection(telemetryDataCollection).Find(ctx, filter)
 	if err != nil {
-		return []*TelemetryData{}, err
+		return []*TelemetryData{}, dbError(err, "telemetry data")
 	}
 	defer cur.Close(ctx)
 	telemetry := []*TelemetryData{}
 	for cur.Next(ctx) {
 		var i TelemetryData
 		if err := cur.Decode(&i); err != nil {
-			return telemetry, err
+			return telemetry, dbError(err, "telemetry data")
 		}
 		telemetry = append(telemetry, &i)
 	}
@@ -46,39 +64,185 @@ func ListTelemetryData(db *mongo.Database, filter bson.M) ([]*TelemetryData, err
 }
 
 // AlreadyAcquired : checks if a given telemetry data is already in the DB
-func (t *TelemetryData) AlreadyAcquired(db *mongo.Database) bool {
-	ctx := context.Background()
+func (t *TelemetryData) AlreadyAcquired(ctx context.Context, db *mongo.Database) (bool, error) {
 	filter := bson.M{
 		"serial":            t.Serial,
 		"lastTelemetryTime": t.LastTelemetryTime,
 	}
-	res := db.Collection(telemetryDataCollection).FindOne(ctx, filter)
-	return !(res.Err() == mongo.ErrNoDocuments)
+	// Time-series collections are only indexed by the meta and time fields
+	if telemetryDataTimeSeries {
+		filter = bson.M{
+			"meta.serial":   t.Serial,
+			"telemetryTime": time.Unix(t.LastTelemetryTime, 0).UTC(),
+		}
+	}
+	n, err := db.Collection(telemetryDataCollection).CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, dbError(err, "telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
+	}
+	return n > 0, nil
+}
+
+// FillDerivedFields : fills the fields that are computed from the acquired ones
+func (t *TelemetryData) FillDerivedFields() {
+	// The date field is the one used by the retention policy
+	t.TelemetryTime = time.Unix(t.LastTelemetryTime, 0).UTC()
+	// The meta field groups the reads of a module in time-series collections
+	if telemetryDataTimeSeries {
+		t.Meta = &TelemetryMeta{
+			Serial: t.Serial,
+			Module: t.Module,
+		}
+	}
 }
 
 // AddDataToDB : adds a telemetry read to the DB
-func (t *TelemetryData) AddDataToDB(db *mongo.Database) (primitive.ObjectID, error) {
-	ctx := context.Background()
+func (t *TelemetryData) AddDataToDB(ctx context.Context, db *mongo.Database) (primitive.ObjectID, error) {
+	t.FillDerivedFields()
+	// Without an unique index, repeated data must be checked before inserting
+	if telemetryDataTimeSeries {
+		acquired, err := t.AlreadyAcquired(ctx, db)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if acquired {
+			return primitive.NilObjectID, Duplicate("telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
+		}
+	}
 	res, err := db.Collection(telemetryDataCollection).InsertOne(ctx, t)
 	if err != nil {
-		return primitive.NilObjectID, err
+		return primitive.NilObjectID, dbError(err, "telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
 	}
 	oid, _ := res.InsertedID.(primitive.ObjectID)
 	return oid, nil
 }
 
+// AddDataBatchToDB : adds many telemetry reads to the DB at once, discarding the repeated ones.
+// Returns how many reads were added.
+func AddDataBatchToDB(ctx context.Context, db *mongo.Database, data []*TelemetryData) (int, error) {
+	// Discards the reads repeated inside the batch
+	seen := map[string]bool{}
+	unique := []*TelemetryData{}
+	for _, t := range data {
+		key := fmt.Sprintf("%v-%v", t.Serial, t.LastTelemetryTime)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		t.FillDerivedFields()
+		unique = append(unique, t)
+	}
+	// Without an unique index, the reads already in the DB are looked up at once
+	if telemetryDataTimeSeries && len(unique) > 0 {
+		acquired, err := alreadyAcquiredBatch(ctx, db, unique)
+		if err != nil {
+			return 0, err
+		}
+		remaining := []*TelemetryData{}
+		for _, t := range unique {
+			if !acquired[fmt.Sprintf("%v-%v", t.Serial, t.TelemetryTime.Unix())] {
+				remaining = append(remaining, t)
+			}
+		}
+		unique = remaining
+	}
+	if len(unique) == 0 {
+		return 0, nil
+	}
+	docs := make([]interface{}, len(unique))
+	for i, t := range unique {
+		docs[i] = t
+	}
+	// The unique index rejects the reads already in the DB without stopping the others
+	opts := options.InsertMany().SetOrdered(false)
+	_, err := db.Collection(telemetryDataCollection).InsertMany(ctx, docs, opts)
+	if err != nil {
+		var bwe mongo.BulkWriteException
+		if IsDuplicateKeyError(err) && errors.As(err, &bwe) {
+			return len(docs) - len(bwe.WriteErrors), nil
+		}
+		return 0, dbError(err, "telemetry data")
+	}
+	return len(docs), nil
+}
+
+// alreadyAcquiredBatch : finds which of the reads are already in a time-series collection
+func alreadyAcquiredBatch(ctx context.Context, db *mongo.Database, data []*TelemetryData) (map[string]bool, error) {
+	or := bson.A{}
+	for _, t := range data {
+		or = append(or, bson.M{
+			"meta.serial":   t.Serial,
+			"telemetryTime": t.TelemetryTime,
+		})
+	}
+	opts := options.Find().SetProjection(bson.M{"meta.serial": 1, "telemetryTime": 1})
+	cur, err := db.Collection(telemetryDataCollection).Find(ctx, bson.M{"$or": or}, opts)
+	if err != nil {
+		return nil, dbError(err, "telemetry data")
+	}
+	defer cur.Close(ctx)
+	acquired := map[string]bool{}
+	for cur.Next(ctx) {
+		var t TelemetryData
+		if err := cur.Decode(&t); err != nil {
+			return nil, dbError(err, "telemetry data")
+		}
+		if t.Meta != nil {
+			acquired[fmt.Sprintf("%v-%v", t.Meta.Serial, t.TelemetryTime.Unix())] = true
+		}
+	}
+	return acquired, dbError(cur.Err(), "telemetry data")
+}
+
 // DeleteDataFromDB : deletes a telemetry read from the DB
-func (t *TelemetryData) DeleteDataFromDB(db *mongo.Database) error {
-	ctx := context.Background()
+func (t *TelemetryData) DeleteDataFromDB(ctx context.Context, db *mongo.Database) error {
 	filter := bson.M{
 		"serial":            t.Serial,
 		"lastTelemetryTime": t.LastTelemetryTime,
 	}
 	res, err := db.Collection(telemetryDataCollection).DeleteOne(ctx, filter)
+	if err != nil {
+		return dbError(err, "telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
+	}
 	if res.DeletedCount < 1 {
-		return fmt.Errorf("Dado de telemetria não encontrado")
+		return NotFound("telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
 	}
-	return err
+	return nil
+}
+
+// ReplaceDataInDB : adds a telemetry read to the DB or replaces the one of the same time, as
+// when parsing it again. Time-series collections do not allow replacing, so the reads of the
+// same module and time are deleted before inserting, which needs MongoDB 7.0 or newer.
+func (t *TelemetryData) ReplaceDataInDB(ctx context.Context, db *mongo.Database) (UpsertResult, error) {
+	t.FillDerivedFields()
+	if telemetryDataTimeSeries {
+		filter := bson.M{
+			"meta.serial":   t.Serial,
+			"meta.module":   t.Module,
+			"telemetryTime": t.TelemetryTime,
+		}
+		res, err := db.Collection(telemetryDataCollection).DeleteMany(ctx, filter)
+		if err != nil {
+			return Unchanged, dbError(err, "telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
+		}
+		if _, err := db.Collection(telemetryDataCollection).InsertOne(ctx, t); err != nil {
+			return Unchanged, dbError(err, "telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
+		}
+		if res.DeletedCount > 0 {
+			return Changed, nil
+		}
+		return Created, nil
+	}
+	filter := bson.M{
+		"serial":            t.Serial,
+		"lastTelemetryTime": t.LastTelemetryTime,
+	}
+	opts := options.Replace().SetUpsert(true)
+	res, err := db.Collection(telemetryDataCollection).ReplaceOne(ctx, filter, t, opts)
+	if err != nil {
+		return Unchanged, dbError(err, "telemetry data of %v at %v", t.Serial, t.LastTelemetryTime)
+	}
+	return upsertResultFrom(res), nil
 }
 
 // FromScrapper : fills the telemetry with data from the HTML scrapper
@@ -95,6 +259,8 @@ func (t *TelemetryData) FromScrapper(e *colly.HTMLElement) error {
 	const OTHER1 = "row no-gutters align-items-center row-margin"
 	const OTHER2 = "col-5 title-font"
 	const OTHER3 = "col-5 setting-font text-right"
+	// The problems found in the page
+	problems := []string{}
 	// Looks in all divs with classes
 	e.ForEach("div[class]", func(_ int, el *colly.HTMLElement) {
 		// Looks for the telemetry serial
@@ -127,6 +293,7 @@ func (t *TelemetryData) FromScrapper(e *colly.HTMLElement) error {
 							layout := "Jan-02-2006, 15:04:05"
 							lt, err := time.Parse(layout, ele.Text)
 							if err != nil {
+								problems = append(problems, fmt.Sprintf("invalid %v: %q", divData, ele.Text))
 								return
 							}
 							t.LastTelemetryTime = lt.Unix()
@@ -134,32 +301,17 @@ func (t *TelemetryData) FromScrapper(e *colly.HTMLElement) error {
 					case "Tensão de Saída":
 						if !foundLastOutputVoltage {
 							foundLastOutputVoltage = true
-							s := strings.Split(ele.Text, " ")
-							if len(s) == 2 {
-								if v, err := strconv.ParseFloat(s[0], 64); err == nil {
-									t.OutputVoltage = v
-								}
-							}
+							t.OutputVoltage = scrapedValue(divData, ele.Text, &problems)
 						}
 					case "Tensão de Entrada":
 						if !foundLastInputVoltage {
 							foundLastInputVoltage = true
-							s := strings.Split(ele.Text, " ")
-							if len(s) == 2 {
-								if v, err := strconv.ParseFloat(s[0], 64); err == nil {
-									t.InputVoltage = v
-								}
-							}
+							t.InputVoltage = scrapedValue(divData, ele.Text, &problems)
 						}
 					case "Corrente de Entrada":
 						if !foundLastInputCurrent {
 							foundLastInputCurrent = true
-							s := strings.Split(ele.Text, " ")
-							if len(s) == 2 {
-								if i, err := strconv.ParseFloat(s[0], 64); err == nil {
-									t.InputCurrent = i
-								}
-							}
+							t.InputCurrent = scrapedValue(divData, ele.Text, &problems)
 						}
 					default:
 					}
@@ -167,5 +319,15 @@ func (t *TelemetryData) FromScrapper(e *colly.HTMLElement) error {
 			})
 		}
 	})
-	return nil
+	if t.Serial == "" {
+		problems = append(problems, "missing serial")
+	}
+	missingValues(map[string]bool{
+		"Módulo":              foundModule,
+		"Última telemetria":   foundLastTelemetry,
+		"Tensão de Saída":     foundLastOutputVoltage,
+		"Tensão de Entrada":   foundLastInputVoltage,
+		"Corrente de Entrada": foundLastInputCurrent,
+	}, &problems)
+	return scrapeError(problems)
 }
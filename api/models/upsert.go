@@ -0,0 +1,36 @@
+package models
+
+import "go.mongodb.org/mongo-driver/mongo"
+
+// UpsertResult : what an upsert did to the document in the DB
+type UpsertResult int
+
+// The possible results of an upsert
+const (
+	Unchanged UpsertResult = iota
+	Created
+	Changed
+)
+
+func (r UpsertResult) String() string {
+	switch r {
+	case Created:
+		return "created"
+	case Changed:
+		return "changed"
+	default:
+		return "unchanged"
+	}
+}
+
+// upsertResultFrom : tells what an update with upsert did from its result
+func upsertResultFrom(res *mongo.UpdateResult) UpsertResult {
+	switch {
+	case res.UpsertedCount > 0:
+		return Created
+	case res.ModifiedCount > 0:
+		return Changed
+	default:
+		return Unchanged
+	}
+}
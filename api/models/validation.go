@@ -0,0 +1,280 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var rejectedDataCollection = "rejectedData"
+
+// The actions taken on values out of their limits
+const (
+	// RejectValue : the read is not stored and its payload is kept for inspection
+	RejectValue = "reject"
+	// FlagValue : the read is stored with the violated limits in its flags
+	FlagValue = "flag"
+)
+
+// The kinds of the validated reads
+const (
+	InverterKind      = "inverter"
+	TelemetryDataKind = "telemetryData"
+)
+
+// FieldLimit : the physical bounds of a field and how fast it may change, in units per second
+type FieldLimit struct {
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	MaxRate float64  `json:"maxRate,omitempty"`
+	Action  string   `json:"action,omitempty"`
+}
+
+// check : validates the action taken on the values of a field out of the limit
+func (l FieldLimit) check(field string) error {
+	switch l.Action {
+	case "", RejectValue, FlagValue:
+		return nil
+	}
+	return fmt.Errorf("%w action of the limit of %v: %v", ErrInvalid, field, l.Action)
+}
+
+// FieldLimits : the limits of the fields of a read, by the bson name of the field
+type FieldLimits map[string]FieldLimit
+
+// ValidationRules : the limits used for all inverters, overridden field by field by the
+// limits of the model of each inverter
+type ValidationRules struct {
+	Default FieldLimits            `json:"default"`
+	Models  map[string]FieldLimits `json:"models"`
+	// Inverters : the model of each inverter serial
+	Inverters map[string]string `json:"inverters"`
+}
+
+// Violation : a field of a read out of its limits
+type Violation struct {
+	Field  string  `bson:"field" json:"field"`
+	Value  float64 `bson:"value" json:"value"`
+	Reason string  `bson:"reason" json:"reason"`
+	Action string  `bson:"action" json:"action"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v %v: %v", v.Field, v.Reason, v.Value)
+}
+
+// RejectedData : a read rejected by the validation, with the page it was scraped from
+type RejectedData struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Kind       string             `bson:"kind" json:"kind"`
+	Serial     string             `bson:"serial" json:"serial"`
+	Module     string             `bson:"module,omitempty" json:"module,omitempty"`
+	ReceivedAt time.Time          `bson:"receivedAt" json:"receivedAt"`
+	Violations []Violation        `bson:"violations" json:"violations"`
+	Data       bson.M             `bson:"data" json:"data"`
+	Payload    string             `bson:"payload" json:"payload"`
+}
+
+func limit(v float64) *float64 {
+	return &v
+}
+
+// DefaultValidationRules : the physical bounds of SolarEdge inverters and optimizers, with the
+// power in kW, the voltages in V, the frequency in Hz and the energies in kWh
+func DefaultValidationRules() *ValidationRules {
+	return &ValidationRules{
+		Default: FieldLimits{
+			"power":           {Min: limit(0), Max: limit(1000)},
+			"voltage":         {Min: limit(0), Max: limit(500)},
+			"frequency":       {Min: limit(0), Max: limit(70)},
+			"energyToday":     {Min: limit(0)},
+			"energyThisMonth": {Min: limit(0)},
+			"energyThisYear":  {Min: limit(0)},
+			"totalEnergy":     {Min: limit(0)},
+			"outputVoltage":   {Min: limit(0), Max: limit(100)},
+			"inputVoltage":    {Min: limit(0), Max: limit(125)},
+			"inputCurrent":    {Min: limit(0), Max: limit(20)},
+		},
+		Models:    map[string]FieldLimits{},
+		Inverters: map[string]string{},
+	}
+}
+
+// ReadValidationFile : reads validation rules from a JSON file, whose default limits are added
+// to the built-in ones field by field. Limits with an unknown action are rejected.
+func ReadValidationFile(path string) (*ValidationRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := ValidationRules{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w validation file: %v", ErrInvalid, err)
+	}
+	r := DefaultValidationRules()
+	for field, l := range file.Default {
+		if err := l.check(field); err != nil {
+			return nil, err
+		}
+		r.Default[field] = l
+	}
+	for model, limits := range file.Models {
+		for field, l := range limits {
+			if err := l.check(model + "." + field); err != nil {
+				return nil, err
+			}
+		}
+		r.Models[model] = limits
+	}
+	for serial, model := range file.Inverters {
+		r.Inverters[serial] = model
+	}
+	return r, nil
+}
+
+// Limits : the limits of a field for an inverter serial
+func (r *ValidationRules) Limits(serial, field string) (FieldLimit, bool) {
+	if model, ok := r.Inverters[serial]; ok {
+		if l, ok := r.Models[model][field]; ok {
+			return l, true
+		}
+	}
+	l, ok := r.Default[field]
+	return l, ok
+}
+
+// Validate : checks the fields of a read against their limits and, when a previous read is
+// given, how fast they changed in the elapsed seconds
+func (r *ValidationRules) Validate(serial string, current, previous map[string]float64, elapsed int64) []Violation {
+	violations := []Violation{}
+	for field, v := range current {
+		l, ok := r.Limits(serial, field)
+		if !ok {
+			continue
+		}
+		action := l.Action
+		if action == "" {
+			action = RejectValue
+		}
+		reason := ""
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			reason = "is not a number"
+		case l.Min != nil && v < *l.Min:
+			reason = fmt.Sprintf("below %v", *l.Min)
+		case l.Max != nil && v > *l.Max:
+			reason = fmt.Sprintf("above %v", *l.Max)
+		case l.MaxRate > 0 && previous != nil && elapsed > 0:
+			if rate := math.Abs(v-previous[field]) / float64(elapsed); rate > l.MaxRate {
+				reason = fmt.Sprintf("changing %.4g/s, above %v/s", rate, l.MaxRate)
+			}
+		}
+		if reason != "" {
+			violations = append(violations, Violation{
+				Field:  field,
+				Value:  v,
+				Reason: reason,
+				Action: action,
+			})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations
+}
+
+// Rejected : checks if any of the violations rejects the read
+func Rejected(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Action == RejectValue {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags : describes the violations that only flag the read
+func Flags(violations []Violation) []string {
+	flags := []string{}
+	for _, v := range violations {
+		if v.Action == FlagValue {
+			flags = append(flags, v.String())
+		}
+	}
+	return flags
+}
+
+// ValidatedFields : the values of the inverter checked by the validation
+func (i *Inverter) ValidatedFields() map[string]float64 {
+	return map[string]float64{
+		"power":           i.Power,
+		"voltage":         i.Voltage,
+		"frequency":       i.Frequency,
+		"energyToday":     i.EnergyToday,
+		"energyThisMonth": i.EnergyThisMonth,
+		"energyThisYear":  i.EnergyThisYear,
+		"totalEnergy":     i.TotalEnergy,
+	}
+}
+
+// ValidatedFields : the values of the telemetry checked by the validation
+func (t *TelemetryData) ValidatedFields() map[string]float64 {
+	return map[string]float64{
+		"outputVoltage": t.OutputVoltage,
+		"inputVoltage":  t.InputVoltage,
+		"inputCurrent":  t.InputCurrent,
+	}
+}
+
+// NewRejectedData : describes a rejected read of a kind, received now
+func NewRejectedData(kind, serial, module string, read interface{}, violations []Violation, payload []byte) *RejectedData {
+	r := RejectedData{
+		Kind:       kind,
+		Serial:     serial,
+		Module:     module,
+		ReceivedAt: time.Now(),
+		Violations: violations,
+		Payload:    string(payload),
+	}
+	if raw, err := bson.Marshal(read); err == nil {
+		bson.Unmarshal(raw, &r.Data)
+	}
+	return &r
+}
+
+// AddRejectedDataToDB : keeps a rejected read in the DB
+func (r *RejectedData) AddRejectedDataToDB(ctx context.Context, db *mongo.Database) (primitive.ObjectID, error) {
+	res, err := db.Collection(rejectedDataCollection).InsertOne(ctx, r)
+	if err != nil {
+		return primitive.NilObjectID, dbError(err, "rejected data of %v", r.Serial)
+	}
+	oid, _ := res.InsertedID.(primitive.ObjectID)
+	return oid, nil
+}
+
+// ListRejectedData : reads rejected reads from DB using an filter, the newest first
+func ListRejectedData(ctx context.Context, db *mongo.Database, filter bson.M) ([]*RejectedData, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "receivedAt", Value: -1}})
+	cur, err := db.Collection(rejectedDataCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return []*RejectedData{}, dbError(err, "rejected data")
+	}
+	defer cur.Close(ctx)
+	rejected := []*RejectedData{}
+	for cur.Next(ctx) {
+		var r RejectedData
+		if err := cur.Decode(&r); err != nil {
+			return rejected, dbError(err, "rejected data")
+		}
+		rejected = append(rejected, &r)
+	}
+	return rejected, nil
+}
@@ -1,14 +1,17 @@
 package seed
 
 import (
+	"context"
+
 	"github.com/rjmalves/cpid-solar-telemetry/api/models"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // LoadInverters : loads the default inverter data into the DB
 func LoadInverters(db *mongo.Database) error {
+	ctx := context.Background()
 	// Only seeds the DB if the collection is empty
-	invs, err := models.ListInverters(db)
+	invs, err := models.ListInverters(ctx, db)
 	if err != nil {
 		return err
 	}
@@ -47,7 +50,7 @@ func LoadInverters(db *mongo.Database) error {
 	}
 	// Adds the inverters to DB
 	for _, inv := range inverters {
-		_, err := inv.AddInverterToDB(db)
+		_, err := inv.AddInverterToDB(ctx, db)
 		if err != nil {
 			return err
 		}
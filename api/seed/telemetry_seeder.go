@@ -1,6 +1,7 @@
 package seed
 
 import (
+	"context"
 	"math/rand"
 
 	"github.com/rjmalves/cpid-solar-telemetry/api/models"
@@ -10,8 +11,9 @@ import (
 
 // LoadTelemetryData : loads a lot of default telemetry data to the DB
 func LoadTelemetryData(db *mongo.Database) error {
+	ctx := context.Background()
 	// Only seeds the DB if the collection is empty
-	tels, err := models.ListTelemetryData(db, bson.M{})
+	tels, err := models.ListTelemetryData(ctx, db, bson.M{})
 	if err != nil {
 		return err
 	}
@@ -40,7 +42,7 @@ func LoadTelemetryData(db *mongo.Database) error {
 		} else {
 			td.Serial = "INVERTER3"
 		}
-		_, err := td.AddDataToDB(db)
+		_, err := td.AddDataToDB(ctx, db)
 		if err != nil {
 			return err
 		}
@@ -1,16 +1,101 @@
 package api
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/rjmalves/cpid-solar-telemetry/api/controllers"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+	"github.com/rjmalves/cpid-solar-telemetry/api/migrations"
+	"github.com/rjmalves/cpid-solar-telemetry/api/models"
+	"github.com/rjmalves/cpid-solar-telemetry/api/simulator"
+	"github.com/rjmalves/cpid-solar-telemetry/api/tracing"
 )
 
 var s = controllers.Server{}
 
+// configureLogging : sets the level and the format of the service messages
+func configureLogging() {
+	if err := logging.Configure(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT")); err != nil {
+		logging.Fatal("Error configuring the logging", logging.Fields{logging.ErrorField: err})
+	}
+}
+
 // Run : launches the service
 func Run() {
+	configureLogging()
+	logging.Info("Starting the service")
+	shutdown, err := tracing.Configure(os.Getenv("TRACING_EXPORTER"), os.Getenv("TRACING_ENDPOINT"))
+	if err != nil {
+		logging.Fatal("Error configuring the tracing", logging.Fields{logging.ErrorField: err})
+	}
+	// Sends the remaining spans on exit
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			logging.Error("Error while sending the spans", logging.Fields{logging.ErrorField: err})
+		}
+	}()
+
+	s.SetRetention(os.Getenv("TELEMETRY_RETENTION_DAYS"),
+		os.Getenv("ROLLUP_RETENTION_DAYS"))
+	s.SetTimeSeries(os.Getenv("TELEMETRY_TIMESERIES"))
+	s.SetTelemetryBatch(os.Getenv("TELEMETRY_BATCH_SIZE"),
+		os.Getenv("TELEMETRY_FLUSH_PERIOD"))
+	s.SetModuleHealth(os.Getenv("MODULE_STALE_AFTER"),
+		os.Getenv("MODULE_MAX_DEVIATION"))
+	if err := s.SetTelemetryDiscovery(os.Getenv("TELEMETRY_DISCOVERY_PATHS"),
+		os.Getenv("TELEMETRY_DISCOVERY_PATTERN")); err != nil {
+		logging.Fatal("Error configuring the telemetry discovery", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetIrradiance(os.Getenv("IRRADIANCE_SOURCE"),
+		os.Getenv("IRRADIANCE_REFERENCE"),
+		os.Getenv("IRRADIANCE_CSV"),
+		os.Getenv("IRRADIANCE_MODULE"),
+		os.Getenv("IRRADIANCE_MODULE_STC_CURRENT")); err != nil {
+		logging.Fatal("Error configuring the irradiance", logging.Fields{logging.ErrorField: err})
+	}
+	s.SetEnergyLedger(os.Getenv("ENERGY_MAX_GAP"))
+	if err := s.SetCompleteness(os.Getenv("TELEMETRY_SAMPLE_PERIOD"),
+		os.Getenv("TELEMETRY_HOURS")); err != nil {
+		logging.Fatal("Error configuring the telemetry completeness", logging.Fields{logging.ErrorField: err})
+	}
+	s.SetAnomalyDetection(os.Getenv("ANOMALY_WINDOW"),
+		os.Getenv("ANOMALY_THRESHOLD"),
+		os.Getenv("ANOMALY_PERSISTENCE"))
+	if err := s.SetValidation(os.Getenv("VALIDATION_FILE")); err != nil {
+		logging.Fatal("Error configuring the validation", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetArchive(os.Getenv("ARCHIVE_MODE"),
+		os.Getenv("ARCHIVE_DIR")); err != nil {
+		logging.Fatal("Error configuring the archive", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetReplay(os.Getenv("REPLAY_DIR"),
+		os.Getenv("REPLAY_SPEED")); err != nil {
+		logging.Fatal("Error configuring the replay", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetAuth(os.Getenv("AUTH_FILE")); err != nil {
+		logging.Fatal("Error configuring the authentication", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetAppURL(os.Getenv("APP_URL")); err != nil {
+		logging.Fatal("Error configuring the app URL", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetTLS(os.Getenv("TLS_FILE")); err != nil {
+		logging.Fatal("Error configuring the TLS settings", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetTargets(os.Getenv("TARGETS_FILE")); err != nil {
+		logging.Fatal("Error configuring the targets", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetReadiness(os.Getenv("READY_MISSED_PERIODS")); err != nil {
+		logging.Fatal("Error configuring the readiness", logging.Fields{logging.ErrorField: err})
+	}
+	s.SetProxyUserHeader(os.Getenv("PROXY_USER_HEADER"))
 
 	if err := s.Initialize(os.Getenv("DB_HOST"),
 		os.Getenv("DB_PORT"),
@@ -19,11 +104,183 @@ func Run() {
 		os.Getenv("DB_DATABASE"),
 		os.Getenv("INVERTER_PATHS"),
 		os.Getenv("TELEMETRY_PATHS")); err != nil {
-		log.Fatalf("Error initializing the service: %v", err)
+		logging.Fatal("Error initializing the service", logging.Fields{logging.ErrorField: err})
+	}
+
+	if err := s.LoadLayoutFile(context.Background(), os.Getenv("LAYOUT_FILE")); err != nil {
+		logging.Fatal("Error loading the plant layout", logging.Fields{logging.ErrorField: err})
 	}
 
-	s.Run(os.Getenv("APP_HOST"),
+	s.Run(os.Getenv("API_PORT"),
+		os.Getenv("APP_HOST"),
 		os.Getenv("APP_PORT"),
 		os.Getenv("INVERTER_ACQ_PERIOD"),
-		os.Getenv("TELEMETRY_ACQ_PERIOD"))
+		os.Getenv("TELEMETRY_ACQ_PERIOD"),
+		os.Getenv("TELEMETRY_DISCOVERY_PERIOD"),
+		os.Getenv("ROLLUP_PERIOD"),
+		os.Getenv("MODULE_HEALTH_PERIOD"),
+		os.Getenv("ANOMALY_PERIOD"))
+}
+
+// Migrate : applies the pending DB migrations without launching the service
+func Migrate(dryRun bool) {
+	configureLogging()
+
+	if err := s.ConnectDB(os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_DATABASE")); err != nil {
+		logging.Fatal("Error connecting to the DB", logging.Fields{logging.ErrorField: err})
+	}
+	defer s.Terminate()
+
+	if err := migrations.Run(context.Background(), s.DB, dryRun); err != nil {
+		logging.Fatal("Error migrating the DB", logging.Fields{logging.ErrorField: err})
+	}
+}
+
+// Completeness : finds the gaps in the telemetry of a day, as 2006-01-02, and prints the
+// completeness of each module and inverter. An empty day is the current one.
+func Completeness(day string) {
+	configureLogging()
+
+	if err := s.SetCompleteness(os.Getenv("TELEMETRY_SAMPLE_PERIOD"),
+		os.Getenv("TELEMETRY_HOURS")); err != nil {
+		logging.Fatal("Error configuring the telemetry completeness", logging.Fields{logging.ErrorField: err})
+	}
+	d := time.Now()
+	if day != "" {
+		var err error
+		if d, err = time.Parse("2006-01-02", day); err != nil {
+			logging.Fatal("Error parsing the day", logging.Fields{logging.ErrorField: err})
+		}
+	}
+
+	if err := s.ConnectDB(os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_DATABASE")); err != nil {
+		logging.Fatal("Error connecting to the DB", logging.Fields{logging.ErrorField: err})
+	}
+	defer s.Terminate()
+
+	report, err := s.UpdateCompleteness(context.Background(), models.StartOfDay(d))
+	if err != nil {
+		logging.Fatal("Error finding the telemetry gaps", logging.Fields{logging.ErrorField: err})
+	}
+	controllers.PrintCompleteness(report)
+}
+
+// Reprocess : parses again the archived pages of a kind fetched between two days, as
+// 2006-01-02, rebuilding the data parsed from them. An empty kind reprocesses all the pages.
+func Reprocess(kind, from, to string) {
+	configureLogging()
+
+	s.SetRetention(os.Getenv("TELEMETRY_RETENTION_DAYS"),
+		os.Getenv("ROLLUP_RETENTION_DAYS"))
+	if err := s.SetValidation(os.Getenv("VALIDATION_FILE")); err != nil {
+		logging.Fatal("Error configuring the validation", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetIrradiance(os.Getenv("IRRADIANCE_SOURCE"),
+		os.Getenv("IRRADIANCE_REFERENCE"),
+		os.Getenv("IRRADIANCE_CSV"),
+		os.Getenv("IRRADIANCE_MODULE"),
+		os.Getenv("IRRADIANCE_MODULE_STC_CURRENT")); err != nil {
+		logging.Fatal("Error configuring the irradiance", logging.Fields{logging.ErrorField: err})
+	}
+	s.SetEnergyLedger(os.Getenv("ENERGY_MAX_GAP"))
+	if err := s.SetCompleteness(os.Getenv("TELEMETRY_SAMPLE_PERIOD"),
+		os.Getenv("TELEMETRY_HOURS")); err != nil {
+		logging.Fatal("Error configuring the telemetry completeness", logging.Fields{logging.ErrorField: err})
+	}
+	if err := s.SetArchive(os.Getenv("ARCHIVE_MODE"),
+		os.Getenv("ARCHIVE_DIR")); err != nil {
+		logging.Fatal("Error configuring the archive", logging.Fields{logging.ErrorField: err})
+	}
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		logging.Fatal("Error parsing the first day", logging.Fields{logging.ErrorField: err})
+	}
+	end := time.Now()
+	if to != "" {
+		if end, err = time.Parse("2006-01-02", to); err != nil {
+			logging.Fatal("Error parsing the last day", logging.Fields{logging.ErrorField: err})
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if err := s.ConnectDB(os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_DATABASE")); err != nil {
+		logging.Fatal("Error connecting to the DB", logging.Fields{logging.ErrorField: err})
+	}
+	defer s.Terminate()
+	if err := s.InitializeArchive(); err != nil {
+		logging.Fatal("Error opening the archive", logging.Fields{logging.ErrorField: err})
+	}
+	ts, err := s.IsTelemetryDataTimeSeries(context.Background())
+	if err != nil {
+		logging.Fatal("Error reading the telemetry data collection", logging.Fields{logging.ErrorField: err})
+	}
+	models.SetTelemetryDataTimeSeries(ts)
+
+	n, err := s.Reprocess(context.Background(), kind, start, end)
+	if err != nil {
+		logging.Fatal("Error reprocessing the archived pages", logging.Fields{logging.ErrorField: err})
+	}
+	logging.Info("Reprocessed the archived pages", logging.Fields{"pages": n})
+}
+
+// Simulate : serves a simulated SetApp device in a port, from the model of a JSON file. An
+// empty file simulates the default model.
+func Simulate(modelFile, port string) {
+	configureLogging()
+
+	m := simulator.DefaultModel()
+	if modelFile != "" {
+		var err error
+		if m, err = simulator.ReadModelFile(modelFile); err != nil {
+			logging.Fatal("Error reading the simulator model", logging.Fields{logging.ErrorField: err})
+		}
+	}
+	sim, err := simulator.New(m)
+	if err != nil {
+		logging.Fatal("Error creating the simulator", logging.Fields{logging.ErrorField: err})
+	}
+	logging.Info("Simulating a device", logging.Fields{"inverters": m.Inverters, "modules": m.Modules,
+		"port": port})
+	if err := sim.Router.Run(":" + port); err != nil {
+		logging.Fatal("Error serving the simulator", logging.Fields{logging.ErrorField: err})
+	}
+}
+
+// Probe : asks the service API if it is healthy or ready, exiting with an error status when
+// not, as a Docker HEALTHCHECK. An empty URL asks the liveness of the API at the API_PORT, as
+// the readiness also fails on remote faults, as an unreachable device, that a restart does not
+// fix.
+func Probe(url string, timeout time.Duration) {
+
+	if url == "" {
+		if os.Getenv("API_PORT") == "" {
+			fmt.Println("The API is not served: API_PORT is not set")
+			os.Exit(1)
+		}
+		url = fmt.Sprintf("http://localhost:%v/healthz", os.Getenv("API_PORT"))
+	}
+	client := http.Client{Timeout: timeout}
+	res, err := client.Get(url)
+	if err != nil {
+		fmt.Printf("Error probing %v: %v\n", url, err)
+		os.Exit(1)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	fmt.Println(strings.TrimSpace(string(body)))
+	if res.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
 }
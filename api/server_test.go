@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"testing"
@@ -8,14 +9,69 @@ import (
 	"github.com/rjmalves/cpid-solar-telemetry/api/tests"
 )
 
+// ts : the static test server, where the tests may inject faults
+var ts = tests.StaticServer{}
+
 func TestMain(m *testing.M) {
 
 	// Starts the static test server
-	var ts = tests.StaticServer{}
 	ts.Initialize()
 	ts.Run(os.Getenv("APP_PORT"))
 
 	// Initializes the scrapper
+	s.SetRetention(os.Getenv("TELEMETRY_RETENTION_DAYS"),
+		os.Getenv("ROLLUP_RETENTION_DAYS"))
+	s.SetTimeSeries(os.Getenv("TELEMETRY_TIMESERIES"))
+	s.SetTelemetryBatch(os.Getenv("TELEMETRY_BATCH_SIZE"),
+		os.Getenv("TELEMETRY_FLUSH_PERIOD"))
+	s.SetModuleHealth(os.Getenv("MODULE_STALE_AFTER"),
+		os.Getenv("MODULE_MAX_DEVIATION"))
+	if err := s.SetTelemetryDiscovery(os.Getenv("TELEMETRY_DISCOVERY_PATHS"),
+		os.Getenv("TELEMETRY_DISCOVERY_PATTERN")); err != nil {
+		log.Fatalf("Error configuring the telemetry discovery: %v", err)
+	}
+	if err := s.SetIrradiance(os.Getenv("IRRADIANCE_SOURCE"),
+		os.Getenv("IRRADIANCE_REFERENCE"),
+		os.Getenv("IRRADIANCE_CSV"),
+		os.Getenv("IRRADIANCE_MODULE"),
+		os.Getenv("IRRADIANCE_MODULE_STC_CURRENT")); err != nil {
+		log.Fatalf("Error configuring the irradiance: %v", err)
+	}
+	s.SetEnergyLedger(os.Getenv("ENERGY_MAX_GAP"))
+	if err := s.SetCompleteness(os.Getenv("TELEMETRY_SAMPLE_PERIOD"),
+		os.Getenv("TELEMETRY_HOURS")); err != nil {
+		log.Fatalf("Error configuring the telemetry completeness: %v", err)
+	}
+	s.SetAnomalyDetection(os.Getenv("ANOMALY_WINDOW"),
+		os.Getenv("ANOMALY_THRESHOLD"),
+		os.Getenv("ANOMALY_PERSISTENCE"))
+	if err := s.SetValidation(os.Getenv("VALIDATION_FILE")); err != nil {
+		log.Fatalf("Error configuring the validation: %v", err)
+	}
+	if err := s.SetArchive(os.Getenv("ARCHIVE_MODE"),
+		os.Getenv("ARCHIVE_DIR")); err != nil {
+		log.Fatalf("Error configuring the archive: %v", err)
+	}
+	if err := s.SetReplay(os.Getenv("REPLAY_DIR"),
+		os.Getenv("REPLAY_SPEED")); err != nil {
+		log.Fatalf("Error configuring the replay: %v", err)
+	}
+	if err := s.SetAuth(os.Getenv("AUTH_FILE")); err != nil {
+		log.Fatalf("Error configuring the authentication: %v", err)
+	}
+	if err := s.SetAppURL(os.Getenv("APP_URL")); err != nil {
+		log.Fatalf("Error configuring the app URL: %v", err)
+	}
+	if err := s.SetTLS(os.Getenv("TLS_FILE")); err != nil {
+		log.Fatalf("Error configuring the TLS settings: %v", err)
+	}
+	if err := s.SetTargets(os.Getenv("TARGETS_FILE")); err != nil {
+		log.Fatalf("Error configuring the targets: %v", err)
+	}
+	if err := s.SetReadiness(os.Getenv("READY_MISSED_PERIODS")); err != nil {
+		log.Fatalf("Error configuring the readiness: %v", err)
+	}
+	s.SetProxyUserHeader(os.Getenv("PROXY_USER_HEADER"))
 	if err := s.Initialize(os.Getenv("DB_HOST"),
 		os.Getenv("DB_PORT"),
 		os.Getenv("DB_USER"),
@@ -25,6 +81,12 @@ func TestMain(m *testing.M) {
 		os.Getenv("TELEMETRY_PATHS")); err != nil {
 		log.Fatalf("Error initializing the service: %v", err)
 	}
+	if err := s.SetPathTargets(fmt.Sprintf("http://%v:%v/", os.Getenv("APP_HOST"), os.Getenv("APP_PORT")),
+		os.Getenv("INVERTER_ACQ_PERIOD"),
+		os.Getenv("TELEMETRY_ACQ_PERIOD"),
+		os.Getenv("TELEMETRY_DISCOVERY_PERIOD")); err != nil {
+		log.Fatalf("Error configuring the targets: %v", err)
+	}
 
 	ret := m.Run()
 
@@ -0,0 +1,322 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// The kinds of the simulated faults
+const (
+	// FaultOffline : the inverter loses the server communication and stops producing, or the
+	// module stops reporting, keeping its last read
+	FaultOffline = "offline"
+	// FaultDerate : the power of the inverter or the current of the module is multiplied by
+	// the factor of the fault
+	FaultDerate = "derate"
+	// FaultHTTP : the pages of the inverter or module are answered with the status of the fault
+	FaultHTTP = "http"
+)
+
+// The markups of the simulated pages
+const (
+	// FirmwareLegacy : the markup of the SetApp version the parsers were written for
+	FirmwareLegacy = "1_5_16"
+	// FirmwareNew : the same pages with renamed classes, as a newer firmware might serve
+	FirmwareNew = "2_0_0"
+)
+
+// Fault : a misbehavior of an inverter or of one of its modules in a time interval
+type Fault struct {
+	Kind string `json:"kind"`
+	// Inverter : the index of the inverter, from 1, where zero is all of them
+	Inverter int `json:"inverter"`
+	// Module : the index of the module in the inverter, from 1, where zero is the inverter
+	// itself and all its modules
+	Module int       `json:"module"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Factor float64   `json:"factor"`
+	Status int       `json:"status"`
+}
+
+// Model : the simulated plant, where every inverter follows the same clear-sky day curve
+type Model struct {
+	Inverters int `json:"inverters"`
+	// Modules : the modules of each inverter
+	Modules int `json:"modules"`
+	// PeakPower : the power of each inverter at noon, in kW
+	PeakPower float64 `json:"peakPower"`
+	// ModuleCurrent, ModuleVoltage : the input of each module at noon, in A and V
+	ModuleCurrent float64 `json:"moduleCurrent"`
+	ModuleVoltage float64 `json:"moduleVoltage"`
+	// Sunrise, Sunset : the hours of the device clock when the production starts and ends
+	Sunrise float64 `json:"sunrise"`
+	Sunset  float64 `json:"sunset"`
+	// TelemetryPeriod : the seconds between the reports of each module
+	TelemetryPeriod int64 `json:"telemetryPeriod"`
+	// Timezone : the location of the device clock, where empty is the local one
+	Timezone string `json:"timezone"`
+	// Start : when the energy counters started, with the InitialEnergy in kWh
+	Start         time.Time `json:"start"`
+	InitialEnergy float64   `json:"initialEnergy"`
+	// CounterResets : when the total energy counters restart from zero
+	CounterResets []time.Time `json:"counterResets"`
+	// Latency, Jitter : the delay of every answer and its random addition, in milliseconds
+	Latency int64 `json:"latency"`
+	Jitter  int64 `json:"jitter"`
+	// ErrorRate : the fraction of the requests answered with an internal error
+	ErrorRate float64 `json:"errorRate"`
+	Firmware  string  `json:"firmware"`
+	Seed      int64   `json:"seed"`
+	Faults    []Fault `json:"faults"`
+	location  *time.Location
+}
+
+// DefaultModel : a small plant of one inverter with three modules, without faults
+func DefaultModel() *Model {
+	m := Model{
+		Inverters:       1,
+		Modules:         3,
+		PeakPower:       10,
+		ModuleCurrent:   9,
+		ModuleVoltage:   40,
+		Sunrise:         6,
+		Sunset:          18,
+		TelemetryPeriod: 300,
+		Firmware:        FirmwareLegacy,
+		Seed:            1,
+	}
+	m.Start = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	m.location = time.Local
+	return &m
+}
+
+// ReadModelFile : reads a model from a JSON file, where the missing fields keep the values of
+// the default model
+func ReadModelFile(path string) (*Model, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := DefaultModel()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Check : verifies the model and loads its timezone
+func (m *Model) Check() error {
+	if m.Inverters < 1 || m.Modules < 1 {
+		return fmt.Errorf("the model needs at least one inverter and one module")
+	}
+	if m.Sunrise < 0 || m.Sunset > 24 || m.Sunrise >= m.Sunset {
+		return fmt.Errorf("invalid sunrise and sunset: %v and %v", m.Sunrise, m.Sunset)
+	}
+	if m.TelemetryPeriod <= 0 {
+		return fmt.Errorf("invalid telemetry period: %v", m.TelemetryPeriod)
+	}
+	if m.Firmware != FirmwareLegacy && m.Firmware != FirmwareNew {
+		return fmt.Errorf("unknown firmware: %v", m.Firmware)
+	}
+	for _, f := range m.Faults {
+		if f.Kind != FaultOffline && f.Kind != FaultDerate && f.Kind != FaultHTTP {
+			return fmt.Errorf("unknown fault: %v", f.Kind)
+		}
+		if f.Kind == FaultHTTP && (f.Status < 100 || f.Status > 599) {
+			return fmt.Errorf("invalid status of the http fault: %v", f.Status)
+		}
+	}
+	m.location = time.Local
+	if m.Timezone != "" {
+		loc, err := time.LoadLocation(m.Timezone)
+		if err != nil {
+			return err
+		}
+		m.location = loc
+	}
+	return nil
+}
+
+// InverterSerial : the serial of an inverter, by its index from 1
+func (m *Model) InverterSerial(inverter int) string {
+	return serial(0x7E1504FE + uint32(inverter-1))
+}
+
+// ModuleSerial : the serial of a module of an inverter, by their indexes from 1
+func (m *Model) ModuleSerial(inverter, module int) string {
+	return serial(0x11F3EF00 + uint32((inverter-1)<<8) + uint32(module-1))
+}
+
+// serial : formats a number as the SetApp serials, with a checksum byte
+func serial(n uint32) string {
+	sum := byte(n) + byte(n>>8) + byte(n>>16) + byte(n>>24)
+	return fmt.Sprintf("%08X-%02X", n, sum)
+}
+
+// hours : the hours of a time in the device clock since the device midnight
+func (m *Model) hours(t time.Time) float64 {
+	l := t.In(m.location)
+	return float64(l.Hour()) + float64(l.Minute())/60 + float64(l.Second())/3600
+}
+
+// day : the number of the day of a time in the device clock
+func (m *Model) day(t time.Time) int64 {
+	l := t.In(m.location)
+	return time.Date(l.Year(), l.Month(), l.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// Sun : the fraction of the peak produced at a time, following half a sine wave from the
+// sunrise to the sunset
+func (m *Model) Sun(t time.Time) float64 {
+	h := m.hours(t)
+	if h <= m.Sunrise || h >= m.Sunset {
+		return 0
+	}
+	return math.Sin(math.Pi * (h - m.Sunrise) / (m.Sunset - m.Sunrise))
+}
+
+// dayEnergy : the energy of an inverter from the device midnight to a time, in kWh
+func (m *Model) dayEnergy(t time.Time) float64 {
+	d := m.Sunset - m.Sunrise
+	h := math.Min(math.Max(m.hours(t), m.Sunrise), m.Sunset)
+	return m.PeakPower * d / math.Pi * (1 - math.Cos(math.Pi*(h-m.Sunrise)/d))
+}
+
+// energySince : the energy of an inverter from the start of the counters to a time, in kWh
+func (m *Model) energySince(t time.Time) float64 {
+	if t.Before(m.Start) {
+		return 0
+	}
+	full := m.PeakPower * 2 * (m.Sunset - m.Sunrise) / math.Pi
+	return float64(m.day(t)-m.day(m.Start))*full + m.dayEnergy(t) - m.dayEnergy(m.Start)
+}
+
+// fault : the active fault of a kind for an inverter or module at a time, if any
+func (m *Model) fault(kind string, inverter, module int, t time.Time) (Fault, bool) {
+	for _, f := range m.Faults {
+		if f.Kind != kind || t.Before(f.From) || (!f.To.IsZero() && !t.Before(f.To)) {
+			continue
+		}
+		if f.Inverter != 0 && f.Inverter != inverter {
+			continue
+		}
+		if f.Module != 0 && f.Module != module {
+			continue
+		}
+		return f, true
+	}
+	return Fault{}, false
+}
+
+// InverterState : the values shown by the page of an inverter
+type InverterState struct {
+	Serial          string
+	Power           float64
+	Voltage         float64
+	Frequency       float64
+	Communication   bool
+	Producing       bool
+	EnergyToday     float64
+	EnergyThisMonth float64
+	EnergyThisYear  float64
+	TotalEnergy     float64
+}
+
+// Inverter : the state of an inverter at a time. The energy counters follow the day curve,
+// regardless of the faults.
+func (m *Model) Inverter(inverter int, t time.Time) InverterState {
+	i := InverterState{
+		Serial:        m.InverterSerial(inverter),
+		Voltage:       277,
+		Frequency:     60,
+		Communication: true,
+	}
+	sun := m.Sun(t)
+	i.Power = m.PeakPower * sun
+	if f, ok := m.fault(FaultDerate, inverter, 0, t); ok {
+		i.Power *= f.Factor
+	}
+	if _, ok := m.fault(FaultOffline, inverter, 0, t); ok {
+		i.Communication = false
+		i.Power = 0
+	}
+	i.Producing = i.Power > 0
+	l := t.In(m.location)
+	month := time.Date(l.Year(), l.Month(), 1, 0, 0, 0, 0, m.location)
+	year := time.Date(l.Year(), time.January, 1, 0, 0, 0, 0, m.location)
+	total := m.energySince(t)
+	i.EnergyToday = m.dayEnergy(t)
+	i.EnergyThisMonth = total - m.energySince(month)
+	i.EnergyThisYear = total - m.energySince(year)
+	i.TotalEnergy = m.InitialEnergy + total
+	for _, r := range m.CounterResets {
+		if !r.After(t) {
+			i.TotalEnergy = total - m.energySince(r)
+		}
+	}
+	return i
+}
+
+// ModuleState : the values shown by the telemetry page of a module
+type ModuleState struct {
+	Serial        string
+	Module        string
+	LastTelemetry time.Time
+	OutputVoltage float64
+	InputVoltage  float64
+	InputCurrent  float64
+}
+
+// lastReport : the last time a module reported before a time. The modules report every
+// telemetry period, with their own offset, and never at night.
+func (m *Model) lastReport(inverter, module int, t time.Time) time.Time {
+	offset := int64((inverter*31 + module*37) % int(m.TelemetryPeriod))
+	last := func(t time.Time) time.Time {
+		u := t.Unix() - offset
+		return time.Unix(u-u%m.TelemetryPeriod+offset, 0)
+	}
+	r := last(t)
+	if m.Sun(r) == 0 {
+		l := r.In(m.location)
+		sunset := time.Date(l.Year(), l.Month(), l.Day(), 0, 0, 0, 0, m.location).
+			Add(time.Duration(m.Sunset * float64(time.Hour)))
+		if m.hours(r) < m.Sunrise {
+			sunset = sunset.AddDate(0, 0, -1)
+		}
+		r = last(sunset.Add(-time.Second))
+	}
+	return r
+}
+
+// Module : the last read of a module of an inverter at a time
+func (m *Model) Module(inverter, module int, t time.Time) ModuleState {
+	if f, ok := m.fault(FaultOffline, inverter, module, t); ok {
+		t = f.From.Add(-time.Second)
+	}
+	r := m.lastReport(inverter, module, t)
+	s := ModuleState{
+		Serial:        m.InverterSerial(inverter),
+		Module:        m.ModuleSerial(inverter, module),
+		LastTelemetry: r,
+		OutputVoltage: 1,
+	}
+	sun := m.Sun(r)
+	if sun == 0 {
+		return s
+	}
+	// Each module is a bit weaker than the previous one, as real ones differ
+	s.InputCurrent = m.ModuleCurrent * sun * (1 - 0.01*float64((module-1)%5))
+	if f, ok := m.fault(FaultDerate, inverter, module, r); ok {
+		s.InputCurrent *= f.Factor
+	}
+	s.InputVoltage = m.ModuleVoltage * (0.9 + 0.1*sun)
+	s.OutputVoltage = s.InputVoltage * 0.98
+	return s
+}
@@ -0,0 +1,166 @@
+package simulator
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+// classes : the class names of the page elements that the parsers look for
+type classes struct {
+	InverterSerial string
+	SerialSpan     string
+	Row            string
+	SingleRow      string
+	Cell           string
+	SingleCell     string
+	Label          string
+	Value          string
+	TelemetryHead  string
+	TelemetrySpan  string
+	TelemetryRow   string
+	TelemetryLabel string
+	TelemetryValue string
+}
+
+// firmwareClasses : the class names of each firmware markup
+var firmwareClasses = map[string]classes{
+	FirmwareLegacy: {
+		InverterSerial: "text-center title-vertical-padding black-font ",
+		SerialSpan:     "grey-strong-class font-14",
+		Row:            "row no-gutters white-cover text-center weak-border-top",
+		SingleRow:      "row no-gutters align-items-center white-cover text-center weak-border-top",
+		Cell:           "container-vertical-padding",
+		SingleCell:     "container-vertical-padding-single",
+		Label:          "grey-strong-class",
+		Value:          "font-16 grey-primary-font bold",
+		TelemetryHead:  "row heading no-gutters justify-content-center",
+		TelemetrySpan:  "heading-info-font",
+		TelemetryRow:   "row no-gutters align-items-center row-margin",
+		TelemetryLabel: "col-5 title-font",
+		TelemetryValue: "col-5 setting-font text-right",
+	},
+	FirmwareNew: {
+		InverterSerial: "text-center title-padding dark-font",
+		SerialSpan:     "label-strong font-sm",
+		Row:            "row g-0 cover text-center border-top",
+		SingleRow:      "row g-0 align-items-center cover text-center border-top",
+		Cell:           "container-padding",
+		SingleCell:     "container-padding-single",
+		Label:          "label-strong",
+		Value:          "font-md primary-font bold",
+		TelemetryHead:  "row heading g-0 justify-content-center",
+		TelemetrySpan:  "heading-info",
+		TelemetryRow:   "row g-0 align-items-center",
+		TelemetryLabel: "col-5 title",
+		TelemetryValue: "col-5 setting text-right",
+	},
+}
+
+// field : a label and its value in a page
+type field struct {
+	Label string
+	Value string
+}
+
+var inverterPage = template.Must(template.New("inverter").Parse(`<!DOCTYPE html>
+<html lang="en"><head>
+<meta charset="utf-8">
+<script type="text/javascript">var APP_VERSION = "{{.Firmware}}";</script>
+<title>Inverter SetApp</title>
+</head>
+<body>
+<div id="root"><div>
+<div class="{{.C.InverterSerial}}"><span class="blue-primary-class font-24">Inversor</span><br><span class="{{.C.SerialSpan}}">Serial {{.Serial}}</span></div>
+{{range .Rows}}<div class="{{$.C.Row}}">{{range .}}<div class="col-4"><div class="{{$.C.Cell}}"><span class="{{$.C.Label}}">{{.Label}}</span><br><span class="{{$.C.Value}}">{{.Value}}</span></div></div>{{end}}</div>
+{{end}}<div class="{{.C.SingleRow}}"><div class="col-12"><div class="{{.C.SingleCell}}"><span class="{{.C.Label}}">{{.Total.Label}}</span><br><span class="{{.C.Value}}">{{.Total.Value}}</span></div></div></div>
+</div></div>
+</body>
+</html>
+`))
+
+var telemetryPage = template.Must(template.New("telemetry").Parse(`<!DOCTYPE html>
+<html lang="en"><head>
+<meta charset="utf-8">
+<script type="text/javascript">var APP_VERSION = "{{.Firmware}}";</script>
+<title>Inverter SetApp</title>
+</head>
+<body>
+<div id="root"><div>
+<div class="{{.C.TelemetryHead}}"><div class="col-10"><span class="heading-font">Status da Telemetria</span><br><span class="{{.C.TelemetrySpan}}">Serial {{.Serial}}</span></div></div>
+{{range .Fields}}<div class="container list-group-item info-block"><div class="{{$.C.TelemetryRow}}"><div class="{{$.C.TelemetryLabel}}">{{.Label}}</div><div class="col-1"></div><div class="{{$.C.TelemetryValue}}">{{.Value}}</div></div></div>
+{{end}}</div></div>
+</body>
+</html>
+`))
+
+var indexPage = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SetApp</title>
+</head>
+<body>
+<div id="root">
+{{range .}}<a href="{{.Value}}">{{.Label}}</a>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// renderInverter : writes the page of an inverter state in the markup of a firmware
+func renderInverter(w io.Writer, firmware string, i InverterState) error {
+	comm := "S_OK"
+	if !i.Communication {
+		comm = "S_ERR_SERVER"
+	}
+	status := "Produção"
+	if !i.Producing {
+		status = "Modo Noturno"
+	}
+	return inverterPage.Execute(w, map[string]interface{}{
+		"Firmware": firmware,
+		"C":        firmwareClasses[firmware],
+		"Serial":   i.Serial,
+		"Rows": [][]field{
+			{
+				{"Potência", format(i.Power, "kW")},
+				{"Tensão", format(i.Voltage, "Vac")},
+				{"Frequência", format(i.Frequency, "Hz")},
+			},
+			{
+				{"Comunic. c/ Servidor.", comm},
+				{"Status", status},
+				{"Chave está", "On"},
+			},
+			{
+				{"Hoje", format(i.EnergyToday, "kWh")},
+				{"Este Mês", format(i.EnergyThisMonth, "kWh")},
+				{"Este Ano", format(i.EnergyThisYear, "kWh")},
+			},
+		},
+		"Total": field{"Total", format(i.TotalEnergy, "kWh")},
+	})
+}
+
+// renderModule : writes the telemetry page of a module state in the markup of a firmware and
+// in a device clock
+func renderModule(w io.Writer, firmware string, loc *time.Location, m ModuleState) error {
+	return telemetryPage.Execute(w, map[string]interface{}{
+		"Firmware": firmware,
+		"C":        firmwareClasses[firmware],
+		"Serial":   m.Serial,
+		"Fields": []field{
+			{"Módulo", m.Module},
+			{"Última telemetria", m.LastTelemetry.In(loc).Format("Jan-02-2006, 15:04:05")},
+			{"Tensão de Saída", format(m.OutputVoltage, "Vdc")},
+			{"Tensão de Entrada", format(m.InputVoltage, "Vdc")},
+			{"Corrente de Entrada", format(m.InputCurrent, "A")},
+		},
+	})
+}
+
+// renderIndex : writes a page linking to the telemetry pages, with the module serials as text
+func renderIndex(w io.Writer, links []field) error {
+	return indexPage.Execute(w, links)
+}
@@ -0,0 +1,169 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rjmalves/cpid-solar-telemetry/api/logging"
+)
+
+// Simulator : serves SetApp pages rendered from a model of the plant, in place of a device
+type Simulator struct {
+	Model  *Model
+	Router *gin.Engine
+	// Now : the clock of the simulated device, which tests may replace
+	Now   func() time.Time
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// New : creates the simulator of a model
+func New(m *Model) (*Simulator, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	s := Simulator{
+		Model: m,
+		Now:   time.Now,
+		rand:  rand.New(rand.NewSource(m.Seed)),
+	}
+	gin.SetMode(gin.ReleaseMode)
+	s.Router = gin.New()
+	s.Router.Use(gin.Recovery(), s.misbehave)
+	s.initializeRoutes()
+	return &s, nil
+}
+
+// Run : serves the simulated device in a port, in background
+func (s *Simulator) Run(port string) {
+	go func() {
+		if err := s.Router.Run(":" + port); err != nil {
+			logging.Fatal("Error serving the simulator", logging.Fields{logging.ErrorField: err})
+		}
+	}()
+}
+
+func (s *Simulator) initializeRoutes() {
+	// The paths are the same of the static test server, with the first inverter and module
+	// in the bare paths and the others numbered from 1
+	s.Router.GET("/inverter/*index", s.inverter)
+	s.Router.GET("/telemetry-data/*index", s.module)
+	s.Router.GET("/telemetry-index/", s.index)
+}
+
+// format : writes a value with its unit, as the SetApp pages do
+func format(v float64, unit string) string {
+	return fmt.Sprintf("%v %v", strconv.FormatFloat(v, 'f', 2, 64), unit)
+}
+
+// pathIndex : reads the index of a path parameter, where empty is the first one
+func pathIndex(c *gin.Context) (int, bool) {
+	p := strings.Trim(c.Param("index"), "/")
+	if p == "" {
+		return 1, true
+	}
+	n, err := strconv.Atoi(p)
+	return n, err == nil && n > 0
+}
+
+// moduleIndex : the inverter and module of a telemetry page index, numbered across inverters
+func (s *Simulator) moduleIndex(n int) (int, int) {
+	return (n-1)/s.Model.Modules + 1, (n-1)%s.Model.Modules + 1
+}
+
+// misbehave : delays the answers and fails some of them, as set in the model
+func (s *Simulator) misbehave(c *gin.Context) {
+	s.mutex.Lock()
+	delay := s.Model.Latency
+	if s.Model.Jitter > 0 {
+		delay += s.rand.Int63n(s.Model.Jitter)
+	}
+	fail := s.rand.Float64() < s.Model.ErrorRate
+	s.mutex.Unlock()
+	time.Sleep(time.Duration(delay) * time.Millisecond)
+	if fail {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	// Fails the pages of the inverters and modules with HTTP faults
+	inverter, module := 0, 0
+	path := strings.Trim(c.Request.URL.Path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	n := 1
+	if len(parts) == 2 {
+		if i, err := strconv.Atoi(parts[1]); err == nil {
+			n = i
+		}
+	}
+	switch parts[0] {
+	case "inverter":
+		inverter = n
+	case "telemetry-data":
+		inverter, module = s.moduleIndex(n)
+	}
+	if inverter > 0 {
+		if f, ok := s.Model.fault(FaultHTTP, inverter, module, s.Now()); ok {
+			c.AbortWithStatus(f.Status)
+			return
+		}
+	}
+	c.Next()
+}
+
+// page : answers with a rendered page, or with an error if it could not be rendered
+func page(c *gin.Context, render func(*bytes.Buffer) error) {
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (s *Simulator) inverter(c *gin.Context) {
+	n, ok := pathIndex(c)
+	if !ok || n > s.Model.Inverters {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	i := s.Model.Inverter(n, s.Now())
+	page(c, func(buf *bytes.Buffer) error {
+		return renderInverter(buf, s.Model.Firmware, i)
+	})
+}
+
+func (s *Simulator) module(c *gin.Context) {
+	n, ok := pathIndex(c)
+	if !ok || n > s.Model.Inverters*s.Model.Modules {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	inverter, module := s.moduleIndex(n)
+	m := s.Model.Module(inverter, module, s.Now())
+	page(c, func(buf *bytes.Buffer) error {
+		return renderModule(buf, s.Model.Firmware, s.Model.location, m)
+	})
+}
+
+func (s *Simulator) index(c *gin.Context) {
+	links := []field{}
+	for i := 1; i <= s.Model.Inverters; i++ {
+		for m := 1; m <= s.Model.Modules; m++ {
+			n := (i-1)*s.Model.Modules + m
+			links = append(links, field{
+				Label: s.Model.ModuleSerial(i, m),
+				Value: fmt.Sprintf("/telemetry-data/%v/", n),
+			})
+		}
+	}
+	page(c, func(buf *bytes.Buffer) error {
+		return renderIndex(buf, links)
+	})
+}
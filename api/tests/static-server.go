@@ -1,20 +1,53 @@
 package tests
 
 import (
+	"io/ioutil"
 	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// assetsDir : the directory of the served pages, relative to the go app launch
+const assetsDir = "./tests/assets"
+
+// Fault : a misbehavior injected in the answers of a route of the static server
+type Fault struct {
+	// Delay : how long to wait before answering
+	Delay time.Duration
+	// Status : the status code answered instead of the page, if not zero
+	Status int
+	// Truncate : the bytes of the page answered, if not zero
+	Truncate int
+	// Classes : the class names replaced in the page, as old to new
+	Classes map[string]string
+	// Garbage : replaces the numbers of the values shown with their units by garbage text
+	Garbage bool
+}
+
+// alters : checks if the fault changes the content of the page
+func (f Fault) alters() bool {
+	return f.Truncate > 0 || len(f.Classes) > 0 || f.Garbage
+}
+
 // StaticServer : the static file server used for testing the scrapper
 type StaticServer struct {
 	Router *gin.Engine
+	mutex  sync.RWMutex
+	faults map[string]Fault
 }
 
 // Initialize : configures the static server for testing
 func (s *StaticServer) Initialize() {
 	gin.SetMode(gin.ReleaseMode)
 	s.Router = gin.Default()
+	s.faults = map[string]Fault{}
+	s.Router.Use(s.injectFaults)
 	s.initializeRoutes()
 }
 
@@ -35,4 +68,64 @@ func (s *StaticServer) initializeRoutes() {
 	// In release, should be ./api/tests/assets/...
 	s.Router.Static("/telemetry-data", "./tests/assets/telemetry-data")
 	s.Router.Static("/inverter", "./tests/assets/inverter")
+	s.Router.Static("/telemetry-index", "./tests/assets/telemetry-index")
+}
+
+// SetFault : injects a fault in the answers of a route, as "inverter", until it is cleared
+func (s *StaticServer) SetFault(route string, f Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults[route] = f
+}
+
+// ClearFaults : answers all the routes normally again
+func (s *StaticServer) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = map[string]Fault{}
+}
+
+// garbageValue : the values shown with their units, as "31.81 kW"
+var garbageValue = regexp.MustCompile(`>-?[0-9][0-9.]* (kW|Vac|Hz|kWh|Vdc|A)<`)
+
+// injectFaults : answers the routes with faults as set, reading the altered pages from the assets
+func (s *StaticServer) injectFaults(c *gin.Context) {
+	route := strings.SplitN(strings.Trim(c.Request.URL.Path, "/"), "/", 2)[0]
+	s.mutex.RLock()
+	f, ok := s.faults[route]
+	s.mutex.RUnlock()
+	if !ok {
+		c.Next()
+		return
+	}
+	time.Sleep(f.Delay)
+	if f.Status != 0 {
+		c.AbortWithStatus(f.Status)
+		return
+	}
+	if !f.alters() {
+		c.Next()
+		return
+	}
+	file := filepath.Join(assetsDir, filepath.FromSlash(c.Request.URL.Path))
+	if strings.HasSuffix(c.Request.URL.Path, "/") {
+		file = filepath.Join(file, "index.html")
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	page := string(content)
+	for old, renamed := range f.Classes {
+		page = strings.Replace(page, `class="`+old+`"`, `class="`+renamed+`"`, -1)
+	}
+	if f.Garbage {
+		page = garbageValue.ReplaceAllString(page, ">1.2.3 $1<")
+	}
+	if f.Truncate > 0 && f.Truncate < len(page) {
+		page = page[:f.Truncate]
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	c.Abort()
 }
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CommandMonitor : records a span for each command sent to MongoDB, as a child of the span in
+// the context of the operation, named after the collection and the command, as "inverters.update"
+func CommandMonitor() *event.CommandMonitor {
+	spans := sync.Map{}
+	key := func(connection string, request int64) string {
+		return fmt.Sprintf("%v/%v", connection, request)
+	}
+	finish := func(e event.CommandFinishedEvent, err error) {
+		k := key(e.ConnectionID, e.RequestID)
+		if s, ok := spans.Load(k); ok {
+			spans.Delete(k)
+			span := s.(trace.Span)
+			Fail(span, err)
+			span.End()
+		}
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			name := e.CommandName
+			attrs := []attribute.KeyValue{
+				semconv.DBSystemMongoDB,
+				semconv.DBNameKey.String(e.DatabaseName),
+				semconv.DBOperationKey.String(e.CommandName),
+			}
+			if elem, err := e.Command.IndexErr(0); err == nil {
+				if collection, ok := elem.Value().StringValueOK(); ok {
+					name = collection + "." + name
+					attrs = append(attrs, semconv.DBMongoDBCollectionKey.String(collection))
+				}
+			}
+			_, span := Start(ctx, name, attrs...)
+			spans.Store(key(e.ConnectionID, e.RequestID), span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finish(e.CommandFinishedEvent, nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finish(e.CommandFinishedEvent, errors.New(e.Failure))
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName : the name of the service in the exported spans
+const serviceName = "cpid-solar-telemetry"
+
+// defaultEndpoint : the OTLP/HTTP endpoint of a collector in the same host
+const defaultEndpoint = "localhost:4318"
+
+// The exporters of the spans
+const (
+	// NoExporter : the spans are not recorded
+	NoExporter = ""
+	// StdoutExporter : the spans are written as JSON to the standard output
+	StdoutExporter = "stdout"
+	// OTLPExporter : the spans are sent to an OpenTelemetry collector, over OTLP/HTTP
+	OTLPExporter = "otlp"
+)
+
+// The attributes shared by the spans of the service
+const (
+	URLKey    = attribute.Key("url")
+	KindKey   = attribute.Key("kind")
+	TargetKey = attribute.Key("target")
+	SerialKey = attribute.Key("serial")
+	ModuleKey = attribute.Key("module")
+)
+
+// Configure : records the spans of the service with an exporter, where the OTLP one sends them
+// to a collector endpoint, as "localhost:4318". Returns the function that sends the remaining
+// spans before the service exits.
+func Configure(exporter, endpoint string) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case NoExporter:
+		return func(context.Context) error { return nil }, nil
+	case StdoutExporter:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case OTLPExporter:
+		if endpoint == "" {
+			endpoint = defaultEndpoint
+		}
+		exp, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpoint(endpoint),
+			otlptracehttp.WithInsecure())
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %v", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	Use(exp)
+	return Shutdown, nil
+}
+
+// Use : records the spans of the service with an exporter, in batches
+func Use(exp sdktrace.SpanExporter) {
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+}
+
+// Flush : sends the spans that ended
+func Flush(ctx context.Context) error {
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		return tp.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// Shutdown : sends the remaining spans and stops recording them
+func Shutdown(ctx context.Context) error {
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		return tp.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Start : starts a span as a child of the one in a context, which is not recorded when the
+// tracing is not configured
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(serviceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked : starts a span that works for the spans in some contexts, as a write of the
+// reads of many scrapes
+func StartLinked(ctx context.Context, name string, linked []context.Context, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	links := []trace.Link{}
+	for _, l := range linked {
+		if sc := trace.SpanContextFromContext(l); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return otel.Tracer(serviceName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithLinks(links...))
+}
+
+// Fail : marks a span as failed with an error, if any
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Track : runs a step in a span, which fails with the step
+func Track(ctx context.Context, name string, step func() error, attrs ...attribute.KeyValue) error {
+	_, span := Start(ctx, name, attrs...)
+	defer span.End()
+	err := step()
+	Fail(span, err)
+	return err
+}
@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// URLPrefix : the pages under an URL, as "http://172.16.0.1/inverter/". The scheme, host and
+// port must be the same and the path must start with the whole segments of the prefix path, so
+// "http://172.16.0.1" is not a prefix of "http://172.16.0.10/".
+type URLPrefix struct {
+	scheme   string
+	host     string
+	segments []string
+}
+
+// hostPort : the host of an URL in lower case with its port, which is the default one of the
+// scheme when not given
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return strings.ToLower(u.Hostname()) + ":" + port
+}
+
+// pathSegments : the segments of an URL path, ignoring the leading and trailing slashes
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// ParseURLPrefix : reads an URL prefix, which must have a scheme and a host
+func ParseURLPrefix(raw string) (*URLPrefix, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL prefix: %v", raw)
+	}
+	return &URLPrefix{
+		scheme:   strings.ToLower(u.Scheme),
+		host:     hostPort(u),
+		segments: pathSegments(u.Path),
+	}, nil
+}
+
+// Matches : checks if an URL is under the prefix
+func (p *URLPrefix) Matches(u *url.URL) bool {
+	if strings.ToLower(u.Scheme) != p.scheme || hostPort(u) != p.host {
+		return false
+	}
+	segments := pathSegments(u.Path)
+	if len(segments) < len(p.segments) {
+		return false
+	}
+	for i, s := range p.segments {
+		if segments[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+// LongestMatch : the index of the prefix with the most path segments that an URL is under,
+// or -1 when none is. Nil prefixes are skipped.
+func LongestMatch(prefixes []*URLPrefix, u *url.URL) int {
+	found := -1
+	for i, p := range prefixes {
+		if p != nil && p.Matches(u) && (found < 0 || len(p.segments) > len(prefixes[found].segments)) {
+			found = i
+		}
+	}
+	return found
+}
+
+// TLSTarget : how the HTTPS pages under an URL are trusted, and the certificate presented to them
+type TLSTarget struct {
+	URL string `json:"url"`
+	// CAFile : a PEM bundle of the authorities trusted besides the system ones
+	CAFile string `json:"caFile,omitempty"`
+	// Fingerprint : the SHA-256 of the device certificate, in hex, which is trusted even if
+	// self-signed or issued for another name, and no other one is
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// CertFile, KeyFile : the PEM client certificate and key, for devices that ask for one
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ServerName : the name verified in the device certificate, when not the one in the URL
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// TLSConfig : the TLS settings of each target, by the URL prefix of its pages
+type TLSConfig struct {
+	Targets []*TLSTarget `json:"targets"`
+}
+
+// ReadTLSFile : reads the TLS settings of the targets from a JSON file
+func ReadTLSFile(path string) (*TLSConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := TLSConfig{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Fingerprint : the SHA-256 of a DER certificate, in hex
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint : a fingerprint in lower case hex, without the colons of some tools
+func normalizeFingerprint(f string) string {
+	return strings.ToLower(strings.Replace(f, ":", "", -1))
+}
+
+// tlsConfig : the TLS settings of the requests to a target
+func (t *TLSTarget) tlsConfig() (*tls.Config, error) {
+	c := &tls.Config{ServerName: t.ServerName}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", t.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	if t.Fingerprint != "" {
+		// The chain is not verified, only the pinned certificate
+		pinned := normalizeFingerprint(t.Fingerprint)
+		c.InsecureSkipVerify = true
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("no certificate presented")
+			}
+			if f := Fingerprint(rawCerts[0]); f != pinned {
+				return fmt.Errorf("certificate fingerprint %v is not the pinned one", f)
+			}
+			return nil
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// newTransport : a transport with the settings of the default one and a TLS configuration
+func newTransport(c *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       c,
+	}
+}
+
+// Router : sends the requests of each target through a transport with its TLS settings
+type Router struct {
+	targets    []*TLSTarget
+	prefixes   []*URLPrefix
+	transports map[string]http.RoundTripper
+	// Default : the transport of the requests to other URLs
+	Default http.RoundTripper
+}
+
+// New : creates the router of the TLS settings of the targets
+func New(c *TLSConfig) (*Router, error) {
+	r := Router{
+		targets:    []*TLSTarget{},
+		prefixes:   []*URLPrefix{},
+		transports: map[string]http.RoundTripper{},
+		Default:    http.DefaultTransport,
+	}
+	if c == nil {
+		return &r, nil
+	}
+	for _, t := range c.Targets {
+		p, err := ParseURLPrefix(t.URL)
+		if err != nil {
+			return nil, fmt.Errorf("TLS settings of %v: %v", t.URL, err)
+		}
+		tc, err := t.tlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("TLS settings of %v: %v", t.URL, err)
+		}
+		r.targets = append(r.targets, t)
+		r.prefixes = append(r.prefixes, p)
+		r.transports[t.URL] = newTransport(tc)
+	}
+	return &r, nil
+}
+
+// RoundTrip : sends a request through the transport of the longest URL prefix of its target
+func (r *Router) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := LongestMatch(r.prefixes, req.URL)
+	if i < 0 {
+		return r.Default.RoundTrip(req)
+	}
+	return r.transports[r.targets[i].URL].RoundTrip(req)
+}
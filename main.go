@@ -1,12 +1,57 @@
 package main
 
 import (
-	"fmt"
+	"flag"
+	"os"
+	"time"
 
 	"github.com/rjmalves/cpid-solar-telemetry/api"
 )
 
 func main() {
-	fmt.Println("TO VIVO")
+	// Runs the DB migrations only, if asked
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "only prints what the pending migrations would change")
+		fs.Parse(os.Args[2:])
+		api.Migrate(*dryRun)
+		return
+	}
+	// Prints the telemetry completeness of a day only, if asked
+	if len(os.Args) > 1 && os.Args[1] == "completeness" {
+		fs := flag.NewFlagSet("completeness", flag.ExitOnError)
+		day := fs.String("day", "", "the day of the device clock, as 2006-01-02 (default today)")
+		fs.Parse(os.Args[2:])
+		api.Completeness(*day)
+		return
+	}
+	// Parses again the archived pages only, if asked
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+		kind := fs.String("kind", "", "the kind of the pages, inverter or telemetryData (default all)")
+		from := fs.String("from", "", "the first day of the pages, as 2006-01-02")
+		to := fs.String("to", "", "the last day of the pages, as 2006-01-02 (default today)")
+		fs.Parse(os.Args[2:])
+		api.Reprocess(*kind, *from, *to)
+		return
+	}
+	// Serves a simulated device only, if asked
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+		model := fs.String("model", "", "the JSON file of the simulated plant (default one inverter)")
+		port := fs.String("port", "8080", "the port of the simulated device")
+		fs.Parse(os.Args[2:])
+		api.Simulate(*model, *port)
+		return
+	}
+	// Asks the running service if it is healthy or ready only, if asked
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		fs := flag.NewFlagSet("probe", flag.ExitOnError)
+		url := fs.String("url", "", "the health or readiness URL (default the /healthz of the API_PORT)")
+		timeout := fs.Duration("timeout", 5*time.Second, "how long to wait for the answer")
+		fs.Parse(os.Args[2:])
+		api.Probe(*url, *timeout)
+		return
+	}
 	api.Run()
 }